@@ -1,62 +1,137 @@
 package main
 
 import (
-	"log"
+	"context"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"awesomeProject/internal/analytics"
 	"awesomeProject/pkg/config"
+	"awesomeProject/pkg/health"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/metrics"
 	"awesomeProject/pkg/postgres"
 	"awesomeProject/pkg/rabbitmq"
+	"awesomeProject/pkg/tracing"
 )
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("[Analytics] Starting analytics-consumer...")
-
 	cfg := config.Load()
+	logger := logging.Setup("analytics-consumer", cfg.LogFormat, cfg.LogLevel)
+	logger.Info("starting analytics-consumer")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "analytics-consumer", cfg.TracingExporter, cfg.TracingFile)
+	if err != nil {
+		logging.Fatal(logger, "failed to set up tracing", logging.KeyError, err)
+	}
 
 	// Connect to PostgreSQL
 	db, err := postgres.Connect(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("[Analytics] Failed to connect to PostgreSQL: %v", err)
+		logging.Fatal(logger, "failed to connect to PostgreSQL", logging.KeyError, err)
 	}
 	defer db.Close()
 
 	// Run migrations
 	if err := postgres.RunMigrations(db, "analytics"); err != nil {
-		log.Fatalf("[Analytics] Failed to run migrations: %v", err)
+		logging.Fatal(logger, "failed to run migrations", logging.KeyError, err)
 	}
 
 	// Connect to RabbitMQ
 	rmqConn, err := rabbitmq.Connect(cfg.RabbitMQURL)
 	if err != nil {
-		log.Fatalf("[Analytics] Failed to connect to RabbitMQ: %v", err)
+		logging.Fatal(logger, "failed to connect to RabbitMQ", logging.KeyError, err)
 	}
 	defer rmqConn.Close()
 
+	// Create publisher for result events
+	publisher, err := rabbitmq.NewPublisher(rmqConn)
+	if err != nil {
+		logging.Fatal(logger, "failed to create publisher", logging.KeyError, err)
+	}
+	defer publisher.Close()
+
 	// Create consumer
 	consumer := analytics.NewConsumer(db)
+	consumer.Publisher = publisher
 
-	consumerCfg := rabbitmq.ConsumerConfig{
-		QueueName:    "analytics.user.events",
-		DLQName:      "dlq.analytics.user.events",
-		RoutingKeys:  []string{"user.created", "user.updated", "user.deleted"},
-		ConsumerName: "analytics-consumer",
+	topology, err := rabbitmq.LoadTopology(cfg.RabbitMQTopologyFile)
+	if err != nil {
+		logging.Fatal(logger, "failed to load RabbitMQ topology", logging.KeyError, err)
+	}
+	consumerCfg, err := topology.Consumer("analytics-consumer")
+	if err != nil {
+		logging.Fatal(logger, "invalid RabbitMQ topology for consumer", logging.KeyError, err)
 	}
+	consumerCfg.BatchSize = cfg.BatchSize
+	consumerCfg.BatchTimeout = cfg.BatchTimeout
 
-	if err := rabbitmq.SetupConsumer(rmqConn, consumerCfg, consumer.HandleMessage); err != nil {
-		log.Fatalf("[Analytics] Failed to setup consumer: %v", err)
+	var amqpConsumer *rabbitmq.Consumer
+	if cfg.BatchSize > 1 {
+		amqpConsumer, err = rabbitmq.SetupBatchConsumer(rmqConn, consumerCfg, consumer.HandleBatch)
+	} else {
+		amqpConsumer, err = rabbitmq.SetupConsumer(rmqConn, consumerCfg, consumer.HandleMessage)
 	}
+	if err != nil {
+		logging.Fatal(logger, "failed to setup consumer", logging.KeyError, err)
+	}
+
+	logger.Info("consumer is running, waiting for messages")
 
-	log.Println("[Analytics] Consumer is running. Waiting for messages...")
+	// Metrics listener
+	metricsSrv := metrics.NewServer(":" + cfg.MetricsPort)
+	go func() {
+		logger.Info("metrics listening", "port", cfg.MetricsPort)
+		if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(logger, "metrics server error", logging.KeyError, err)
+		}
+	}()
+
+	// Health listener
+	checker := health.NewChecker()
+	checker.AddLiveness("consumer", amqpConsumer.Alive)
+	checker.Add("postgres", postgres.Check(db))
+	checker.Add("rabbitmq", rmqConn.Check)
+	checker.Add("publisher", publisher.Check)
+	checker.Add("queue", amqpConsumer.Check(cfg.HealthMaxQueueMessages))
+	healthSrv := health.NewServer(":"+cfg.HealthPort, checker)
+	go func() {
+		logger.Info("health listening", "port", cfg.HealthPort)
+		if err := healthSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(logger, "health server error", logging.KeyError, err)
+		}
+	}()
+
+	// Query API server
+	srv := &http.Server{
+		Addr:    ":" + cfg.AnalyticsPort,
+		Handler: analytics.NewRouter(analytics.NewQueryHandler(db)),
+	}
+
+	go func() {
+		logger.Info("query API listening", "port", cfg.AnalyticsPort)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(logger, "server error", logging.KeyError, err)
+		}
+	}()
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("[Analytics] Shutting down...")
+	logger.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("query API forced to shutdown", logging.KeyError, err)
+	}
+	_ = metricsSrv.Shutdown(ctx)
+	_ = healthSrv.Shutdown(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("failed to flush traces", logging.KeyError, err)
+	}
 }
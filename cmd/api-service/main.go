@@ -2,19 +2,23 @@ package main
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	_ "awesomeProject/docs"
 	"awesomeProject/internal/api"
 	"awesomeProject/pkg/config"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+	"awesomeProject/pkg/models"
 	"awesomeProject/pkg/postgres"
 	"awesomeProject/pkg/rabbitmq"
-
-	_ "awesomeProject/docs"
+	"awesomeProject/pkg/saga"
+	"awesomeProject/pkg/syncstatus"
+	"awesomeProject/pkg/tracing"
 )
 
 // @title           Event-Driven User API
@@ -23,52 +27,203 @@ import (
 // @host            localhost:8080
 // @BasePath        /
 // @schemes         http
+// @securityDefinitions.apikey  ApiKeyAuth
+// @in                          header
+// @name                        X-API-Key
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("[API] Starting api-service...")
-
 	cfg := config.Load()
+	logger := logging.Setup("api-service", cfg.LogFormat, cfg.LogLevel)
+	logger.Info("starting api-service")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "api-service", cfg.TracingExporter, cfg.TracingFile)
+	if err != nil {
+		logging.Fatal(logger, "failed to set up tracing", logging.KeyError, err)
+	}
 
 	// Connect to PostgreSQL
 	db, err := postgres.Connect(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("[API] Failed to connect to PostgreSQL: %v", err)
+		logging.Fatal(logger, "failed to connect to PostgreSQL", logging.KeyError, err)
 	}
 	defer db.Close()
 
 	// Run migrations
 	if err := postgres.RunMigrations(db, "api"); err != nil {
-		log.Fatalf("[API] Failed to run migrations: %v", err)
+		logging.Fatal(logger, "failed to run migrations", logging.KeyError, err)
 	}
 
 	// Connect to RabbitMQ
 	rmqConn, err := rabbitmq.Connect(cfg.RabbitMQURL)
 	if err != nil {
-		log.Fatalf("[API] Failed to connect to RabbitMQ: %v", err)
+		logging.Fatal(logger, "failed to connect to RabbitMQ", logging.KeyError, err)
 	}
 	defer rmqConn.Close()
 
 	// Create publisher
 	publisher, err := rabbitmq.NewPublisher(rmqConn)
 	if err != nil {
-		log.Fatalf("[API] Failed to create publisher: %v", err)
+		logging.Fatal(logger, "failed to create publisher", logging.KeyError, err)
 	}
 	defer publisher.Close()
 
 	// Setup handlers and router
 	handler := api.NewUserHandler(db, publisher)
+	handler.Health.Add("rabbitmq", rmqConn.Check)
+	handler.Health.Add("publisher", publisher.Check)
+	handler.FoldPlusAddressing = cfg.EmailFoldPlus
+	handler.IdempotencyTTL = cfg.IdempotencyTTL
+	handler.PendingReminderAfter = cfg.PendingReminderAfter
+	if cfg.AttributeSchemaFile != "" {
+		data, err := os.ReadFile(cfg.AttributeSchemaFile)
+		if err != nil {
+			logging.Fatal(logger, "failed to read attribute schema", logging.KeyError, err)
+		}
+		handler.AttributeSchema, err = models.ParseAttributeSchema(data)
+		if err != nil {
+			logging.Fatal(logger, "failed to load attribute schema", logging.KeyError, err)
+		}
+		logger.Info("loaded user attribute rules", "rules", len(handler.AttributeSchema.Rules), "file", cfg.AttributeSchemaFile)
+	}
+	if cfg.AuthEnabled {
+		handler.Authenticators = []middleware.Authenticator{&middleware.APIKeyAuthenticator{DB: db}}
+		if cfg.JWKSFile != "" {
+			keys, err := middleware.LoadJWKS(cfg.JWKSFile)
+			if err != nil {
+				logging.Fatal(logger, "failed to load JWKS", logging.KeyError, err)
+			}
+			handler.Authenticators = append(handler.Authenticators, &middleware.JWTAuthenticator{
+				Keys:     keys,
+				Issuer:   cfg.JWTIssuer,
+				Audience: cfg.JWTAudience,
+				Leeway:   time.Minute,
+			})
+		}
+		logger.Info("authentication enabled", "authenticators", len(handler.Authenticators))
+	}
+	handler.ReadLimit = middleware.PerMinute(cfg.RateLimitReadPerMinute)
+	handler.WriteLimit = middleware.PerMinute(cfg.RateLimitWritePerMinute)
+	switch cfg.RateLimitStore {
+	case "memory":
+		handler.RateLimitStore = middleware.NewMemoryRateLimitStore()
+	case "postgres":
+		handler.RateLimitStore = &middleware.PostgresRateLimitStore{DB: db}
+	case "off":
+	default:
+		logging.Fatal(logger, "RATE_LIMIT_STORE must be memory, postgres or off", "rate_limit_store", cfg.RateLimitStore)
+	}
 	router := api.NewRouter(handler)
 
+	// Fail import jobs whose process died before finishing them
+	if n, err := handler.FailInterruptedImports(context.Background()); err != nil {
+		logger.Error("error failing interrupted import jobs", logging.KeyError, err)
+	} else if n > 0 {
+		logger.Warn("failed interrupted import jobs", "jobs", n)
+	}
+
+	topology, err := rabbitmq.LoadTopology(cfg.RabbitMQTopologyFile)
+	if err != nil {
+		logging.Fatal(logger, "failed to load RabbitMQ topology", logging.KeyError, err)
+	}
+
+	// Record the consumers' result events for /users/:id/sync-status
+	syncCfg, err := topology.Consumer("api-sync-status", syncstatus.RoutingKeys...)
+	if err != nil {
+		logging.Fatal(logger, "invalid RabbitMQ topology for consumer", logging.KeyError, err)
+	}
+	syncConsumer, err := rabbitmq.SetupConsumer(rmqConn, syncCfg, handler.SyncStatus.HandleMessage)
+	if err != nil {
+		logging.Fatal(logger, "failed to setup sync status consumer", logging.KeyError, err)
+	}
+	handler.Health.AddLiveness("sync_status_consumer", syncConsumer.Alive)
+
+	// Run the onboarding saga. Step events handled before their saga starts
+	// are kept until it does.
+	handler.Sagas = saga.NewEngine(db, handler.Onboarding(cfg.OnboardingStepTimeout))
+	sagaCfg, err := topology.Consumer("api-sagas", handler.Sagas.RoutingKeys()...)
+	if err != nil {
+		logging.Fatal(logger, "invalid RabbitMQ topology for consumer", logging.KeyError, err)
+	}
+	sagaConsumer, err := rabbitmq.SetupConsumer(rmqConn, sagaCfg, handler.Sagas.HandleMessage)
+	if err != nil {
+		logging.Fatal(logger, "failed to setup saga consumer", logging.KeyError, err)
+	}
+	handler.Health.AddLiveness("saga_consumer", sagaConsumer.Alive)
+
+	// Send user.reminder messages published by the scheduler
+	reminderCfg, err := topology.Consumer("api-reminders", api.ReminderRoutingKeys...)
+	if err != nil {
+		logging.Fatal(logger, "invalid RabbitMQ topology for consumer", logging.KeyError, err)
+	}
+	reminderConsumer, err := rabbitmq.SetupConsumer(rmqConn, reminderCfg, handler.HandleReminder)
+	if err != nil {
+		logging.Fatal(logger, "failed to setup reminder consumer", logging.KeyError, err)
+	}
+	handler.Health.AddLiveness("reminder_consumer", reminderConsumer.Alive)
+
+	// Fail saga steps whose event is overdue
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n, err := handler.Sagas.ExpireSteps(context.Background()); err != nil {
+				logger.Error("error expiring saga steps", logging.KeyError, err)
+			} else if n > 0 {
+				logger.Info("expired saga steps", "steps", n)
+			}
+		}
+	}()
+
+	// Publish scheduled messages (e.g. user.reminder) once due
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n, err := handler.Scheduler.PublishDue(context.Background()); err != nil {
+				logger.Error("error publishing scheduled messages", logging.KeyError, err)
+			} else if n > 0 {
+				logger.Info("published scheduled messages", "messages", n)
+			}
+		}
+	}()
+
 	// HTTP server with graceful shutdown
 	srv := &http.Server{
 		Addr:    ":" + cfg.APIPort,
 		Handler: router,
 	}
 
+	// Drop expired Idempotency-Key responses, early saga events no saga
+	// claimed and idle shared rate limit buckets
 	go func() {
-		log.Printf("[API] Listening on port %s", cfg.APIPort)
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			n, err := middleware.PurgeIdempotencyKeys(db, cfg.IdempotencyTTL)
+			if err != nil {
+				logger.Error("error purging idempotency keys", logging.KeyError, err)
+			} else if n > 0 {
+				logger.Info("purged expired idempotency keys", "keys", n)
+			}
+			if n, err := handler.Sagas.PurgeEarlyEvents(context.Background(), 24*time.Hour); err != nil {
+				logger.Error("error purging early saga events", logging.KeyError, err)
+			} else if n > 0 {
+				logger.Info("purged early saga events", "events", n)
+			}
+			if cfg.RateLimitStore == "postgres" {
+				if _, err := middleware.PurgeRateLimitBuckets(db, time.Hour); err != nil {
+					logger.Error("error purging rate limit buckets", logging.KeyError, err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		logger.Info("listening", "port", cfg.APIPort)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("[API] Server error: %v", err)
+			logging.Fatal(logger, "server error", logging.KeyError, err)
 		}
 	}()
 
@@ -77,11 +232,17 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("[API] Shutting down server...")
+	logger.Info("shutting down server")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("[API] Server forced to shutdown: %v", err)
+		logging.Fatal(logger, "server forced to shutdown", logging.KeyError, err)
+	}
+	if err := handler.StopImports(ctx); err != nil {
+		logger.Error("import jobs did not stop in time", logging.KeyError, err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("failed to flush traces", logging.KeyError, err)
 	}
-	log.Println("[API] Server exited gracefully")
+	logger.Info("server exited gracefully")
 }
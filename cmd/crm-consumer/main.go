@@ -1,62 +1,113 @@
 package main
 
 import (
-	"log"
+	"context"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"awesomeProject/internal/crm"
 	"awesomeProject/pkg/config"
+	"awesomeProject/pkg/health"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/metrics"
 	"awesomeProject/pkg/postgres"
 	"awesomeProject/pkg/rabbitmq"
+	"awesomeProject/pkg/tracing"
 )
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("[CRM] Starting crm-consumer...")
-
 	cfg := config.Load()
+	logger := logging.Setup("crm-consumer", cfg.LogFormat, cfg.LogLevel)
+	logger.Info("starting crm-consumer")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "crm-consumer", cfg.TracingExporter, cfg.TracingFile)
+	if err != nil {
+		logging.Fatal(logger, "failed to set up tracing", logging.KeyError, err)
+	}
 
 	// Connect to PostgreSQL
 	db, err := postgres.Connect(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("[CRM] Failed to connect to PostgreSQL: %v", err)
+		logging.Fatal(logger, "failed to connect to PostgreSQL", logging.KeyError, err)
 	}
 	defer db.Close()
 
 	// Run migrations
 	if err := postgres.RunMigrations(db, "crm"); err != nil {
-		log.Fatalf("[CRM] Failed to run migrations: %v", err)
+		logging.Fatal(logger, "failed to run migrations", logging.KeyError, err)
 	}
 
 	// Connect to RabbitMQ
 	rmqConn, err := rabbitmq.Connect(cfg.RabbitMQURL)
 	if err != nil {
-		log.Fatalf("[CRM] Failed to connect to RabbitMQ: %v", err)
+		logging.Fatal(logger, "failed to connect to RabbitMQ", logging.KeyError, err)
 	}
 	defer rmqConn.Close()
 
+	// Create publisher for result events
+	publisher, err := rabbitmq.NewPublisher(rmqConn)
+	if err != nil {
+		logging.Fatal(logger, "failed to create publisher", logging.KeyError, err)
+	}
+	defer publisher.Close()
+
 	// Create consumer
 	consumer := crm.NewConsumer(db)
+	consumer.Publisher = publisher
 
-	consumerCfg := rabbitmq.ConsumerConfig{
-		QueueName:    "crm.user.events",
-		DLQName:      "dlq.crm.user.events",
-		RoutingKeys:  []string{"user.created", "user.updated", "user.deleted"},
-		ConsumerName: "crm-consumer",
+	topology, err := rabbitmq.LoadTopology(cfg.RabbitMQTopologyFile)
+	if err != nil {
+		logging.Fatal(logger, "failed to load RabbitMQ topology", logging.KeyError, err)
+	}
+	consumerCfg, err := topology.Consumer("crm-consumer")
+	if err != nil {
+		logging.Fatal(logger, "invalid RabbitMQ topology for consumer", logging.KeyError, err)
 	}
 
-	if err := rabbitmq.SetupConsumer(rmqConn, consumerCfg, consumer.HandleMessage); err != nil {
-		log.Fatalf("[CRM] Failed to setup consumer: %v", err)
+	amqpConsumer, err := rabbitmq.SetupConsumer(rmqConn, consumerCfg, consumer.HandleMessage)
+	if err != nil {
+		logging.Fatal(logger, "failed to setup consumer", logging.KeyError, err)
 	}
 
-	log.Println("[CRM] Consumer is running. Waiting for messages...")
+	logger.Info("consumer is running, waiting for messages")
+
+	// Metrics listener
+	metricsSrv := metrics.NewServer(":" + cfg.MetricsPort)
+	go func() {
+		logger.Info("metrics listening", "port", cfg.MetricsPort)
+		if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(logger, "metrics server error", logging.KeyError, err)
+		}
+	}()
+
+	// Health listener
+	checker := health.NewChecker()
+	checker.AddLiveness("consumer", amqpConsumer.Alive)
+	checker.Add("postgres", postgres.Check(db))
+	checker.Add("rabbitmq", rmqConn.Check)
+	checker.Add("publisher", publisher.Check)
+	checker.Add("queue", amqpConsumer.Check(cfg.HealthMaxQueueMessages))
+	healthSrv := health.NewServer(":"+cfg.HealthPort, checker)
+	go func() {
+		logger.Info("health listening", "port", cfg.HealthPort)
+		if err := healthSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal(logger, "health server error", logging.KeyError, err)
+		}
+	}()
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("[CRM] Shutting down...")
+	logger.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = healthSrv.Shutdown(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("failed to flush traces", logging.KeyError, err)
+	}
 }
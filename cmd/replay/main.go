@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"awesomeProject/internal/analytics"
+	"awesomeProject/internal/crm"
+	"awesomeProject/internal/replay"
+	"awesomeProject/pkg/config"
+	"awesomeProject/pkg/correlation"
+	"awesomeProject/pkg/eventstore"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/postgres"
+	"awesomeProject/pkg/rabbitmq"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// target is a consumer that can be rebuilt by replaying events.
+type target interface {
+	HandleMessage(ctx context.Context, delivery amqp.Delivery) error
+	Reset(ctx context.Context) error
+}
+
+func main() {
+	cfg := config.Load()
+	logger := logging.Setup("replay", cfg.LogFormat, cfg.LogLevel)
+
+	targetName := flag.String("target", "", "consumer to rebuild: crm or analytics (required)")
+	source := flag.String("source", "store", "event source: store (api_db events table) or file")
+	file := flag.String("file", "", "NDJSON export file when -source=file (- for stdin)")
+	afterSeq := flag.Int64("after-seq", 0, "only replay store events with sequence greater than this")
+	mode := flag.String("mode", "publish", "publish (to replay.<target>.* routing keys) or direct (call HandleMessage in-process)")
+	reset := flag.Bool("reset", false, "delete the target's projection and idempotency keys before replaying")
+	types := flag.String("types", "", "comma-separated event types to replay (default all)")
+	dryRun := flag.Bool("dry-run", false, "read and filter events without replaying them")
+	flag.Parse()
+
+	if *targetName != "crm" && *targetName != "analytics" {
+		logging.Fatal(logger, "-target must be crm or analytics")
+	}
+	// One correlation ID for the run, on everything it logs
+	runID := correlation.New()
+	logger = logger.With("target", *targetName, logging.KeyCorrelationID, runID)
+	ctx := logging.WithContext(correlation.WithID(context.Background(), runID), logger)
+
+	opts := replay.Options{DryRun: *dryRun}
+	if *types != "" {
+		for _, t := range strings.Split(*types, ",") {
+			opts.EventTypes = append(opts.EventTypes, models.EventType(strings.TrimSpace(t)))
+		}
+	}
+
+	// Source
+	var src replay.Source
+	switch *source {
+	case "store":
+		apiDB, err := postgres.Connect(config.LoadForService("API").DatabaseURL)
+		if err != nil {
+			logging.Fatal(logger, "failed to connect to API database", logging.KeyError, err)
+		}
+		defer apiDB.Close()
+		src = &replay.StoreSource{Store: eventstore.New(apiDB), AfterSequence: *afterSeq}
+	case "file":
+		var r io.Reader = os.Stdin
+		if *file != "-" {
+			if *file == "" {
+				logging.Fatal(logger, "-file is required when -source=file")
+			}
+			f, err := os.Open(*file)
+			if err != nil {
+				logging.Fatal(logger, "failed to open file", "file", *file, logging.KeyError, err)
+			}
+			defer f.Close()
+			r = f
+		}
+		src = &replay.FileSource{Reader: r}
+	default:
+		logging.Fatal(logger, "-source must be store or file")
+	}
+
+	// Target consumer (needed for reset and direct mode)
+	var consumer target
+	if *reset || *mode == "direct" {
+		db, err := postgres.Connect(config.LoadForService(strings.ToUpper(*targetName)).DatabaseURL)
+		if err != nil {
+			logging.Fatal(logger, "failed to connect to target database", logging.KeyError, err)
+		}
+		defer db.Close()
+		consumer = newTarget(*targetName, db)
+	}
+
+	if *reset && !*dryRun {
+		if err := consumer.Reset(ctx); err != nil {
+			logging.Fatal(logger, "failed to reset target", logging.KeyError, err)
+		}
+	}
+
+	// Sink
+	var sink replay.Sink
+	switch *mode {
+	case "direct":
+		sink = &replay.HandlerSink{Handler: consumer.HandleMessage}
+	case "publish":
+		rmqConn, err := rabbitmq.Connect(config.Load().RabbitMQURL)
+		if err != nil {
+			logging.Fatal(logger, "failed to connect to RabbitMQ", logging.KeyError, err)
+		}
+		defer rmqConn.Close()
+		publisher, err := rabbitmq.NewPublisher(rmqConn)
+		if err != nil {
+			logging.Fatal(logger, "failed to create publisher", logging.KeyError, err)
+		}
+		defer publisher.Close()
+		sink = &replay.PublishSink{Publisher: publisher, Target: *targetName}
+	default:
+		logging.Fatal(logger, "-mode must be publish or direct")
+	}
+
+	stats, err := replay.Run(src, sink, opts)
+	logger.Info("replay finished", "read", stats.Read, "replayed", stats.Replayed, "skipped", stats.Skipped)
+	if err != nil {
+		logging.Fatal(logger, "replay stopped", logging.KeyError, err)
+	}
+}
+
+func newTarget(name string, db *sql.DB) target {
+	if name == "crm" {
+		c := crm.NewConsumer(db)
+		c.SimulateFailures = false
+		return c
+	}
+	c := analytics.NewConsumer(db)
+	c.SimulateFailures = false
+	return c
+}
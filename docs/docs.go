@@ -13,13 +13,38 @@ const docTemplate = `{
     },
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "description": "API key with users:read / users:write scopes (when AUTH_ENABLED=true)",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "HS256 or RS256 JWT as \"Bearer <token>\"; scopes from the scope or scp claim",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
+    },
+    "security": [
+        { "ApiKeyAuth": [] },
+        { "BearerAuth": [] }
+    ],
     "paths": {
         "/users": {
             "get": {
-                "description": "Returns all users",
+                "description": "Returns users, optionally filtered",
                 "produces": ["application/json"],
                 "tags": ["users"],
                 "summary": "List all users",
+                "parameters": [
+                    { "type": "string", "description": "Exact email match", "name": "email", "in": "query" },
+                    { "type": "string", "description": "pending, active, suspended or deleted", "name": "status", "in": "query" },
+                    { "type": "string", "description": "Case-insensitive substring of name or email", "name": "q", "in": "query" },
+                    { "type": "string", "description": "RFC3339 lower bound on created_at", "name": "created_after", "in": "query" },
+                    { "type": "string", "description": "RFC3339 upper bound on created_at", "name": "created_before", "in": "query" }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -31,12 +56,18 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Creates a new user and publishes a user.created event",
+                "description": "Creates a new user and publishes a user.created event. Emails are normalised (trimmed, lowercased) and must be unique.",
                 "consumes": ["application/json"],
                 "produces": ["application/json"],
                 "tags": ["users"],
                 "summary": "Create a new user",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Replays the original response for retries with the same key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
                     {
                         "description": "Create user request",
                         "name": "request",
@@ -50,6 +81,41 @@ const docTemplate = `{
                         "description": "Created",
                         "schema": { "$ref": "#/definitions/models.User" }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "422": {
+                        "description": "Idempotency-Key reused with a different body",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "429": {
+                        "description": "Rate limit exceeded; see Retry-After",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users/export": {
+            "get": {
+                "description": "Streams users as NDJSON (default) or CSV from a database cursor. The export reads a single consistent snapshot and accepts the same filters as GET /users.",
+                "produces": ["application/x-ndjson", "text/csv"],
+                "tags": ["users"],
+                "summary": "Export all users",
+                "parameters": [
+                    { "type": "string", "description": "ndjson or csv", "name": "format", "in": "query" },
+                    { "type": "string", "description": "Exact email match", "name": "email", "in": "query" },
+                    { "type": "string", "description": "pending, active, suspended or deleted", "name": "status", "in": "query" },
+                    { "type": "string", "description": "Case-insensitive substring of name or email", "name": "q", "in": "query" },
+                    { "type": "string", "description": "RFC3339 lower bound on created_at", "name": "created_after", "in": "query" },
+                    { "type": "string", "description": "RFC3339 upper bound on created_at", "name": "created_before", "in": "query" }
+                ],
+                "responses": {
+                    "200": { "description": "OK" },
                     "400": {
                         "description": "Bad Request",
                         "schema": { "type": "object", "additionalProperties": { "type": "string" } }
@@ -114,6 +180,260 @@ const docTemplate = `{
                         "description": "Bad Request",
                         "schema": { "type": "object", "additionalProperties": { "type": "string" } }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users/{id}/events": {
+            "get": {
+                "description": "Returns the events recorded for a user in stream order",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "List a user's event history",
+                "parameters": [
+                    { "type": "string", "description": "User ID", "name": "id", "in": "path", "required": true },
+                    { "type": "integer", "description": "Only return events after this stream version", "name": "after", "in": "query" },
+                    { "type": "integer", "description": "Maximum number of events (default 100)", "name": "limit", "in": "query" }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "type": "array", "items": { "$ref": "#/definitions/eventstore.StoredEvent" } }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users/{id}/sagas": {
+            "get": {
+                "description": "Returns the progress of the user's multi-step workflows (e.g. onboarding), oldest first: each step's status and timings, the current step's deadline, and what failed if the saga was compensated",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "List a user's sagas",
+                "parameters": [
+                    { "type": "string", "description": "User ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "type": "array", "items": { "$ref": "#/definitions/saga.Saga" } }
+                    }
+                }
+            }
+        },
+        "/users/{id}/sync-status": {
+            "get": {
+                "description": "Reports, per downstream (crm, analytics), the latest of the user's events it applied and when, and its latest failure. A downstream is synced once it has applied the user's latest event, failed if its last failure is newer than its last success, and pending otherwise.",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "Get a user's downstream sync status",
+                "parameters": [
+                    { "type": "string", "description": "User ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "$ref": "#/definitions/syncstatus.Report" }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users/{id}/suspend": {
+            "post": {
+                "description": "Moves an active user to suspended and publishes a user.suspended event",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "Suspend a user",
+                "parameters": [
+                    { "type": "string", "description": "User ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "$ref": "#/definitions/models.User" }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "409": {
+                        "description": "Transition not allowed",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users/{id}/reactivate": {
+            "post": {
+                "description": "Moves a suspended (or pending) user to active and publishes a user.reactivated event",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "Reactivate a user",
+                "parameters": [
+                    { "type": "string", "description": "User ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "$ref": "#/definitions/models.User" }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "409": {
+                        "description": "Transition not allowed",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users/{id}/erase": {
+            "post": {
+                "description": "GDPR erasure: pseudonymises the user, scrubs personal data from the user's stored events, audit entries, idempotent responses and import results, marks the user deleted and publishes a user.erased event so consumers scrub their copies. Users in any status, including deleted ones, can be erased once",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "Erase a user's personal data",
+                "parameters": [
+                    { "type": "string", "description": "User ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "$ref": "#/definitions/models.User" }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "409": {
+                        "description": "User already deleted",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/users:bulk": {
+            "post": {
+                "description": "Creates many users from a JSON array, NDJSON or CSV (email,name) body or multipart \"file\" upload, returning a per-row result. With async=true the upload is stored and a job is returned at once; the job reads it, sets its total and runs the import in the background.",
+                "consumes": ["application/json", "text/csv", "application/x-ndjson", "multipart/form-data"],
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "Bulk import users",
+                "parameters": [
+                    { "type": "boolean", "description": "Run as a background job", "name": "async", "in": "query" }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "$ref": "#/definitions/models.BulkImportResult" }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": { "$ref": "#/definitions/models.ImportJob" }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/imports/{id}": {
+            "get": {
+                "description": "Returns the status and, once finished, the per-row results of an async import",
+                "produces": ["application/json"],
+                "tags": ["users"],
+                "summary": "Get a bulk import job",
+                "parameters": [
+                    { "type": "string", "description": "Job ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "$ref": "#/definitions/models.ImportJob" }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/audit": {
+            "get": {
+                "description": "Returns audit entries (actor, action, before/after snapshots, request metadata) for successful user changes, oldest first. Page with after=<id of the last entry seen>.",
+                "produces": ["application/json"],
+                "tags": ["audit"],
+                "summary": "List audit log entries",
+                "parameters": [
+                    { "type": "string", "description": "Only entries about this user", "name": "user_id", "in": "query" },
+                    { "type": "integer", "description": "Return entries with an ID greater than this", "name": "after", "in": "query" },
+                    { "type": "integer", "description": "Maximum entries (1-1000, default 100)", "name": "limit", "in": "query" }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "type": "array", "items": { "$ref": "#/definitions/audit.Entry" } }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/scheduled-messages": {
+            "get": {
+                "description": "Returns messages waiting to be published (e.g. user.reminder), soonest first, with the attempts and last error of any failed publish",
+                "produces": ["application/json"],
+                "tags": ["scheduled-messages"],
+                "summary": "List pending scheduled messages",
+                "parameters": [
+                    { "type": "string", "description": "Only messages with this key, e.g. reminder:<user id>", "name": "key", "in": "query" },
+                    { "type": "string", "description": "Only messages with this routing key", "name": "routing_key", "in": "query" },
+                    { "type": "integer", "description": "Maximum messages (1-1000, default 100)", "name": "limit", "in": "query" }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": { "type": "array", "items": { "$ref": "#/definitions/rabbitmq.ScheduledMessage" } }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": { "type": "object", "additionalProperties": { "type": "string" } }
+                    }
+                }
+            }
+        },
+        "/scheduled-messages/{id}": {
+            "delete": {
+                "description": "Drops a message that has not been published yet",
+                "tags": ["scheduled-messages"],
+                "summary": "Cancel a scheduled message",
+                "parameters": [
+                    { "type": "string", "description": "Scheduled message ID", "name": "id", "in": "path", "required": true }
+                ],
+                "responses": {
+                    "204": { "description": "No Content" },
                     "404": {
                         "description": "Not Found",
                         "schema": { "type": "object", "additionalProperties": { "type": "string" } }
@@ -127,6 +447,7 @@ const docTemplate = `{
                 "produces": ["application/json"],
                 "tags": ["health"],
                 "summary": "Health check",
+                "security": [],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -134,32 +455,266 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/livez": {
+            "get": {
+                "description": "Liveness probe: the process is serving requests. It does not check dependencies, so a database outage doesn't get the service restarted",
+                "produces": ["application/json"],
+                "tags": ["health"],
+                "summary": "Liveness probe",
+                "security": [],
+                "responses": {
+                    "200": { "description": "OK", "schema": { "$ref": "#/definitions/health.Report" } },
+                    "503": { "description": "Service Unavailable", "schema": { "$ref": "#/definitions/health.Report" } }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Readiness probe: pings PostgreSQL and checks the RabbitMQ connection and publishing channel, reporting each component's status, error and check duration",
+                "produces": ["application/json"],
+                "tags": ["health"],
+                "summary": "Readiness probe",
+                "security": [],
+                "responses": {
+                    "200": { "description": "OK", "schema": { "$ref": "#/definitions/health.Report" } },
+                    "503": { "description": "Service Unavailable", "schema": { "$ref": "#/definitions/health.Report" } }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Prometheus metrics in the text exposition format: request latency per route, event publishes per routing key",
+                "produces": ["text/plain"],
+                "tags": ["health"],
+                "summary": "Prometheus metrics",
+                "security": [],
+                "responses": {
+                    "200": { "description": "OK", "schema": { "type": "string" } }
+                }
+            }
         }
     },
     "definitions": {
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "status":     { "type": "string", "enum": ["up", "down"] },
+                "components": { "type": "object", "additionalProperties": { "$ref": "#/definitions/health.Component" } }
+            }
+        },
+        "health.Component": {
+            "type": "object",
+            "properties": {
+                "status":      { "type": "string", "enum": ["up", "down"] },
+                "error":       { "type": "string" },
+                "details":     { "type": "object" },
+                "duration_ms": { "type": "integer" }
+            }
+        },
         "models.User": {
             "type": "object",
             "properties": {
-                "id":         { "type": "string" },
-                "email":      { "type": "string" },
-                "name":       { "type": "string" },
-                "created_at": { "type": "string" },
-                "updated_at": { "type": "string" }
+                "id":                { "type": "string" },
+                "email":             { "type": "string" },
+                "name":              { "type": "string" },
+                "status":            { "type": "string", "enum": ["pending", "active", "suspended", "deleted"] },
+                "phone":             { "type": "string" },
+                "locale":            { "type": "string" },
+                "timezone":          { "type": "string" },
+                "marketing_consent": { "$ref": "#/definitions/models.MarketingConsent" },
+                "attributes":        { "type": "object", "additionalProperties": true },
+                "created_at":        { "type": "string" },
+                "updated_at":        { "type": "string" }
+            }
+        },
+        "models.MarketingConsent": {
+            "type": "object",
+            "properties": {
+                "email": { "type": "boolean" },
+                "sms":   { "type": "boolean" }
+            }
+        },
+        "models.UserEvent": {
+            "type": "object",
+            "properties": {
+                "event_id":       { "type": "string" },
+                "correlation_id": { "type": "string" },
+                "causation_id":   { "type": "string", "description": "ID of the event that led to this one; absent when a request caused it" },
+                "event_type":     { "type": "string" },
+                "timestamp":      { "type": "string" },
+                "data":           { "$ref": "#/definitions/models.User" },
+                "actor":          { "$ref": "#/definitions/models.Actor" },
+                "stream_version": { "type": "integer", "description": "Position in the user's event stream" }
+            }
+        },
+        "models.Actor": {
+            "type": "object",
+            "properties": {
+                "type": { "type": "string", "enum": ["api_key", "jwt"] },
+                "id":   { "type": "string" }
+            }
+        },
+        "audit.RequestMeta": {
+            "type": "object",
+            "properties": {
+                "method":         { "type": "string" },
+                "path":           { "type": "string" },
+                "client_ip":      { "type": "string" },
+                "user_agent":     { "type": "string" },
+                "correlation_id": { "type": "string" }
+            }
+        },
+        "audit.Entry": {
+            "type": "object",
+            "properties": {
+                "id":          { "type": "integer" },
+                "occurred_at": { "type": "string" },
+                "actor":       { "$ref": "#/definitions/models.Actor" },
+                "action":      { "type": "string" },
+                "user_id":     { "type": "string" },
+                "before":      { "$ref": "#/definitions/models.User" },
+                "after":       { "$ref": "#/definitions/models.User" },
+                "request":     { "$ref": "#/definitions/audit.RequestMeta" }
+            }
+        },
+        "eventstore.StoredEvent": {
+            "type": "object",
+            "properties": {
+                "sequence":       { "type": "integer" },
+                "stream_id":      { "type": "string" },
+                "stream_version": { "type": "integer" },
+                "recorded_at":    { "type": "string" },
+                "event":          { "$ref": "#/definitions/models.UserEvent" }
+            }
+        },
+        "saga.Saga": {
+            "type": "object",
+            "properties": {
+                "id":               { "type": "string" },
+                "name":             { "type": "string" },
+                "user_id":          { "type": "string" },
+                "trigger_event_id": { "type": "string" },
+                "correlation_id":   { "type": "string" },
+                "status":           { "type": "string", "enum": ["running", "completed", "compensated", "failed"] },
+                "current_step":     { "type": "integer" },
+                "steps":            { "type": "array", "items": { "$ref": "#/definitions/saga.StepState" } },
+                "step_deadline":    { "type": "string" },
+                "error":            { "type": "string" },
+                "created_at":       { "type": "string" },
+                "updated_at":       { "type": "string" }
+            }
+        },
+        "saga.StepState": {
+            "type": "object",
+            "properties": {
+                "name":        { "type": "string" },
+                "status":      { "type": "string", "enum": ["pending", "waiting", "completed", "failed", "compensated"] },
+                "started_at":  { "type": "string" },
+                "finished_at": { "type": "string" },
+                "error":       { "type": "string" }
+            }
+        },
+        "rabbitmq.ScheduledMessage": {
+            "type": "object",
+            "properties": {
+                "id":             { "type": "string" },
+                "key":            { "type": "string", "description": "Groups messages for cancellation, e.g. reminder:<user id>" },
+                "routing_key":    { "type": "string" },
+                "body":           { "type": "object", "description": "The message as it will be published" },
+                "correlation_id": { "type": "string" },
+                "message_id":     { "type": "string" },
+                "publish_at":     { "type": "string" },
+                "attempts":       { "type": "integer", "description": "Failed publishes so far" },
+                "last_error":     { "type": "string" },
+                "created_at":     { "type": "string" }
+            }
+        },
+        "syncstatus.Report": {
+            "type": "object",
+            "properties": {
+                "user_id":         { "type": "string" },
+                "latest_event_id": { "type": "string" },
+                "downstreams":     { "type": "array", "items": { "$ref": "#/definitions/syncstatus.Status" } }
+            }
+        },
+        "syncstatus.Status": {
+            "type": "object",
+            "properties": {
+                "downstream":      { "type": "string", "enum": ["crm", "analytics"] },
+                "state":           { "type": "string", "enum": ["synced", "pending", "failed"] },
+                "last_event_id":   { "type": "string" },
+                "last_event_type": { "type": "string" },
+                "last_event_version": { "type": "integer" },
+                "last_applied_at": { "type": "string" },
+                "last_failure":    { "$ref": "#/definitions/syncstatus.Failure" }
+            }
+        },
+        "syncstatus.Failure": {
+            "type": "object",
+            "properties": {
+                "event_id":   { "type": "string" },
+                "event_type": { "type": "string" },
+                "event_version": { "type": "integer" },
+                "error":      { "type": "string" },
+                "failed_at":  { "type": "string" }
+            }
+        },
+        "models.BulkRowResult": {
+            "type": "object",
+            "properties": {
+                "row":    { "type": "integer" },
+                "status": { "type": "string" },
+                "id":     { "type": "string" },
+                "email":  { "type": "string" },
+                "error":  { "type": "string" }
+            }
+        },
+        "models.BulkImportResult": {
+            "type": "object",
+            "properties": {
+                "total":     { "type": "integer" },
+                "succeeded": { "type": "integer" },
+                "failed":    { "type": "integer" },
+                "results":   { "type": "array", "items": { "$ref": "#/definitions/models.BulkRowResult" } }
+            }
+        },
+        "models.ImportJob": {
+            "type": "object",
+            "properties": {
+                "id":          { "type": "string" },
+                "status":      { "type": "string" },
+                "total":       { "type": "integer" },
+                "result":      { "$ref": "#/definitions/models.BulkImportResult" },
+                "error":       { "type": "string" },
+                "created_at":  { "type": "string" },
+                "finished_at": { "type": "string" }
             }
         },
         "models.CreateUserRequest": {
             "type": "object",
             "required": ["email", "name"],
             "properties": {
-                "email": { "type": "string", "example": "john@example.com" },
-                "name":  { "type": "string", "example": "John Doe" }
+                "email":             { "type": "string", "example": "john@example.com" },
+                "name":              { "type": "string", "example": "John Doe" },
+                "status":            { "type": "string", "enum": ["pending", "active"], "example": "active" },
+                "phone":             { "type": "string", "example": "+14155550123" },
+                "locale":            { "type": "string", "example": "en-US" },
+                "timezone":          { "type": "string", "example": "America/New_York" },
+                "marketing_consent": { "$ref": "#/definitions/models.MarketingConsent" },
+                "attributes":        { "type": "object", "additionalProperties": true }
             }
         },
         "models.UpdateUserRequest": {
             "type": "object",
             "properties": {
-                "email": { "type": "string", "example": "john@example.com" },
-                "name":  { "type": "string", "example": "John Doe" }
+                "email":             { "type": "string", "example": "john@example.com" },
+                "name":              { "type": "string", "example": "John Doe" },
+                "phone":             { "type": "string", "example": "+14155550123" },
+                "locale":            { "type": "string", "example": "en-US" },
+                "timezone":          { "type": "string", "example": "America/New_York" },
+                "marketing_consent": { "$ref": "#/definitions/models.MarketingConsent" },
+                "attributes":        { "type": "object", "additionalProperties": true }
             }
         }
     }
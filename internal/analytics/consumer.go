@@ -1,21 +1,35 @@
 package analytics
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"sort"
+	"strings"
 
+	"awesomeProject/pkg/logging"
 	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
 
+	"github.com/lib/pq"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// EventPublisher publishes the consumer's result events.
+type EventPublisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error
+}
+
 // Consumer handles analytics events.
 type Consumer struct {
 	DB               *sql.DB
 	SimulateFailures bool
+
+	// Publisher receives analytics.updated events; nil disables them
+	Publisher EventPublisher
 }
 
 // NewConsumer creates a new analytics consumer.
@@ -23,38 +37,64 @@ func NewConsumer(db *sql.DB) *Consumer {
 	return &Consumer{DB: db, SimulateFailures: true}
 }
 
+// Reset deletes the Analytics projection (analytics_metrics) and all idempotency keys
+// so that a replay rebuilds it from scratch.
+func (c *Consumer) Reset(ctx context.Context) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM analytics_metrics"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Info("analytics projection and idempotency keys reset")
+	return nil
+}
+
 // HandleMessage processes a user event for analytics.
-func (c *Consumer) HandleMessage(delivery amqp.Delivery) error {
+func (c *Consumer) HandleMessage(ctx context.Context, delivery amqp.Delivery) error {
 	var event models.UserEvent
 	if err := json.Unmarshal(delivery.Body, &event); err != nil {
-		log.Printf("[Analytics] Failed to unmarshal event: %v correlation_id=%s", err, delivery.CorrelationId)
+		logging.FromContext(ctx).Error("failed to unmarshal event", logging.KeyError, err)
 		return err
 	}
 
-	log.Printf("[Analytics] Processing event: type=%s event_id=%s correlation_id=%s user_id=%s",
-		event.EventType, event.EventID, event.CorrelationID, event.Data.ID)
+	_, logger := logging.WithEvent(ctx, event)
+	logger.Info("processing event")
 
 	// Idempotency check
 	var exists bool
-	err := c.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM idempotency_keys WHERE event_id = $1)", event.EventID).Scan(&exists)
+	err := c.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM idempotency_keys WHERE event_id = $1)", event.EventID).Scan(&exists)
 	if err != nil {
-		log.Printf("[Analytics] Error checking idempotency: %v correlation_id=%s", err, event.CorrelationID)
+		logger.Error("error checking idempotency", logging.KeyError, err)
 		return err
 	}
 	if exists {
-		log.Printf("[Analytics] Duplicate event ignored: event_id=%s correlation_id=%s", event.EventID, event.CorrelationID)
+		logger.Info("duplicate event ignored")
+		rabbitmq.MarkDuplicate(ctx)
 		return nil
 	}
 
 	// Simulate random failure (10% chance)
 	if c.SimulateFailures && rand.Intn(10) == 0 {
-		log.Printf("[Analytics] Simulated failure! event_id=%s correlation_id=%s", event.EventID, event.CorrelationID)
+		logger.Warn("simulated failure")
 		return fmt.Errorf("simulated analytics failure")
 	}
 
-	// Aggregate metrics — upsert count by date and event type
+	// Aggregate metrics — upsert count by date and event type. Counts don't
+	// depend on the order events arrive in, so a requeued event needs no
+	// stream version check
 	metricDate := event.Timestamp.Format("2006-01-02")
-	_, err = c.DB.Exec(
+	_, err = c.DB.ExecContext(ctx,
 		`INSERT INTO analytics_metrics (metric_date, event_type, count)
 		 VALUES ($1, $2, 1)
 		 ON CONFLICT (metric_date, event_type)
@@ -62,15 +102,167 @@ func (c *Consumer) HandleMessage(delivery amqp.Delivery) error {
 		metricDate, string(event.EventType),
 	)
 	if err != nil {
-		log.Printf("[Analytics] Error upserting metrics: %v correlation_id=%s", err, event.CorrelationID)
+		logger.Error("error upserting metrics", logging.KeyError, err)
 		return err
 	}
 
 	// Record idempotency key
-	_, _ = c.DB.Exec("INSERT INTO idempotency_keys (event_id) VALUES ($1) ON CONFLICT DO NOTHING", event.EventID)
+	_, _ = c.DB.ExecContext(ctx, "INSERT INTO idempotency_keys (event_id) VALUES ($1) ON CONFLICT DO NOTHING", event.EventID)
 
-	log.Printf("[Analytics] Metrics updated: date=%s type=%s correlation_id=%s",
-		metricDate, event.EventType, event.CorrelationID)
+	logger.Info("metrics updated", "metric_date", metricDate)
+	c.publish(ctx, logger, models.NewResultEvent(ctx, models.EventAnalyticsUpdated, event))
 
 	return nil
 }
+
+// metricKey identifies one analytics_metrics row.
+type metricKey struct {
+	date      string
+	eventType string
+}
+
+// HandleBatch processes a batch of user events for analytics. Counts are
+// aggregated in memory and written with the idempotency keys in a single
+// transaction, so the batch is applied all-or-nothing. A message that does
+// not parse, or fails on its own, is rejected without failing the rest.
+func (c *Consumer) HandleBatch(ctx context.Context, deliveries []amqp.Delivery) error {
+	logger := logging.FromContext(ctx)
+	events := make([]models.UserEvent, 0, len(deliveries))
+	parsed := make([]amqp.Delivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		var event models.UserEvent
+		if err := json.Unmarshal(d.Body, &event); err != nil {
+			logger.Error("failed to unmarshal event in batch", logging.KeyError, err,
+				"delivery_tag", d.DeliveryTag, logging.KeyCorrelationID, d.CorrelationId)
+			rabbitmq.Reject(ctx, d)
+			continue
+		}
+		events = append(events, event)
+		parsed = append(parsed, d)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.EventID
+	}
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Idempotency check for the whole batch in one round trip
+	seen := make(map[string]bool, len(ids))
+	rows, err := tx.QueryContext(ctx, "SELECT event_id FROM idempotency_keys WHERE event_id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		logger.Error("error checking idempotency for batch", logging.KeyError, err)
+		return err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		seen[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Aggregate, skipping events already processed (or repeated in this batch)
+	counts := make(map[metricKey]int)
+	var fresh []int
+	for i, e := range events {
+		if seen[e.EventID] {
+			_, l := logging.WithEvent(ctx, e)
+			l.Info("duplicate event ignored")
+			rabbitmq.MarkDuplicate(ctx)
+			continue
+		}
+		// Simulate random failure (10% chance per event)
+		if c.SimulateFailures && rand.Intn(10) == 0 {
+			_, l := logging.WithEvent(ctx, e)
+			l.Warn("simulated failure")
+			rabbitmq.Reject(ctx, parsed[i])
+			continue
+		}
+		seen[e.EventID] = true
+		fresh = append(fresh, i)
+		counts[metricKey{date: e.Timestamp.Format("2006-01-02"), eventType: string(e.EventType)}]++
+	}
+
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	keys := make([]metricKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].date != keys[j].date {
+			return keys[i].date < keys[j].date
+		}
+		return keys[i].eventType < keys[j].eventType
+	})
+
+	for _, k := range keys {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO analytics_metrics (metric_date, event_type, count)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (metric_date, event_type)
+			 DO UPDATE SET count = analytics_metrics.count + EXCLUDED.count`,
+			k.date, k.eventType, counts[k],
+		)
+		if err != nil {
+			logger.Error("error upserting batch metrics", logging.KeyError, err)
+			return err
+		}
+	}
+
+	// Record idempotency keys in one multi-row insert
+	placeholders := make([]string, len(fresh))
+	args := make([]interface{}, len(fresh))
+	for i, idx := range fresh {
+		placeholders[i] = fmt.Sprintf("($%d)", i+1)
+		args[i] = events[idx].EventID
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO idempotency_keys (event_id) VALUES "+strings.Join(placeholders, ", ")+" ON CONFLICT DO NOTHING",
+		args...,
+	)
+	if err != nil {
+		logger.Error("error recording idempotency keys for batch", logging.KeyError, err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("batch applied", "events", len(deliveries), "new", len(fresh), "buckets", len(keys))
+
+	for _, idx := range fresh {
+		ctx := rabbitmq.WithDelivery(ctx, parsed[idx])
+		c.publish(ctx, logger, models.NewResultEvent(ctx, models.EventAnalyticsUpdated, events[idx]))
+	}
+	return nil
+}
+
+// publish sends a result event, logging (but not returning) failures: the
+// metrics are updated either way.
+func (c *Consumer) publish(ctx context.Context, logger *slog.Logger, event models.ResultEvent) {
+	if c.Publisher == nil {
+		return
+	}
+	body, _ := json.Marshal(event)
+	if err := c.Publisher.Publish(ctx, string(event.EventType), body, event.CorrelationID, event.EventID); err != nil {
+		logger.Error("error publishing result event", logging.KeyError, err, "result_event_type", event.EventType)
+	}
+}
@@ -1,6 +1,7 @@
 package analytics
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
@@ -61,7 +62,7 @@ func TestHandleMessage_Success(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	delivery := makeDelivery(event)
-	if err := consumer.HandleMessage(delivery); err != nil {
+	if err := consumer.HandleMessage(context.Background(), delivery); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -98,7 +99,7 @@ func TestHandleMessage_DuplicateEvent(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
 
 	delivery := makeDelivery(event)
-	if err := consumer.HandleMessage(delivery); err != nil {
+	if err := consumer.HandleMessage(context.Background(), delivery); err != nil {
 		t.Fatalf("expected no error for duplicate, got %v", err)
 	}
 
@@ -122,7 +123,211 @@ func TestHandleMessage_InvalidJSON(t *testing.T) {
 		CorrelationId: "corr-bad",
 	}
 
-	if err := consumer.HandleMessage(delivery); err == nil {
+	if err := consumer.HandleMessage(context.Background(), delivery); err == nil {
 		t.Fatal("expected error for invalid JSON, got nil")
 	}
 }
+
+func TestHandleBatch_AggregatesAndSkipsDuplicates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	pub := &mockPublisher{}
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+	consumer.Publisher = pub
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := []models.UserEvent{
+		{EventID: "evt-b1", CorrelationID: "corr-b1", EventType: models.EventUserCreated, Timestamp: day},
+		{EventID: "evt-b2", CorrelationID: "corr-b2", EventType: models.EventUserCreated, Timestamp: day},
+		{EventID: "evt-b3", CorrelationID: "corr-b3", EventType: models.EventUserUpdated, Timestamp: day},
+		{EventID: "evt-b2", EventType: models.EventUserCreated, Timestamp: day}, // redelivered in same batch
+		{EventID: "evt-old", EventType: models.EventUserCreated, Timestamp: day},
+	}
+	deliveries := make([]amqp.Delivery, len(events))
+	for i, e := range events {
+		deliveries[i] = makeDelivery(e)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT event_id FROM idempotency_keys WHERE event_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow("evt-old"))
+	mock.ExpectExec("INSERT INTO analytics_metrics").
+		WithArgs("2026-03-01", "user.created", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO analytics_metrics").
+		WithArgs("2026-03-01", "user.updated", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO idempotency_keys \\(event_id\\) VALUES \\(\\$1\\), \\(\\$2\\), \\(\\$3\\)").
+		WithArgs("evt-b1", "evt-b2", "evt-b3").
+		WillReturnResult(sqlmock.NewResult(3, 3))
+	mock.ExpectCommit()
+
+	if err := consumer.HandleBatch(context.Background(), deliveries); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// One analytics.updated per newly counted event, in its own correlation
+	if len(pub.messages) != 3 {
+		t.Fatalf("expected 3 published messages, got %d", len(pub.messages))
+	}
+	for i, msg := range pub.messages {
+		want := events[i]
+		if msg.routingKey != "analytics.updated" || msg.event.CausationID != want.EventID || msg.correlationID != want.CorrelationID {
+			t.Errorf("message %d: expected analytics.updated caused by %s in %s, got %+v", i, want.EventID, want.CorrelationID, msg)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleBatch_AllDuplicates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+
+	deliveries := []amqp.Delivery{
+		makeDelivery(models.UserEvent{EventID: "evt-d1", EventType: models.EventUserCreated, Timestamp: time.Now()}),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT event_id FROM idempotency_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow("evt-d1"))
+	mock.ExpectRollback()
+
+	if err := consumer.HandleBatch(context.Background(), deliveries); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleBatch_InvalidJSONSkipsOnlyThatMessage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	deliveries := []amqp.Delivery{
+		{Body: []byte("{invalid json"), DeliveryTag: 1},
+		makeDelivery(models.UserEvent{EventID: "evt-ok", EventType: models.EventUserCreated, Timestamp: day}),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT event_id FROM idempotency_keys WHERE event_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}))
+	mock.ExpectExec("INSERT INTO analytics_metrics").
+		WithArgs("2026-03-01", "user.created", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("evt-ok").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := consumer.HandleBatch(context.Background(), deliveries); err != nil {
+		t.Fatalf("expected the valid event to be applied, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM analytics_metrics").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	if err := NewConsumer(db).Reset(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+type publishedMsg struct {
+	routingKey    string
+	correlationID string
+	event         models.ResultEvent
+}
+
+type mockPublisher struct {
+	messages []publishedMsg
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error {
+	msg := publishedMsg{routingKey: routingKey, correlationID: correlationID}
+	if err := json.Unmarshal(body, &msg.event); err != nil {
+		return err
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestHandleMessage_PublishesUpdated(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	pub := &mockPublisher{}
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+	consumer.Publisher = pub
+
+	event := models.UserEvent{
+		EventID:       "evt-001",
+		CorrelationID: "corr-001",
+		EventType:     models.EventUserCreated,
+		Timestamp:     time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Data:          models.User{ID: "user-001"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-001").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO analytics_metrics").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := consumer.HandleMessage(context.Background(), makeDelivery(event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(pub.messages) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(pub.messages))
+	}
+	msg := pub.messages[0]
+	if msg.routingKey != "analytics.updated" {
+		t.Errorf("expected routing key analytics.updated, got %q", msg.routingKey)
+	}
+	if msg.event.CausationID != "evt-001" || msg.event.CorrelationID != "corr-001" || msg.event.UserID != "user-001" {
+		t.Errorf("unexpected result event: %+v", msg.event)
+	}
+}
@@ -0,0 +1,258 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dateLayout = "2006-01-02"
+
+// defaultRangeDays is the lookback used when no "from" date is supplied.
+const defaultRangeDays = 30
+
+// MetricPoint is a single day/event-type bucket of the time series.
+type MetricPoint struct {
+	Date      string `json:"date"`
+	EventType string `json:"event_type"`
+	Count     int    `json:"count"`
+}
+
+// MetricTotal is an aggregated count keyed by event type or date.
+type MetricTotal struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// QueryHandler serves read-only analytics queries over HTTP.
+type QueryHandler struct {
+	DB *sql.DB
+}
+
+// NewQueryHandler creates a new QueryHandler.
+func NewQueryHandler(db *sql.DB) *QueryHandler {
+	return &QueryHandler{DB: db}
+}
+
+// TimeSeries returns daily counts in [from, to], optionally filtered by event_type.
+func (q *QueryHandler) TimeSeries(c *gin.Context) {
+	from, to, ok := parseRange(c)
+	if !ok {
+		return
+	}
+
+	points, err := q.timeSeries(c.Request.Context(), from, to, c.Query("event_type"))
+	if err != nil {
+		middleware.Logger(c).Error("error querying time series", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query metrics"})
+		return
+	}
+
+	if wantsCSV(c) {
+		records := make([][]string, 0, len(points))
+		for _, p := range points {
+			records = append(records, []string{p.Date, p.EventType, strconv.Itoa(p.Count)})
+		}
+		writeCSV(c, "timeseries.csv", []string{"date", "event_type", "count"}, records)
+		return
+	}
+
+	c.JSON(http.StatusOK, points)
+}
+
+// Totals returns the per-event-type totals in [from, to] plus a grand total.
+func (q *QueryHandler) Totals(c *gin.Context) {
+	from, to, ok := parseRange(c)
+	if !ok {
+		return
+	}
+
+	totals, err := q.groupedCounts(c.Request.Context(), "event_type", from, to, 0)
+	if err != nil {
+		middleware.Logger(c).Error("error querying totals", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query metrics"})
+		return
+	}
+
+	if wantsCSV(c) {
+		writeCSV(c, "totals.csv", []string{"event_type", "count"}, totalsToRecords(totals))
+		return
+	}
+
+	sum := 0
+	for _, t := range totals {
+		sum += t.Count
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from.Format(dateLayout),
+		"to":      to.Format(dateLayout),
+		"total":   sum,
+		"by_type": totals,
+	})
+}
+
+// Top returns the N highest-count buckets in [from, to], grouped by
+// event_type (default) or date.
+func (q *QueryHandler) Top(c *gin.Context) {
+	from, to, ok := parseRange(c)
+	if !ok {
+		return
+	}
+
+	var column string
+	switch by := c.DefaultQuery("by", "event_type"); by {
+	case "event_type":
+		column = "event_type"
+	case "date":
+		column = "metric_date"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "by must be event_type or date"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	totals, err := q.groupedCounts(c.Request.Context(), column, from, to, limit)
+	if err != nil {
+		middleware.Logger(c).Error("error querying top-N", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query metrics"})
+		return
+	}
+
+	if wantsCSV(c) {
+		writeCSV(c, "top.csv", []string{"key", "count"}, totalsToRecords(totals))
+		return
+	}
+
+	c.JSON(http.StatusOK, totals)
+}
+
+// timeSeries returns the daily counts in [from, to], for eventType only if
+// it is non-empty.
+func (q *QueryHandler) timeSeries(ctx context.Context, from, to time.Time, eventType string) ([]MetricPoint, error) {
+	query := `SELECT metric_date, event_type, count FROM analytics_metrics
+		WHERE metric_date BETWEEN $1 AND $2`
+	args := []interface{}{from, to}
+	if eventType != "" {
+		query += " AND event_type = $3"
+		args = append(args, eventType)
+	}
+	query += " ORDER BY metric_date, event_type"
+
+	rows, err := q.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MetricPoint{}
+	for rows.Next() {
+		var p MetricPoint
+		var date time.Time
+		if err := rows.Scan(&date, &p.EventType, &p.Count); err != nil {
+			return nil, err
+		}
+		p.Date = date.Format(dateLayout)
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// groupedCounts sums counts grouped by column, ordered by count descending.
+// column must be a trusted identifier; it is cast to text so dates come back
+// as YYYY-MM-DD. A limit of 0 means no limit.
+func (q *QueryHandler) groupedCounts(ctx context.Context, column string, from, to time.Time, limit int) ([]MetricTotal, error) {
+	query := `SELECT ` + column + `::text, SUM(count) AS total FROM analytics_metrics
+		WHERE metric_date BETWEEN $1 AND $2
+		GROUP BY ` + column + ` ORDER BY total DESC, ` + column
+	args := []interface{}{from, to}
+	if limit > 0 {
+		query += " LIMIT $3"
+		args = append(args, limit)
+	}
+
+	rows, err := q.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []MetricTotal{}
+	for rows.Next() {
+		var t MetricTotal
+		if err := rows.Scan(&t.Key, &t.Count); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// parseRange reads the from/to query params (YYYY-MM-DD). It writes a 400
+// and returns ok=false if either is malformed or the range is inverted.
+func parseRange(c *gin.Context) (from, to time.Time, ok bool) {
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be YYYY-MM-DD"})
+			return from, to, false
+		}
+		to = t
+	}
+
+	from = to.AddDate(0, 0, -defaultRangeDays)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be YYYY-MM-DD"})
+			return from, to, false
+		}
+		from = t
+	}
+
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// wantsCSV reports whether the caller asked for CSV via ?format=csv or Accept.
+func wantsCSV(c *gin.Context) bool {
+	if f := c.Query("format"); f != "" {
+		return strings.EqualFold(f, "csv")
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/csv")
+}
+
+func writeCSV(c *gin.Context, filename string, header []string, records [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(header)
+	_ = w.WriteAll(records)
+}
+
+func totalsToRecords(totals []MetricTotal) [][]string {
+	records := make([][]string, 0, len(totals))
+	for _, t := range totals {
+		records = append(records, []string{t.Key, strconv.Itoa(t.Count)})
+	}
+	return records
+}
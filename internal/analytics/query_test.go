@@ -0,0 +1,204 @@
+package analytics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestTimeSeries_JSON(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT metric_date, event_type, count FROM analytics_metrics").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "user.created").
+		WillReturnRows(sqlmock.NewRows([]string{"metric_date", "event_type", "count"}).
+			AddRow(day, "user.created", 4).
+			AddRow(day.AddDate(0, 0, 1), "user.created", 7))
+
+	router := NewRouter(NewQueryHandler(db))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet,
+		"/analytics/timeseries?event_type=user.created&from=2026-03-01&to=2026-03-02", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var points []MetricPoint
+	if err := json.Unmarshal(w.Body.Bytes(), &points); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(points))
+	}
+	if points[1].Date != "2026-03-02" || points[1].Count != 7 {
+		t.Errorf("unexpected second point: %+v", points[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestTimeSeries_CSV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT metric_date, event_type, count FROM analytics_metrics").
+		WillReturnRows(sqlmock.NewRows([]string{"metric_date", "event_type", "count"}).
+			AddRow(day, "user.updated", 2))
+
+	router := NewRouter(NewQueryHandler(db))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/analytics/timeseries?format=csv", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected text/csv content type, got %q", ct)
+	}
+
+	expected := "date,event_type,count\n2026-03-01,user.updated,2\n"
+	if w.Body.String() != expected {
+		t.Errorf("expected body %q, got %q", expected, w.Body.String())
+	}
+}
+
+func TestTotals(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT event_type::text, SUM\\(count\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"event_type", "total"}).
+			AddRow("user.created", 10).
+			AddRow("user.updated", 5))
+
+	router := NewRouter(NewQueryHandler(db))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/analytics/totals?from=2026-01-01&to=2026-01-31", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Total  int           `json:"total"`
+		ByType []MetricTotal `json:"by_type"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Total != 15 {
+		t.Errorf("expected total 15, got %d", resp.Total)
+	}
+	if len(resp.ByType) != 2 {
+		t.Errorf("expected 2 event types, got %d", len(resp.ByType))
+	}
+}
+
+func TestTop_ByDateWithLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT metric_date::text, SUM\\(count\\)").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"metric_date", "total"}).
+			AddRow("2026-03-04", 42))
+
+	router := NewRouter(NewQueryHandler(db))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/analytics/top?by=date&limit=3", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestQuery_BadParams(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	router := NewRouter(NewQueryHandler(db))
+
+	for _, url := range []string{
+		"/analytics/timeseries?from=yesterday",
+		"/analytics/totals?from=2026-02-01&to=2026-01-01",
+		"/analytics/top?by=user",
+		"/analytics/top?limit=0",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestTimeSeries_RowError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT metric_date, event_type, count FROM analytics_metrics").
+		WillReturnRows(sqlmock.NewRows([]string{"metric_date", "event_type", "count"}).
+			AddRow(day, "user.created", 4).
+			AddRow(day.AddDate(0, 0, 1), "user.created", 7).
+			RowError(1, errors.New("connection reset")))
+
+	router := NewRouter(NewQueryHandler(db))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/analytics/timeseries", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 rather than partial data, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,28 @@
+package analytics
+
+import (
+	"net/http"
+
+	"awesomeProject/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter creates the Gin router for the analytics query API.
+func NewRouter(q *QueryHandler) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery(), middleware.Tracing(), middleware.CorrelationID(), middleware.RequestLogger(), middleware.Metrics())
+
+	// Health check
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// Query routes
+	g := r.Group("/analytics")
+	g.GET("/timeseries", q.TimeSeries)
+	g.GET("/totals", q.Totals)
+	g.GET("/top", q.Top)
+
+	return r
+}
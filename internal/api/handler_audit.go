@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListAudit godoc
+// @Summary      List audit log entries
+// @Description  Returns audit entries (actor, action, before/after snapshots, request metadata) for successful user changes, oldest first. Page with after=<id of the last entry seen>.
+// @Tags         audit
+// @Produce      json
+// @Param        user_id  query     string  false  "Only entries about this user"
+// @Param        after    query     int     false  "Return entries with an ID greater than this"
+// @Param        limit    query     int     false  "Maximum entries (1-1000, default 100)"
+// @Success      200      {array}   audit.Entry
+// @Failure      400      {object}  map[string]string
+// @Failure      500      {object}  map[string]string
+// @Router       /audit [get]
+func (h *UserHandler) ListAudit(c *gin.Context) {
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a non-negative integer"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	entries, err := h.Audit.List(c.Request.Context(), c.Query("user_id"), after, limit)
+	if err != nil {
+		middleware.Logger(c).Error("error listing audit log", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
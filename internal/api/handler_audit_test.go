@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListAudit_ByUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, .* FROM audit_log WHERE id > \\$1 AND user_id = \\$2 ORDER BY id LIMIT \\$3").
+		WithArgs(int64(5), "user-1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "occurred_at", "actor_type", "actor_id", "action", "user_id", "before", "after",
+			"method", "path", "client_ip", "user_agent", "correlation_id"}).
+			AddRow(6, time.Now(), "api_key", "key-1", "user.suspended", "user-1",
+				[]byte(`{"id":"user-1","status":"active"}`), []byte(`{"id":"user-1","status":"suspended"}`),
+				"POST", "/users/user-1/suspend", "10.0.0.1", "curl/8", "corr-1"))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/audit?user_id=user-1&after=5&limit=2", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var entries []audit.Entry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Before.Status != "active" || entries[0].After.Status != "suspended" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if entries[0].Actor == nil || entries[0].Actor.ID != "key-1" || entries[0].Request.ClientIP != "10.0.0.1" {
+		t.Errorf("expected actor and request metadata, got %+v", entries[0])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestListAudit_InvalidLimit(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/audit?limit=0", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
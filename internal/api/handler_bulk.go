@@ -0,0 +1,651 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/pkg/audit"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+	"awesomeProject/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+const (
+	// bulkBatchSize is the number of rows inserted per transaction.
+	bulkBatchSize = 500
+	// maxSyncBulkRows is the largest import handled inline; bigger ones need ?async=true.
+	maxSyncBulkRows = 1000
+	// maxBulkBodyBytes caps the size of an uploaded import.
+	maxBulkBodyBytes = 50 << 20
+	// maxBulkLineBytes caps one NDJSON line.
+	maxBulkLineBytes = 1 << 20
+
+	// importHeartbeat is how often a running import job touches its row;
+	// FailInterruptedImports fails jobs that missed a few beats.
+	importHeartbeat  = 30 * time.Second
+	importStaleAfter = 3 * importHeartbeat
+)
+
+// Supported bulk import formats.
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// usersAction serves the custom methods on the users collection
+// (/users:<action>); gin can't route a literal colon, so they share a route.
+func (h *UserHandler) usersAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":bulk":
+		h.BulkCreateUsers(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	}
+}
+
+// BulkCreateUsers godoc
+// @Summary      Bulk import users
+// @Description  Creates many users from a JSON array, NDJSON or CSV (email,name and optional phone,locale,timezone) body or multipart "file" upload, returning a per-row result. Inserted users get a user.created event each. With async=true the upload is stored and a job is returned at once; the job reads it, sets its total and runs the import in the background.
+// @Tags         users
+// @Accept       json,text/csv,application/x-ndjson,multipart/form-data
+// @Produce      json
+// @Param        async  query     bool  false  "Run as a background job"
+// @Success      200    {object}  models.BulkImportResult
+// @Success      202    {object}  models.ImportJob
+// @Failure      400    {object}  map[string]string
+// @Failure      413    {object}  map[string]string
+// @Router       /users:bulk [post]
+func (h *UserHandler) BulkCreateUsers(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	async := false
+	if v := c.Query("async"); v != "" {
+		var err error
+		if async, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "async must be true or false"})
+			return
+		}
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBulkBodyBytes)
+	format, upload, err := openBulkUpload(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer upload.Close()
+
+	if async {
+		h.queueImportJob(c, format, upload)
+		return
+	}
+
+	rows, err := decodeBulkRows(format, upload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no rows to import"})
+		return
+	}
+
+	if len(rows) > maxSyncBulkRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("%d rows exceeds the synchronous limit of %d; retry with ?async=true", len(rows), maxSyncBulkRows),
+		})
+		return
+	}
+
+	result := h.importUsers(c.Request.Context(), rows, originFrom(c))
+	logger.Info("bulk import finished", "total", result.Total, "succeeded", result.Succeeded, "failed", result.Failed)
+	c.JSON(http.StatusOK, result)
+}
+
+// queueImportJob stores upload in a temporary file and starts a job that
+// parses and imports it, so the request returns without holding the rows.
+func (h *UserHandler) queueImportJob(c *gin.Context, format string, upload io.Reader) {
+	logger := middleware.Logger(c)
+
+	spool, err := os.CreateTemp("", "import-*")
+	if err != nil {
+		logger.Error("error creating import spool file", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store upload"})
+		return
+	}
+	_, err = io.Copy(spool, upload)
+	if cerr := spool.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(spool.Name())
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("upload exceeds %d bytes", tooLarge.Limit)})
+			return
+		}
+		logger.Error("error storing import upload", logging.KeyError, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read upload"})
+		return
+	}
+
+	job := models.ImportJob{
+		ID:        uuid.New().String(),
+		Status:    models.ImportJobPending,
+		CreatedAt: time.Now(),
+	}
+	_, err = h.DB.ExecContext(c.Request.Context(),
+		"INSERT INTO import_jobs (id, status, total, created_at) VALUES ($1, $2, $3, $4)",
+		job.ID, job.Status, job.Total, job.CreatedAt,
+	)
+	if err != nil {
+		_ = os.Remove(spool.Name())
+		logger.Error("error creating import job", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create import job"})
+		return
+	}
+
+	// The job outlives the request but keeps its logger
+	ctx, logger := logging.With(context.WithoutCancel(c.Request.Context()), "job_id", job.ID)
+	o := originFrom(c)
+	path := spool.Name()
+	h.imports.run(ctx, func(ctx context.Context) { h.runSpooledImportJob(ctx, job.ID, format, path, o) })
+
+	logger.Info("import job queued", "format", format)
+	c.Header("Location", "/imports/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetImportJob godoc
+// @Summary      Get a bulk import job
+// @Description  Returns the status and, once finished, the per-row results of an async import
+// @Tags         users
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200  {object}  models.ImportJob
+// @Failure      404  {object}  map[string]string
+// @Router       /imports/{id} [get]
+func (h *UserHandler) GetImportJob(c *gin.Context) {
+	var job models.ImportJob
+	var result []byte
+	var jobErr sql.NullString
+	var finishedAt sql.NullTime
+	err := h.DB.QueryRowContext(c.Request.Context(),
+		"SELECT id, status, total, result, error, created_at, finished_at FROM import_jobs WHERE id = $1",
+		c.Param("id"),
+	).Scan(&job.ID, &job.Status, &job.Total, &result, &jobErr, &job.CreatedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch import job"})
+		return
+	}
+
+	if len(result) > 0 {
+		job.Result = &models.BulkImportResult{}
+		if err := json.Unmarshal(result, job.Result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode import result"})
+			return
+		}
+	}
+	job.Error = jobErr.String
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// runSpooledImportJob parses the upload queueImportJob stored at path,
+// removes it and runs the import, or fails the job if the upload is invalid.
+func (h *UserHandler) runSpooledImportJob(ctx context.Context, jobID, format, path string, o origin) {
+	rows, err := readSpooledRows(format, path)
+	if err == nil && len(rows) == 0 {
+		err = errors.New("no rows to import")
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("import job upload is invalid", logging.KeyError, err)
+		h.finishImportJob(ctx, jobID, models.ImportJobFailed, nil, err.Error())
+		return
+	}
+	h.runImportJob(ctx, jobID, rows, o)
+}
+
+func readSpooledRows(format, path string) ([]models.CreateUserRequest, error) {
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeBulkRows(format, f)
+}
+
+// runImportJob executes an async import and records the outcome on the job
+// row. If ctx is cancelled (StopImports on shutdown) the rows not yet
+// inserted fail and so does the job.
+func (h *UserHandler) runImportJob(ctx context.Context, jobID string, rows []models.CreateUserRequest, o origin) {
+	logger := logging.FromContext(ctx)
+	if _, err := h.DB.ExecContext(ctx, "UPDATE import_jobs SET status = $1, total = $2, heartbeat_at = $3 WHERE id = $4",
+		models.ImportJobRunning, len(rows), time.Now(), jobID); err != nil {
+		logger.Error("error starting import job", logging.KeyError, err)
+	}
+
+	beat := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(importHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beat:
+				return
+			case <-ticker.C:
+				if _, err := h.DB.ExecContext(ctx, "UPDATE import_jobs SET heartbeat_at = $1 WHERE id = $2", time.Now(), jobID); err != nil {
+					logger.Warn("error recording import job heartbeat", logging.KeyError, err)
+				}
+			}
+		}
+	}()
+	result := h.importUsers(ctx, rows, o)
+	close(beat)
+
+	status, jobErr := models.ImportJobCompleted, ""
+	if ctx.Err() != nil {
+		status, jobErr = models.ImportJobFailed, "interrupted by shutdown"
+	}
+	if h.finishImportJob(ctx, jobID, status, &result, jobErr) {
+		logger.Info("import job finished", "status", status, "succeeded", result.Succeeded, "failed", result.Failed)
+	}
+}
+
+// finishImportJob records a job's outcome, even when ctx was cancelled, and
+// reports whether it was saved.
+func (h *UserHandler) finishImportJob(ctx context.Context, jobID, status string, result *models.BulkImportResult, jobErr string) bool {
+	var resultJSON []byte
+	if result != nil {
+		var err error
+		if resultJSON, err = json.Marshal(result); err != nil {
+			status = models.ImportJobFailed
+		}
+	}
+
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_, err := h.DB.ExecContext(finishCtx,
+		"UPDATE import_jobs SET status = $1, result = $2, error = NULLIF($3, ''), finished_at = $4 WHERE id = $5",
+		status, resultJSON, jobErr, time.Now(), jobID,
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("error finishing import job", logging.KeyError, err)
+		return false
+	}
+	return true
+}
+
+// importJobs tracks the async import jobs running in a process so shutdown
+// can cancel them and wait for them to record their outcome.
+type importJobs struct {
+	wg   sync.WaitGroup
+	stop context.Context
+	halt context.CancelFunc
+}
+
+func newImportJobs() *importJobs {
+	j := &importJobs{}
+	j.stop, j.halt = context.WithCancel(context.Background())
+	return j
+}
+
+// run starts fn in a goroutine with a ctx that StopImports cancels.
+func (j *importJobs) run(ctx context.Context, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+	unlink := context.AfterFunc(j.stop, cancel)
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		defer unlink()
+		defer cancel()
+		fn(ctx)
+	}()
+}
+
+// StopImports cancels the async import jobs running in this process and
+// waits until they have recorded their outcome or ctx is done. Jobs still
+// running when the process exits are failed by FailInterruptedImports on a
+// later start.
+func (h *UserHandler) StopImports(ctx context.Context) error {
+	h.imports.halt()
+	done := make(chan struct{})
+	go func() {
+		h.imports.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FailInterruptedImports marks import jobs that stopped beating as failed:
+// the process running them exited without finishing them. Jobs another
+// instance is still running keep their heartbeat fresh and are left alone.
+func (h *UserHandler) FailInterruptedImports(ctx context.Context) (int64, error) {
+	res, err := h.DB.ExecContext(ctx,
+		`UPDATE import_jobs SET status = $1, error = 'interrupted: the process running it stopped', finished_at = NOW()
+		 WHERE status IN ($2, $3) AND COALESCE(heartbeat_at, created_at) < $4`,
+		models.ImportJobFailed, models.ImportJobPending, models.ImportJobRunning, time.Now().Add(-importStaleAfter),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// importUsers validates every row, inserts the valid ones in batched
+// transactions (recording their events), then publishes the events.
+func (h *UserHandler) importUsers(ctx context.Context, rows []models.CreateUserRequest, o origin) models.BulkImportResult {
+	result := models.BulkImportResult{
+		Total:   len(rows),
+		Results: make([]models.BulkRowResult, len(rows)),
+	}
+
+	seen := make(map[string]int, len(rows))
+	pending := make([]int, 0, len(rows))
+	for i := range rows {
+		res := &result.Results[i]
+		res.Row = i + 1
+		rows[i].Email = h.normalizeEmail(rows[i].Email)
+		res.Email = rows[i].Email
+
+		if err := binding.Validator.ValidateStruct(&rows[i]); err != nil {
+			res.Status, res.Error = models.BulkRowError, err.Error()
+			continue
+		}
+		if err := h.AttributeSchema.Validate(rows[i].Attributes); err != nil {
+			res.Status, res.Error = models.BulkRowError, err.Error()
+			continue
+		}
+		if first, dup := seen[rows[i].Email]; dup {
+			res.Status, res.Error = models.BulkRowError, fmt.Sprintf("duplicate of row %d", first)
+			continue
+		}
+		seen[rows[i].Email] = res.Row
+		pending = append(pending, i)
+	}
+
+	var events []models.UserEvent
+	for start := 0; start < len(pending); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+
+		batchEvents, err := h.insertBulkBatch(ctx, rows, batch, result.Results, o)
+		if err != nil {
+			logging.FromContext(ctx).Error("error inserting bulk batch", "rows", len(batch), logging.KeyError, err)
+			for _, i := range batch {
+				res := &result.Results[i]
+				res.Status, res.ID, res.Error = models.BulkRowError, "", "failed to create user"
+			}
+			continue
+		}
+		events = append(events, batchEvents...)
+	}
+
+	for _, event := range events {
+		h.publish(ctx, event)
+	}
+
+	for _, res := range result.Results {
+		if res.Status == models.BulkRowCreated {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+	return result
+}
+
+// insertBulkBatch inserts rows[idx...] in one transaction. Rows whose email
+// already exists are skipped and marked as errors rather than aborting the
+// batch. The conflict clause has no target so both the email column and the
+// lower(email) index count. Emails are unique within idx, so RETURNING email
+// identifies the inserted rows.
+func (h *UserHandler) insertBulkBatch(ctx context.Context, rows []models.CreateUserRequest, idx []int, results []models.BulkRowResult, o origin) ([]models.UserEvent, error) {
+	now := time.Now()
+	users := make([]models.User, len(idx))
+
+	values := make([]string, len(idx))
+	var args []interface{}
+	for n, i := range idx {
+		users[n] = h.newUser(rows[i], now)
+		cols := userArgs(users[n])
+		placeholders := make([]string, len(cols))
+		for j := range cols {
+			placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+		}
+		values[n] = "(" + strings.Join(placeholders, ", ") + ")"
+		args = append(args, cols...)
+	}
+
+	var events []models.UserEvent
+	inserted := make(map[string]bool, len(idx))
+	err := h.withTx(ctx, func(tx *sql.Tx) error {
+		rs, err := tx.QueryContext(ctx,
+			"INSERT INTO users ("+userColumns+") VALUES "+strings.Join(values, ", ")+
+				" ON CONFLICT DO NOTHING RETURNING email",
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		for rs.Next() {
+			var email string
+			if err := rs.Scan(&email); err != nil {
+				rs.Close()
+				return err
+			}
+			inserted[email] = true
+		}
+		rs.Close()
+		if err := rs.Err(); err != nil {
+			return err
+		}
+
+		entries := make([]audit.Entry, 0, len(users))
+		for _, u := range users {
+			if inserted[u.Email] {
+				event := newUserEvent(ctx, models.EventUserCreated, o.Actor, u)
+				events = append(events, event)
+				entries = append(entries, auditEntry(event, nil, o))
+			}
+		}
+		if err := h.Events.AppendBatch(ctx, tx, events); err != nil {
+			return err
+		}
+		if err := h.scheduleReminders(ctx, tx, events); err != nil {
+			return err
+		}
+		return h.Audit.AppendBatch(ctx, tx, entries)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for n, i := range idx {
+		res := &results[i]
+		if inserted[users[n].Email] {
+			res.Status, res.ID = models.BulkRowCreated, users[n].ID
+		} else {
+			res.Status, res.Error = models.BulkRowError, "email already exists"
+		}
+	}
+	return events, nil
+}
+
+// openBulkUpload returns the format and content of the request body (or
+// multipart "file" field). The caller closes the content.
+func openBulkUpload(r *http.Request) (string, io.ReadCloser, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", nil, errors.New("missing or invalid Content-Type")
+	}
+
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return "", nil, errors.New(`multipart upload must include a "file" field`)
+		}
+
+		format := formatFromMediaType(header.Header.Get("Content-Type"))
+		if format == "" {
+			format = formatFromFilename(header.Filename)
+		}
+		if format == "" {
+			file.Close()
+			return "", nil, errors.New("cannot determine file format; use .json, .ndjson or .csv")
+		}
+		return format, file, nil
+	}
+
+	format := formatFromMediaType(mediaType)
+	if format == "" {
+		return "", nil, fmt.Errorf("unsupported Content-Type %q", mediaType)
+	}
+	return format, r.Body, nil
+}
+
+func formatFromMediaType(mediaType string) string {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = mt
+	}
+	switch mediaType {
+	case "application/json":
+		return formatJSON
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return formatNDJSON
+	case "text/csv":
+		return formatCSV
+	}
+	return ""
+}
+
+func formatFromFilename(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return formatJSON
+	case ".ndjson", ".jsonl":
+		return formatNDJSON
+	case ".csv":
+		return formatCSV
+	}
+	return ""
+}
+
+func decodeBulkRows(format string, r io.Reader) ([]models.CreateUserRequest, error) {
+	switch format {
+	case formatJSON:
+		var rows []models.CreateUserRequest
+		if err := json.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+		return rows, nil
+
+	case formatNDJSON:
+		var rows []models.CreateUserRequest
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxBulkLineBytes)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var row models.CreateUserRequest
+			if err := json.Unmarshal([]byte(text), &row); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			rows = append(rows, row)
+		}
+		if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line %d: longer than %d bytes", line+1, maxBulkLineBytes)
+		}
+		return rows, scanner.Err()
+
+	case formatCSV:
+		reader := csv.NewReader(r)
+		reader.TrimLeadingSpace = true
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV header: %w", err)
+		}
+		emailCol, nameCol, phoneCol, localeCol, timezoneCol := -1, -1, -1, -1, -1
+		for i, col := range header {
+			switch strings.ToLower(strings.TrimSpace(col)) {
+			case "email":
+				emailCol = i
+			case "name":
+				nameCol = i
+			case "phone":
+				phoneCol = i
+			case "locale":
+				localeCol = i
+			case "timezone":
+				timezoneCol = i
+			}
+		}
+		optional := func(record []string, col int) string {
+			if col < 0 {
+				return ""
+			}
+			return record[col]
+		}
+		if emailCol < 0 || nameCol < 0 {
+			return nil, errors.New(`CSV header must include "email" and "name" columns`)
+		}
+
+		var rows []models.CreateUserRequest
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return rows, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %w", err)
+			}
+			rows = append(rows, models.CreateUserRequest{
+				Email:    record[emailCol],
+				Name:     record[nameCol],
+				Phone:    optional(record, phoneCol),
+				Locale:   optional(record, localeCol),
+				Timezone: optional(record, timezoneCol),
+			})
+		}
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func doBulk(t *testing.T, handler *UserHandler, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	router := NewRouter(handler)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBulkCreateUsers_JSONPerRowResults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Rows 1 and 3 reach the database; c@example.com already exists there
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users .* ON CONFLICT DO NOTHING RETURNING email").
+		WithArgs(append(insertUserArgs("a@example.com", "A"), insertUserArgs("c@example.com", "C")...)...).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("a@example.com"))
+	mock.ExpectQuery("INSERT INTO events").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "stream_version"}))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	body := `[
+		{"email":"a@example.com","name":"A"},
+		{"email":"not-an-email","name":"B"},
+		{"email":"c@example.com","name":"C"},
+		{"email":"a@example.com","name":"A again"}
+	]`
+	w := doBulk(t, NewUserHandler(db, pub), "/users:bulk", "application/json", []byte(body))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result models.BulkImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Total != 4 || result.Succeeded != 1 || result.Failed != 3 {
+		t.Errorf("unexpected summary: %+v", result)
+	}
+	if result.Results[0].Status != models.BulkRowCreated || result.Results[0].ID == "" {
+		t.Errorf("row 1: expected created with ID, got %+v", result.Results[0])
+	}
+	if !strings.Contains(result.Results[1].Error, "Email") {
+		t.Errorf("row 2: expected validation error, got %q", result.Results[1].Error)
+	}
+	if result.Results[2].Error != "email already exists" {
+		t.Errorf("row 3: expected conflict error, got %q", result.Results[2].Error)
+	}
+	if result.Results[3].Error != "duplicate of row 1" {
+		t.Errorf("row 4: expected duplicate error, got %q", result.Results[3].Error)
+	}
+
+	if len(pub.published) != 1 || pub.published[0].RoutingKey != "user.created" {
+		t.Fatalf("expected 1 user.created event, got %+v", pub.published)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestBulkCreateUsers_CSVUpload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("x@example.com").AddRow("y@example.com"))
+	mock.ExpectQuery("INSERT INTO events").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "stream_version"}))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, _ := mw.CreateFormFile("file", "users.csv")
+	_, _ = part.Write([]byte("name,email\nX,x@example.com\nY,y@example.com\n"))
+	_ = mw.Close()
+
+	pub := &mockPublisher{}
+	w := doBulk(t, NewUserHandler(db, pub), "/users:bulk", mw.FormDataContentType(), buf.Bytes())
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result models.BulkImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Succeeded != 2 {
+		t.Errorf("expected 2 succeeded, got %+v", result)
+	}
+	if len(pub.published) != 2 {
+		t.Errorf("expected 2 published events, got %d", len(pub.published))
+	}
+}
+
+func TestBulkCreateUsers_AsyncJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO import_jobs").
+		WithArgs(sqlmock.AnyArg(), models.ImportJobPending, 0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	w := doBulk(t, handler, "/users:bulk?async=true", "application/x-ndjson",
+		[]byte("{\"email\":\"n@example.com\",\"name\":\"N\"}\n"))
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var job models.ImportJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	// The total is known once the job has read the stored upload
+	if job.ID == "" || job.Status != models.ImportJobPending || job.Total != 0 {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if loc := w.Header().Get("Location"); loc != "/imports/"+job.ID {
+		t.Errorf("unexpected Location header %q", loc)
+	}
+}
+
+func TestBulkCreateUsers_BadInput(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, &mockPublisher{})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"unsupported type", "text/plain", "hello"},
+		{"bad json", "application/json", "{"},
+		{"csv missing column", "text/csv", "email\na@example.com\n"},
+		{"empty array", "application/json", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doBulk(t, handler, "/users:bulk", tt.contentType, []byte(tt.body))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBulkCreateUsers_AsyncFlag(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	w := doBulk(t, handler, "/users:bulk?async=yes", "application/json", []byte(`[{"email":"a@example.com","name":"A"}]`))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a non-boolean async, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = doBulk(t, handler, "/users:merge", "application/json", []byte(`[]`))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown action, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRunSpooledImportJob_InvalidUploadFailsJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	spool := filepath.Join(t.TempDir(), "import")
+	if err := os.WriteFile(spool, []byte("email\na@example.com\n"), 0o600); err != nil {
+		t.Fatalf("failed to write spool file: %v", err)
+	}
+	mock.ExpectExec("UPDATE import_jobs SET status = \\$1, result = \\$2, error = NULLIF\\(\\$3, ''\\)").
+		WithArgs(models.ImportJobFailed, sqlmock.AnyArg(), `CSV header must include "email" and "name" columns`, sqlmock.AnyArg(), "job-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	handler.runSpooledImportJob(context.Background(), "job-1", formatCSV, spool, origin{})
+
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("expected the spool file to be removed, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestBulkCreateUsers_LongNDJSONLine(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users .* ON CONFLICT DO NOTHING RETURNING email").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("big@example.com"))
+	mock.ExpectQuery("INSERT INTO events").WillReturnRows(sqlmock.NewRows([]string{"event_id", "stream_version"}))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Well past bufio.Scanner's default 64KB token limit
+	big := strings.Repeat("x", 100*1024)
+	line := `{"email":"big@example.com","name":"Big","attributes":{"bio":"` + big + `"}}` + "\n"
+	w := doBulk(t, NewUserHandler(db, &mockPublisher{}), "/users:bulk", "application/x-ndjson", []byte(line))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result models.BulkImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Succeeded != 1 {
+		t.Errorf("expected the long line to be imported, got %+v", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+
+	tooLong := `{"email":"huge@example.com","name":"` + strings.Repeat("x", maxBulkLineBytes) + `"}` + "\n"
+	w = doBulk(t, NewUserHandler(db, &mockPublisher{}), "/users:bulk", "application/x-ndjson", []byte(tooLong))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "line 1: longer than") {
+		t.Errorf("expected a 400 naming the line, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStopImports_FailsInterruptedJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// The job is cancelled before it starts: only the outcome reaches the
+	// database, through a context that outlives the cancellation
+	mock.ExpectExec("UPDATE import_jobs SET status = \\$1, result = \\$2, error = NULLIF\\(\\$3, ''\\)").
+		WithArgs(models.ImportJobFailed, sqlmock.AnyArg(), "interrupted by shutdown", sqlmock.AnyArg(), "job-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	ctx := context.Background()
+	if err := handler.StopImports(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	rows := []models.CreateUserRequest{{Email: "a@example.com", Name: "A"}}
+	handler.imports.run(ctx, func(ctx context.Context) { handler.runImportJob(ctx, "job-1", rows, origin{}) })
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := handler.StopImports(waitCtx); err != nil {
+		t.Fatalf("expected the job to stop, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestFailInterruptedImports(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE import_jobs SET status = \\$1, .* WHERE status IN \\(\\$2, \\$3\\) AND COALESCE\\(heartbeat_at, created_at\\) < \\$4").
+		WithArgs(models.ImportJobFailed, models.ImportJobPending, models.ImportJobRunning, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	n, err := NewUserHandler(db, &mockPublisher{}).FailInterruptedImports(context.Background())
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 jobs failed, got %d, %v", n, err)
+	}
+}
+
+func TestGetImportJob_Completed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	result, _ := json.Marshal(models.BulkImportResult{Total: 2, Succeeded: 2})
+	mock.ExpectQuery("SELECT id, status, total, result, error, created_at, finished_at FROM import_jobs").
+		WithArgs("job-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "total", "result", "error", "created_at", "finished_at"}).
+			AddRow("job-1", models.ImportJobCompleted, 2, result, nil, now, now))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/imports/job-1", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var job models.ImportJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if job.Result == nil || job.Result.Succeeded != 2 || job.FinishedAt == nil {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
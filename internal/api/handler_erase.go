@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+	"awesomeProject/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EraseUser godoc
+// @Summary      Erase a user's personal data
+// @Description  GDPR erasure: pseudonymises the user, scrubs personal data from the user's stored events, audit entries, idempotent responses and import results, marks the user deleted and publishes a user.erased event so consumers scrub their copies. Users in any status, including deleted ones, can be erased once
+// @Tags         users
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  models.User
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/{id}/erase [post]
+func (h *UserHandler) EraseUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("id")
+	logger := middleware.Logger(c).With(logging.KeyUserID, userID)
+
+	var erasedAt sql.NullTime
+	user, err := scanUser(h.DB.QueryRowContext(ctx, "SELECT "+userColumns+", erased_at FROM users WHERE id = $1", userID), &erasedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
+		return
+	}
+	// Any status can be erased: deleted users (including those removed by
+	// a compensated onboarding saga) still hold their personal data
+	if erasedAt.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is already erased"})
+		return
+	}
+
+	now := time.Now()
+	erased := pseudonymise(user, now)
+	o := originFrom(c)
+	event := newUserEvent(ctx, models.EventUserErased, o.Actor, erased)
+
+	err = h.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE users SET email = $1, name = $2, status = $3, phone = '', locale = '', timezone = '',
+				marketing_email = FALSE, marketing_sms = FALSE, attributes = '{}', updated_at = $4, erased_at = $4
+			WHERE id = $5 AND status = $6 AND erased_at IS NULL`,
+			erased.Email, erased.Name, erased.Status, now, user.ID, user.Status,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errStatusChanged
+		}
+		if _, err := h.Events.ScrubStream(ctx, tx, user.ID, erased); err != nil {
+			return err
+		}
+		if _, err := h.Audit.ScrubUser(ctx, tx, user.ID); err != nil {
+			return err
+		}
+		if err := scrubIdempotentResponses(ctx, tx, user.ID, erased); err != nil {
+			return err
+		}
+		if err := scrubImportResults(ctx, tx, user, erased); err != nil {
+			return err
+		}
+		if user.Status == models.UserPending {
+			// The reminders carry the user's personal data too
+			if err := h.cancelReminders(ctx, tx, user.ID); err != nil {
+				return err
+			}
+		}
+		stored, err := h.Events.Append(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+		event = stored.Event
+		// No before snapshot: it would put the erased data straight back
+		return h.Audit.Append(ctx, tx, auditEntry(event, nil, o))
+	})
+	if isConcurrentChange(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "user changed concurrently, retry"})
+		return
+	}
+	if err != nil {
+		logger.Error("error erasing user", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to erase user"})
+		return
+	}
+
+	h.publish(ctx, event)
+
+	logger.Info("user erased")
+	c.JSON(http.StatusOK, erased)
+}
+
+// pseudonymise returns u with every personal field replaced. The email stays
+// unique (and undeliverable, .invalid is reserved) so the email index holds.
+func pseudonymise(u models.User, now time.Time) models.User {
+	return models.User{
+		ID:        u.ID,
+		Email:     "erased+" + u.ID + "@erased.invalid",
+		Name:      "erased",
+		Status:    models.UserDeleted,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: now,
+	}
+}
+
+// scrubIdempotentResponses replaces stored POST /users responses for the
+// user, which hold the user as created, with the erased user. Retries with
+// the same Idempotency-Key still get a response, without the personal data.
+func scrubIdempotentResponses(ctx context.Context, tx *sql.Tx, userID string, erased models.User) error {
+	body, err := json.Marshal(erased)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE request_idempotency SET response_body = $2 WHERE position($1::bytea in response_body) > 0",
+		[]byte(`"id":"`+userID+`"`), body,
+	)
+	return err
+}
+
+// scrubImportResults replaces the user's email in the per-row results of
+// bulk import jobs: the row that created the user and any rejected row
+// that carried the same address.
+func scrubImportResults(ctx context.Context, tx *sql.Tx, user, erased models.User) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE import_jobs SET result = jsonb_set(result, '{results}', (
+			SELECT jsonb_agg(CASE WHEN r->>'id' = $1 OR r->>'email' = $2
+				THEN r || jsonb_build_object('email', $3::text) ELSE r END ORDER BY n)
+			FROM jsonb_array_elements(result->'results') WITH ORDINALITY AS rows(r, n)))
+		WHERE result->'results' @> jsonb_build_array(jsonb_build_object('id', $1::text))
+			OR result->'results' @> jsonb_build_array(jsonb_build_object('email', $2::text))`,
+		user.ID, user.Email, erased.Email,
+	)
+	return err
+}
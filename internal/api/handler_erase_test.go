@@ -0,0 +1,210 @@
+package api
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectUserToErase expects EraseUser's lookup of a user with the given
+// status; erasedAt is nil for a user not yet erased.
+func expectUserToErase(mock sqlmock.Sqlmock, id, status string, erasedAt interface{}) {
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+), erased_at FROM users WHERE id = \\$1").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{}, userColumnNames...), "erased_at")).
+			AddRow(id, id+"@example.com", "Test User", status, "", "", "", false, false, []byte("{}"), now, now, erasedAt))
+}
+
+// expectErasure expects the erasure transaction up to the event append.
+func expectErasure(mock sqlmock.Sqlmock, id string, status models.UserStatus) {
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET email = \\$1, name = \\$2, status = \\$3, .* WHERE id = \\$5 AND status = \\$6 AND erased_at IS NULL").
+		WithArgs("erased+"+id+"@erased.invalid", "erased", models.UserDeleted, sqlmock.AnyArg(), id, status).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE events SET payload").
+		WithArgs(id, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE audit_log SET before = NULL, after = NULL, client_ip = '', user_agent = '' WHERE user_id = \\$1").
+		WithArgs(id).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE request_idempotency SET response_body = \\$2").
+		WithArgs([]byte(`"id":"`+id+`"`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE import_jobs SET result = jsonb_set").
+		WithArgs(id, id+"@example.com", "erased+"+id+"@erased.invalid").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestEraseUser_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserToErase(mock, "user-1", "suspended", nil)
+	expectErasure(mock, "user-1", models.UserSuspended)
+	expectEventAppend(mock, "user.erased")
+	expectAuditAppend(mock, "user.erased")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	w := postTransition(NewRouter(NewUserHandler(db, pub)), "/users/user-1/erase")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 1 || pub.published[0].RoutingKey != "user.erased" {
+		t.Fatalf("expected one user.erased event, got %+v", pub.published)
+	}
+
+	// The erased event itself must not carry personal data
+	var event models.UserEvent
+	if err := json.Unmarshal(pub.published[0].Body, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if strings.Contains(string(pub.published[0].Body), "user-1@example.com") || event.Data.Name != "erased" {
+		t.Errorf("erased event leaks personal data: %s", pub.published[0].Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestEraseUser_DeletedUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Deleted users, e.g. removed by a compensated onboarding saga, still
+	// hold personal data and must be erasable
+	expectUserToErase(mock, "user-1", "deleted", nil)
+	expectErasure(mock, "user-1", models.UserDeleted)
+	expectEventAppend(mock, "user.erased")
+	expectAuditAppend(mock, "user.erased")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	w := postTransition(NewRouter(NewUserHandler(db, pub)), "/users/user-1/erase")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 1 || pub.published[0].RoutingKey != "user.erased" {
+		t.Fatalf("expected one user.erased event, got %+v", pub.published)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestEraseUser_ScrubsIdempotentResponse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	var stored []byte
+	expectUserToErase(mock, "user-1", "active", nil)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE events SET payload").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE audit_log SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE request_idempotency SET response_body = \\$2 WHERE position\\(\\$1::bytea in response_body\\) > 0").
+		WithArgs([]byte(`"id":"user-1"`), bodyCapture{&stored}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE import_jobs SET result").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectEventAppend(mock, "user.erased")
+	expectAuditAppend(mock, "user.erased")
+	mock.ExpectCommit()
+
+	w := postTransition(NewRouter(NewUserHandler(db, &mockPublisher{})), "/users/user-1/erase")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var user models.User
+	if err := json.Unmarshal(stored, &user); err != nil {
+		t.Fatalf("stored response is not a user: %v", err)
+	}
+	if user.ID != "user-1" || user.Email != "erased+user-1@erased.invalid" || user.Name != "erased" {
+		t.Errorf("stored response still holds personal data: %s", stored)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestEraseUser_ScrubsImportResults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserToErase(mock, "user-1", "active", nil)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE events SET payload").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE audit_log SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE request_idempotency SET").WillReturnResult(sqlmock.NewResult(0, 0))
+	// Rows created the user (matched by id) or rejected with the same email
+	mock.ExpectExec("UPDATE import_jobs SET result = jsonb_set\\(result, '\\{results\\}'.*WHEN r->>'id' = \\$1 OR r->>'email' = \\$2.*jsonb_build_object\\('email', \\$3::text\\)").
+		WithArgs("user-1", "user-1@example.com", "erased+user-1@erased.invalid").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	expectEventAppend(mock, "user.erased")
+	expectAuditAppend(mock, "user.erased")
+	mock.ExpectCommit()
+
+	w := postTransition(NewRouter(NewUserHandler(db, &mockPublisher{})), "/users/user-1/erase")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestEraseUser_AlreadyErased(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserToErase(mock, "user-1", "deleted", time.Now())
+
+	pub := &mockPublisher{}
+	w := postTransition(NewRouter(NewUserHandler(db, pub)), "/users/user-1/erase")
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("expected no events, got %d", len(pub.published))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+// bodyCapture is a sqlmock argument matcher that records the []byte it sees.
+type bodyCapture struct{ dst *[]byte }
+
+func (b bodyCapture) Match(v driver.Value) bool {
+	body, ok := v.([]byte)
+	*b.dst = body
+	return ok
+}
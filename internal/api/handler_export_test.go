@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExportUsers_NDJSON(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE users_export NO SCROLL CURSOR FOR SELECT id, email, name, .*, created_at, updated_at FROM users ORDER BY created_at, id").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH FORWARD 500 FROM users_export").
+		WillReturnRows(sqlmock.NewRows(userColumnNames).
+			AddRow(userRow("user-1", "one@example.com", "One", now)...).
+			AddRow(userRow("user-2", "two@example.com", "Two", now)...))
+	mock.ExpectRollback()
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/export", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	var ids []string
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		var u models.User
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, u.ID)
+	}
+	if strings.Join(ids, ",") != "user-1,user-2" {
+		t.Errorf("unexpected exported users: %v", ids)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestExportUsers_CSVWithFilter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE users_export .* WHERE \\(name ILIKE \\$1 OR email ILIKE \\$1\\)").
+		WithArgs("%ann%").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH FORWARD").
+		WillReturnRows(sqlmock.NewRows(userColumnNames).
+			AddRow(userRow("user-1", "ann@example.com", "Ann", created)...))
+	mock.ExpectRollback()
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/export?format=csv&q=ann", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	expected := "id,email,name,status,phone,locale,timezone,marketing_email,marketing_sms,attributes,created_at,updated_at\n" +
+		"user-1,ann@example.com,Ann,active,,,,false,false,,2026-01-02T03:04:05Z,2026-01-02T03:04:05Z\n"
+	if w.Body.String() != expected {
+		t.Errorf("expected body %q, got %q", expected, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestExportUsers_BadParams(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	for _, url := range []string{"/users/export?format=xml", "/users/export?created_after=yesterday", "/users/export?status=gone"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", url, w.Code)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListUserSagas godoc
+// @Summary      List a user's sagas
+// @Description  Returns the progress of the user's multi-step workflows (e.g. onboarding), oldest first: each step's status and timings, the current step's deadline, and what failed if the saga was compensated
+// @Tags         users
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {array}   saga.Saga
+// @Failure      500  {object}  map[string]string
+// @Router       /users/{id}/sagas [get]
+func (h *UserHandler) ListUserSagas(c *gin.Context) {
+	userID := c.Param("id")
+
+	sagas, err := h.Sagas.ListByUser(c.Request.Context(), userID)
+	if err != nil {
+		middleware.Logger(c).Error("error listing sagas", logging.KeyUserID, userID, logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sagas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sagas)
+}
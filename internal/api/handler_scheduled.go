@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListScheduledMessages godoc
+// @Summary      List pending scheduled messages
+// @Description  Returns messages waiting to be published (e.g. user.reminder), soonest first, with the attempts and last error of any failed publish
+// @Tags         scheduled-messages
+// @Produce      json
+// @Param        key          query     string  false  "Only messages with this key, e.g. reminder:<user id>"
+// @Param        routing_key  query     string  false  "Only messages with this routing key"
+// @Param        limit        query     int     false  "Maximum messages (1-1000, default 100)"
+// @Success      200          {array}   rabbitmq.ScheduledMessage
+// @Failure      400          {object}  map[string]string
+// @Failure      500          {object}  map[string]string
+// @Router       /scheduled-messages [get]
+func (h *UserHandler) ListScheduledMessages(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	msgs, err := h.Scheduler.List(c.Request.Context(), c.Query("key"), c.Query("routing_key"), limit)
+	if err != nil {
+		middleware.Logger(c).Error("error listing scheduled messages", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch scheduled messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, msgs)
+}
+
+// CancelScheduledMessage godoc
+// @Summary      Cancel a scheduled message
+// @Description  Drops a message that has not been published yet
+// @Tags         scheduled-messages
+// @Param        id   path  string  true  "Scheduled message ID"
+// @Success      204
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /scheduled-messages/{id} [delete]
+func (h *UserHandler) CancelScheduledMessage(c *gin.Context) {
+	id := c.Param("id")
+
+	cancelled, err := h.Scheduler.Cancel(c.Request.Context(), h.DB, id)
+	if err != nil {
+		middleware.Logger(c).Error("error cancelling scheduled message", "scheduled_id", id, logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel scheduled message"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "scheduled message not found"})
+		return
+	}
+
+	middleware.Logger(c).Info("scheduled message cancelled", "scheduled_id", id)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// publishAtArg matches a publish time roughly d from now.
+type publishAtArg struct {
+	d time.Duration
+}
+
+func (a publishAtArg) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	return ok && time.Until(at) > a.d-time.Minute && time.Until(at) <= a.d
+}
+
+func TestCreateUser_PendingSchedulesReminder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEventAppend(mock, "user.created")
+	mock.ExpectExec("INSERT INTO scheduled_messages").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "user.reminder", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			publishAtArg{DefaultPendingReminderAfter}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(mock, "user.created")
+	mock.ExpectCommit()
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	body := `{"email":"new@example.com","name":"New User","status":"pending"}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestListScheduledMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	reminder, _ := json.Marshal(models.UserEvent{EventID: "evt-2", EventType: models.EventUserReminder})
+	mock.ExpectQuery("FROM scheduled_messages WHERE TRUE AND key = \\$1 ORDER BY publish_at, id LIMIT \\$2").
+		WithArgs("reminder:user-1", 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key", "routing_key", "body", "correlation_id", "message_id",
+			"publish_at", "attempts", "last_error", "created_at"}).
+			AddRow("sched-1", "reminder:user-1", "user.reminder", reminder, "corr-1", "evt-2", now.Add(time.Hour), 0, "", now))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/scheduled-messages?key=reminder:user-1", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var msgs []rabbitmq.ScheduledMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &msgs); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "sched-1" || msgs[0].RoutingKey != "user.reminder" {
+		t.Errorf("unexpected messages: %+v", msgs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCancelScheduledMessage_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM scheduled_messages WHERE id = \\$1").
+		WithArgs("missing").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/scheduled-messages/missing", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
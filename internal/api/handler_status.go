@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"awesomeProject/pkg/eventstore"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+	"awesomeProject/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errStatusChanged means another request moved the user first.
+var errStatusChanged = errors.New("user status changed concurrently")
+
+// isConcurrentChange reports whether err means another request changed the
+// user first, so the client should retry.
+func isConcurrentChange(err error) bool {
+	return errors.Is(err, errStatusChanged) || errors.Is(err, eventstore.ErrVersionConflict)
+}
+
+// SuspendUser godoc
+// @Summary      Suspend a user
+// @Description  Moves an active user to suspended and publishes a user.suspended event
+// @Tags         users
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  models.User
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/{id}/suspend [post]
+func (h *UserHandler) SuspendUser(c *gin.Context) {
+	h.transitionUser(c, models.UserSuspended, models.EventUserSuspended)
+}
+
+// ReactivateUser godoc
+// @Summary      Reactivate a user
+// @Description  Moves a suspended (or pending) user to active and publishes a user.reactivated event
+// @Tags         users
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  models.User
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/{id}/reactivate [post]
+func (h *UserHandler) ReactivateUser(c *gin.Context) {
+	h.transitionUser(c, models.UserActive, models.EventUserReactivated)
+}
+
+// transitionError means the state machine forbids the change.
+type transitionError struct {
+	from, to models.UserStatus
+}
+
+func (e *transitionError) Error() string {
+	return fmt.Sprintf("cannot change status from %s to %s", e.from, e.to)
+}
+
+// transitionUser moves the user in the path to status `to` if the state
+// machine allows it, recording and publishing eventType.
+func (h *UserHandler) transitionUser(c *gin.Context, to models.UserStatus, eventType models.EventType) {
+	userID := c.Param("id")
+	logger := middleware.Logger(c).With(logging.KeyUserID, userID)
+
+	user, err := h.changeStatus(c.Request.Context(), userID, to, eventType, originFrom(c))
+	var te *transitionError
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.As(err, &te):
+		c.JSON(http.StatusConflict, gin.H{"error": te.Error(), "status": te.from})
+	case isConcurrentChange(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "user changed concurrently, retry"})
+	case err != nil:
+		logger.Error("error changing user status", "to", string(to), logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user status"})
+	default:
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// changeStatus moves userID to status `to` if the state machine allows it,
+// recording and publishing eventType on behalf of o. It returns
+// sql.ErrNoRows for an unknown user, a *transitionError for a forbidden
+// change and errStatusChanged (or eventstore.ErrVersionConflict) if a
+// concurrent change won.
+func (h *UserHandler) changeStatus(ctx context.Context, userID string, to models.UserStatus, eventType models.EventType, o origin) (models.User, error) {
+	logger := logging.FromContext(ctx).With(logging.KeyUserID, userID)
+
+	user, err := scanUser(h.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", userID))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	from := user.Status
+	before := user
+	if !from.CanTransition(to) {
+		return models.User{}, &transitionError{from: from, to: to}
+	}
+
+	user.Status = to
+	user.UpdatedAt = time.Now()
+	event := newUserEvent(ctx, eventType, o.Actor, user)
+
+	// The status guard makes concurrent transitions of the same user safe
+	err = h.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE users SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4",
+			to, user.UpdatedAt, user.ID, from,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errStatusChanged
+		}
+		stored, err := h.Events.Append(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+		event = stored.Event
+		if from == models.UserPending {
+			if err := h.cancelReminders(ctx, tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return h.Audit.Append(ctx, tx, auditEntry(event, &before, o))
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	h.publish(ctx, event)
+
+	logger.Info("user status changed", "from", string(from), "to", string(to))
+	return user, nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectUserWithStatus registers the user lookup a transition starts with.
+func expectUserWithStatus(mock sqlmock.Sqlmock, id, status string) {
+	row := userRow(id, id+"@example.com", "User", time.Now())
+	row[3] = status
+	mock.ExpectQuery("SELECT id, email, name, status, .* FROM users WHERE id = \\$1").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(userColumnNames).AddRow(row...))
+}
+
+func postTransition(router http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSuspendUser_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserWithStatus(mock, "user-1", "active")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET status = \\$1, updated_at = \\$2 WHERE id = \\$3 AND status = \\$4").
+		WithArgs(models.UserSuspended, sqlmock.AnyArg(), "user-1", models.UserActive).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEventAppend(mock, "user.suspended")
+	expectAuditAppend(mock, "user.suspended")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	w := postTransition(NewRouter(NewUserHandler(db, pub)), "/users/user-1/suspend")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var user models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if user.Status != models.UserSuspended {
+		t.Errorf("expected status suspended, got %s", user.Status)
+	}
+	if len(pub.published) != 1 || pub.published[0].RoutingKey != "user.suspended" {
+		t.Errorf("expected one user.suspended event, got %+v", pub.published)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReactivateUser_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserWithStatus(mock, "user-1", "suspended")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET status").
+		WithArgs(models.UserActive, sqlmock.AnyArg(), "user-1", models.UserSuspended).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEventAppend(mock, "user.reactivated")
+	expectAuditAppend(mock, "user.reactivated")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	w := postTransition(NewRouter(NewUserHandler(db, pub)), "/users/user-1/reactivate")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 1 || pub.published[0].RoutingKey != "user.reactivated" {
+		t.Errorf("expected one user.reactivated event, got %+v", pub.published)
+	}
+}
+
+func TestTransitionUser_NotAllowed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserWithStatus(mock, "user-1", "active")
+
+	pub := &mockPublisher{}
+	w := postTransition(NewRouter(NewUserHandler(db, pub)), "/users/user-1/reactivate")
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("expected no events, got %d", len(pub.published))
+	}
+}
+
+func TestTransitionUser_ConcurrentChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserWithStatus(mock, "user-1", "active")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET status").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	w := postTransition(NewRouter(NewUserHandler(db, &mockPublisher{})), "/users/user-1/suspend")
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestTransitionUser_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM users WHERE id = \\$1").
+		WillReturnRows(sqlmock.NewRows(userColumnNames))
+
+	w := postTransition(NewRouter(NewUserHandler(db, &mockPublisher{})), "/users/missing/suspend")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
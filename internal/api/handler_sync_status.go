@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSyncStatus godoc
+// @Summary      Get a user's downstream sync status
+// @Description  Reports, per downstream (crm, analytics), the latest of the user's events it applied and when, and its latest failure. A downstream is synced once it has applied the user's latest event, failed if its last failure is newer than its last success, and pending otherwise.
+// @Tags         users
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  syncstatus.Report
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/{id}/sync-status [get]
+func (h *UserHandler) GetSyncStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("id")
+	logger := middleware.Logger(c).With(logging.KeyUserID, userID)
+
+	var exists bool
+	if err := h.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		logger.Error("error fetching user", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	latest, err := h.Events.LatestEventID(ctx, userID)
+	if err != nil {
+		logger.Error("error fetching latest event", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sync status"})
+		return
+	}
+	report, err := h.SyncStatus.Report(ctx, userID, latest)
+	if err != nil {
+		logger.Error("error fetching sync status", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sync status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/syncstatus"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetSyncStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	applied := time.Now().Add(-time.Minute)
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE id = \\$1\\)").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT event_id FROM events WHERE stream_id = \\$1 ORDER BY stream_version DESC LIMIT 1").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow("evt-2"))
+	mock.ExpectQuery("SELECT downstream, .* FROM sync_status WHERE user_id = \\$1").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"downstream", "last_event_id", "last_event_type", "last_event_version", "last_applied_at",
+			"failed_event_id", "failed_event_type", "failed_event_version", "failure_error", "failed_at"}).
+			AddRow("crm", "evt-2", "user.updated", 2, applied, nil, nil, 0, nil, nil))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/user-1/sync-status", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report syncstatus.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if report.UserID != "user-1" || report.LatestEventID != "evt-2" || len(report.Downstreams) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if crm := report.Downstreams[0]; crm.Downstream != "crm" || crm.State != syncstatus.StateSynced || crm.LastEventID != "evt-2" {
+		t.Errorf("expected crm synced at evt-2, got %+v", crm)
+	}
+	if an := report.Downstreams[1]; an.Downstream != "analytics" || an.State != syncstatus.StatePending {
+		t.Errorf("expected analytics pending, got %+v", an)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestGetSyncStatus_UserNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE id = \\$1\\)").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/missing/sync-status", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,91 +1,197 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"awesomeProject/pkg/audit"
+	"awesomeProject/pkg/eventstore"
+	"awesomeProject/pkg/health"
+	"awesomeProject/pkg/logging"
 	"awesomeProject/pkg/middleware"
 	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/postgres"
+	"awesomeProject/pkg/rabbitmq"
+	"awesomeProject/pkg/saga"
+	"awesomeProject/pkg/syncstatus"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // EventPublisher defines the interface for publishing events.
 type EventPublisher interface {
-	Publish(routingKey string, body []byte, correlationID string) error
+	Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error
 }
 
 // UserHandler handles user-related HTTP requests.
 type UserHandler struct {
 	DB        *sql.DB
 	Publisher EventPublisher
+	Events    *eventstore.Store
+	Audit     *audit.Log
+
+	// SyncStatus backs /users/:id/sync-status; main feeds it from the
+	// consumers' result events
+	SyncStatus *syncstatus.Store
+
+	// Sagas backs /users/:id/sagas; main replaces it with an engine running
+	// the Onboarding saga
+	Sagas *saga.Engine
+
+	// Scheduler holds delayed messages until main's ticker publishes them
+	Scheduler *rabbitmq.Scheduler
+
+	// PendingReminderAfter is when a user created pending gets a
+	// user.reminder; zero disables reminders
+	PendingReminderAfter time.Duration
+
+	// FoldPlusAddressing drops "+tag" suffixes when normalising emails
+	FoldPlusAddressing bool
+
+	// IdempotencyTTL is how long Idempotency-Key responses are replayed
+	IdempotencyTTL time.Duration
+
+	// AttributeSchema validates profile attributes; nil accepts any
+	AttributeSchema *models.AttributeSchema
+
+	// Authenticators guard the /users and /imports routes; none leaves
+	// them open
+	Authenticators []middleware.Authenticator
+
+	// RateLimitStore throttles each client per route group; nil disables
+	// rate limiting
+	RateLimitStore        middleware.RateLimitStore
+	ReadLimit, WriteLimit middleware.Limit
+
+	// Health backs /livez and /readyz; it checks the database, and callers
+	// add checks for the other dependencies
+	Health *health.Checker
+
+	// imports tracks the async import jobs running in this process
+	imports *importJobs
+}
+
+// userColumns is the users column list, in scanUser and userArgs order.
+const userColumns = "id, email, name, status, phone, locale, timezone, marketing_email, marketing_sms, attributes, created_at, updated_at"
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads one row selected with userColumns, followed by any extra
+// columns into extra.
+func scanUser(row rowScanner, extra ...interface{}) (models.User, error) {
+	var u models.User
+	dest := []interface{}{&u.ID, &u.Email, &u.Name, &u.Status, &u.Phone, &u.Locale, &u.Timezone,
+		&u.MarketingConsent.Email, &u.MarketingConsent.SMS, &u.Attributes, &u.CreatedAt, &u.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return u, err
+}
+
+// userArgs returns u's values in userColumns order.
+func userArgs(u models.User) []interface{} {
+	return []interface{}{u.ID, u.Email, u.Name, u.Status, u.Phone, u.Locale, u.Timezone,
+		u.MarketingConsent.Email, u.MarketingConsent.SMS, u.Attributes, u.CreatedAt, u.UpdatedAt}
 }
 
 // NewUserHandler creates a new UserHandler.
 func NewUserHandler(db *sql.DB, pub EventPublisher) *UserHandler {
-	return &UserHandler{DB: db, Publisher: pub}
+	checker := health.NewChecker()
+	checker.Add("postgres", postgres.Check(db))
+	return &UserHandler{
+		DB:                   db,
+		Publisher:            pub,
+		Events:               eventstore.New(db),
+		Audit:                audit.New(db),
+		SyncStatus:           syncstatus.New(db),
+		Sagas:                saga.NewEngine(db),
+		Scheduler:            rabbitmq.NewScheduler(db, pub),
+		PendingReminderAfter: DefaultPendingReminderAfter,
+		IdempotencyTTL:       middleware.DefaultIdempotencyTTL,
+		ReadLimit:            middleware.PerMinute(600),
+		WriteLimit:           middleware.PerMinute(120),
+		Health:               checker,
+		imports:              newImportJobs(),
+	}
 }
 
 // CreateUser godoc
 // @Summary      Create a new user
-// @Description  Creates a new user and publishes a user.created event
+// @Description  Creates a new user and publishes a user.created event. Emails are normalised (trimmed, lowercased) and must be unique.
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        request  body      models.CreateUserRequest  true  "Create user request"
-// @Success      201      {object}  models.User
-// @Failure      400      {object}  map[string]string
-// @Failure      500      {object}  map[string]string
+// @Param        Idempotency-Key  header    string                    false  "Replays the original response for retries with the same key"
+// @Param        request          body      models.CreateUserRequest  true   "Create user request"
+// @Success      201              {object}  models.User
+// @Failure      400              {object}  map[string]string
+// @Failure      409              {object}  map[string]string
+// @Failure      422              {object}  map[string]string
+// @Failure      429              {object}  map[string]string
+// @Failure      500              {object}  map[string]string
 // @Router       /users [post]
 func (h *UserHandler) CreateUser(c *gin.Context) {
-	correlationID := middleware.GetCorrelationID(c)
-	log.Printf("[API] CreateUser correlation_id=%s", correlationID)
+	ctx := c.Request.Context()
+	logger := middleware.Logger(c)
 
 	var req models.CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	user := models.User{
-		ID:        uuid.New().String(),
-		Email:     req.Email,
-		Name:      req.Name,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if err := h.AttributeSchema.Validate(req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Insert into database
-	_, err := h.DB.Exec(
-		"INSERT INTO users (id, email, name, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)",
-		user.ID, user.Email, user.Name, user.CreatedAt, user.UpdatedAt,
-	)
+	user := h.newUser(req, time.Now())
+
+	o := originFrom(c)
+	event := newUserEvent(ctx, models.EventUserCreated, o.Actor, user)
+
+	// Insert user and record the event atomically
+	err := h.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO users ("+userColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+			userArgs(user)...,
+		)
+		if err != nil {
+			return err
+		}
+		stored, err := h.Events.Append(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+		event = stored.Event
+		if err := h.scheduleReminders(ctx, tx, []models.UserEvent{event}); err != nil {
+			return err
+		}
+		return h.Audit.Append(ctx, tx, auditEntry(event, nil, o))
+	})
+	if isEmailConflict(err) {
+		h.respondEmailConflict(c, user.Email)
+		return
+	}
 	if err != nil {
-		log.Printf("[API] Error creating user: %v correlation_id=%s", err, correlationID)
+		logger.Error("error creating user", logging.KeyError, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
 		return
 	}
 
-	// Publish event
-	event := models.UserEvent{
-		EventID:       uuid.New().String(),
-		CorrelationID: correlationID,
-		EventType:     models.EventUserCreated,
-		Timestamp:     time.Now(),
-		Data:          user,
-	}
+	// Publish event — don't fail the request, the event is in the store
+	h.publish(ctx, event)
 
-	eventBytes, _ := json.Marshal(event)
-	if err := h.Publisher.Publish(string(models.EventUserCreated), eventBytes, correlationID); err != nil {
-		log.Printf("[API] Error publishing event: %v correlation_id=%s", err, correlationID)
-		// Don't fail the request — event will be missed but user is created
-	}
-
-	log.Printf("[API] User created: id=%s email=%s correlation_id=%s", user.ID, user.Email, correlationID)
+	logger.Info("user created", logging.KeyUserID, user.ID)
 	c.JSON(http.StatusCreated, user)
 }
 
@@ -100,12 +206,13 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 // @Success      200      {object}  models.User
 // @Failure      400      {object}  map[string]string
 // @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string  "Email taken or user deleted"
 // @Failure      500      {object}  map[string]string
 // @Router       /users/{id} [put]
 func (h *UserHandler) UpdateUser(c *gin.Context) {
-	correlationID := middleware.GetCorrelationID(c)
+	ctx := c.Request.Context()
 	userID := c.Param("id")
-	log.Printf("[API] UpdateUser id=%s correlation_id=%s", userID, correlationID)
+	logger := middleware.Logger(c).With(logging.KeyUserID, userID)
 
 	var req models.UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -114,9 +221,7 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	}
 
 	// Get current user
-	var user models.User
-	err := h.DB.QueryRow("SELECT id, email, name, created_at, updated_at FROM users WHERE id = $1", userID).
-		Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+	user, err := scanUser(h.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", userID))
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
@@ -125,42 +230,78 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
 		return
 	}
+	if user.Status == models.UserDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is deleted"})
+		return
+	}
 
 	// Apply updates
+	before := user
 	if req.Email != "" {
-		user.Email = req.Email
+		user.Email = h.normalizeEmail(req.Email)
 	}
 	if req.Name != "" {
 		user.Name = req.Name
 	}
+	if req.Phone != nil {
+		user.Phone = *req.Phone
+	}
+	if req.Locale != nil {
+		user.Locale = *req.Locale
+	}
+	if req.Timezone != nil {
+		user.Timezone = *req.Timezone
+	}
+	if req.MarketingConsent != nil {
+		user.MarketingConsent = *req.MarketingConsent
+	}
+	if req.Attributes != nil {
+		user.Attributes = user.Attributes.Merge(req.Attributes)
+		if err := h.AttributeSchema.Validate(user.Attributes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	user.UpdatedAt = time.Now()
 
-	// Update in database
-	_, err = h.DB.Exec(
-		"UPDATE users SET email = $1, name = $2, updated_at = $3 WHERE id = $4",
-		user.Email, user.Name, user.UpdatedAt, user.ID,
-	)
+	o := originFrom(c)
+	event := newUserEvent(ctx, models.EventUserUpdated, o.Actor, user)
+
+	// Update in database and record the event atomically
+	err = h.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET email = $1, name = $2, phone = $3, locale = $4, timezone = $5,
+				marketing_email = $6, marketing_sms = $7, attributes = $8, updated_at = $9 WHERE id = $10`,
+			user.Email, user.Name, user.Phone, user.Locale, user.Timezone,
+			user.MarketingConsent.Email, user.MarketingConsent.SMS, user.Attributes, user.UpdatedAt, user.ID,
+		)
+		if err != nil {
+			return err
+		}
+		stored, err := h.Events.Append(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+		event = stored.Event
+		return h.Audit.Append(ctx, tx, auditEntry(event, &before, o))
+	})
+	if isEmailConflict(err) {
+		h.respondEmailConflict(c, user.Email)
+		return
+	}
+	if isConcurrentChange(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "user changed concurrently, retry"})
+		return
+	}
 	if err != nil {
-		log.Printf("[API] Error updating user: %v correlation_id=%s", err, correlationID)
+		logger.Error("error updating user", logging.KeyError, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
 		return
 	}
 
-	// Publish event
-	event := models.UserEvent{
-		EventID:       uuid.New().String(),
-		CorrelationID: correlationID,
-		EventType:     models.EventUserUpdated,
-		Timestamp:     time.Now(),
-		Data:          user,
-	}
-
-	eventBytes, _ := json.Marshal(event)
-	if err := h.Publisher.Publish(string(models.EventUserUpdated), eventBytes, correlationID); err != nil {
-		log.Printf("[API] Error publishing event: %v correlation_id=%s", err, correlationID)
-	}
+	h.publish(ctx, event)
 
-	log.Printf("[API] User updated: id=%s correlation_id=%s", user.ID, correlationID)
+	logger.Info("user updated")
 	c.JSON(http.StatusOK, user)
 }
 
@@ -176,9 +317,7 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 func (h *UserHandler) GetUser(c *gin.Context) {
 	userID := c.Param("id")
 
-	var user models.User
-	err := h.DB.QueryRow("SELECT id, email, name, created_at, updated_at FROM users WHERE id = $1", userID).
-		Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+	user, err := scanUser(h.DB.QueryRowContext(c.Request.Context(), "SELECT "+userColumns+" FROM users WHERE id = $1", userID))
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
@@ -193,14 +332,27 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 
 // ListUsers godoc
 // @Summary      List all users
-// @Description  Returns all users
+// @Description  Returns all users, optionally filtered
 // @Tags         users
 // @Produce      json
+// @Param        email           query     string  false  "Exact email match"
+// @Param        status          query     string  false  "pending, active, suspended or deleted"
+// @Param        q               query     string  false  "Case-insensitive substring of name or email"
+// @Param        created_after   query     string  false  "RFC3339 lower bound on created_at"
+// @Param        created_before  query     string  false  "RFC3339 upper bound on created_at"
 // @Success      200  {array}   models.User
+// @Failure      400  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /users [get]
 func (h *UserHandler) ListUsers(c *gin.Context) {
-	rows, err := h.DB.Query("SELECT id, email, name, created_at, updated_at FROM users ORDER BY created_at DESC")
+	filter, err := h.parseUserFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where, args := filter.where()
+	rows, err := h.DB.QueryContext(c.Request.Context(), "SELECT "+userColumns+" FROM users"+where+" ORDER BY created_at DESC", args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
 		return
@@ -209,8 +361,8 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 
 	var users []models.User
 	for rows.Next() {
-		var u models.User
-		if err := rows.Scan(&u.ID, &u.Email, &u.Name, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			continue
 		}
 		users = append(users, u)
@@ -222,3 +374,228 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 
 	c.JSON(http.StatusOK, users)
 }
+
+// userFilter holds the optional filters shared by ListUsers and ExportUsers.
+type userFilter struct {
+	Email         string
+	Status        models.UserStatus
+	Query         string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// parseUserFilter reads the list filters from the query string.
+func (h *UserHandler) parseUserFilter(c *gin.Context) (userFilter, error) {
+	f := userFilter{
+		Query: c.Query("q"),
+	}
+	if v := c.Query("email"); v != "" {
+		f.Email = h.normalizeEmail(v)
+	}
+	if v := c.Query("status"); v != "" {
+		switch f.Status = models.UserStatus(v); f.Status {
+		case models.UserPending, models.UserActive, models.UserSuspended, models.UserDeleted:
+		default:
+			return f, fmt.Errorf("status must be pending, active, suspended or deleted")
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"created_after", &f.CreatedAfter},
+		{"created_before", &f.CreatedBefore},
+	} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC3339 timestamp", p.name)
+			}
+			*p.dst = &t
+		}
+	}
+	return f, nil
+}
+
+// where renders the filter as a SQL WHERE clause (empty if unfiltered).
+func (f userFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Email != "" {
+		add("email = $%d", f.Email)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.Query != "" {
+		args = append(args, "%"+f.Query+"%")
+		conds = append(conds, fmt.Sprintf("(name ILIKE $%d OR email ILIKE $%d)", len(args), len(args)))
+	}
+	if f.CreatedAfter != nil {
+		add("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		add("created_at < $%d", *f.CreatedBefore)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// ListUserEvents godoc
+// @Summary      List a user's event history
+// @Description  Returns the events recorded for a user in stream order
+// @Tags         users
+// @Produce      json
+// @Param        id     path      string  true   "User ID"
+// @Param        after  query     int     false  "Only return events after this stream version"
+// @Param        limit  query     int     false  "Maximum number of events (default 100)"
+// @Success      200    {array}   eventstore.StoredEvent
+// @Failure      400    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /users/{id}/events [get]
+func (h *UserHandler) ListUserEvents(c *gin.Context) {
+	userID := c.Param("id")
+
+	after, err := strconv.Atoi(c.DefaultQuery("after", "0"))
+	if err != nil || after < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a non-negative integer"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	events, err := h.Events.ListByStream(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		middleware.Logger(c).Error("error listing user events", logging.KeyUserID, userID, logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// newUser builds a user from a create request.
+func (h *UserHandler) newUser(req models.CreateUserRequest, now time.Time) models.User {
+	status := req.Status
+	if status == "" {
+		status = models.UserActive
+	}
+	return models.User{
+		ID:               uuid.New().String(),
+		Email:            h.normalizeEmail(req.Email),
+		Name:             req.Name,
+		Status:           status,
+		Phone:            req.Phone,
+		Locale:           req.Locale,
+		Timezone:         req.Timezone,
+		MarketingConsent: req.MarketingConsent,
+		Attributes:       req.Attributes,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
+
+// newUserEvent builds the event envelope for a change to user, correlated
+// with the request or import job in ctx.
+func newUserEvent(ctx context.Context, eventType models.EventType, actor *models.Actor, user models.User) models.UserEvent {
+	event := models.NewUserEvent(ctx, eventType, user)
+	event.Actor = actor
+	return event
+}
+
+// origin is who made a request and how, as recorded on events and audit
+// entries. It outlives the Gin context for background imports.
+type origin struct {
+	Actor   *models.Actor
+	Request audit.RequestMeta
+}
+
+// originFrom captures the request's principal (nil if the route is not
+// authenticated) and metadata.
+func originFrom(c *gin.Context) origin {
+	o := origin{Request: audit.RequestMeta{
+		Method:        c.Request.Method,
+		Path:          c.Request.URL.Path,
+		ClientIP:      c.ClientIP(),
+		UserAgent:     c.Request.UserAgent(),
+		CorrelationID: middleware.GetCorrelationID(c),
+	}}
+	if p := middleware.GetPrincipal(c); p != nil {
+		o.Actor = &models.Actor{Type: p.Type, ID: p.ID}
+	}
+	return o
+}
+
+// auditEntry records event as an audit entry. before is the user as it was,
+// nil for creations.
+func auditEntry(event models.UserEvent, before *models.User, o origin) audit.Entry {
+	after := event.Data
+	return audit.Entry{
+		OccurredAt: event.Timestamp,
+		Actor:      o.Actor,
+		Action:     string(event.EventType),
+		UserID:     event.Data.ID,
+		Before:     before,
+		After:      &after,
+		Request:    o.Request,
+	}
+}
+
+// publish sends the event to RabbitMQ, logging (but not returning) failures
+// with ctx's logger.
+func (h *UserHandler) publish(ctx context.Context, event models.UserEvent) {
+	eventBytes, _ := json.Marshal(event)
+	if err := h.Publisher.Publish(ctx, string(event.EventType), eventBytes, event.CorrelationID, event.EventID); err != nil {
+		_, logger := logging.WithEvent(ctx, event)
+		logger.Error("error publishing event", logging.KeyError, err)
+	}
+}
+
+// normalizeEmail canonicalises an email per the handler's folding setting.
+func (h *UserHandler) normalizeEmail(email string) string {
+	return models.NormalizeEmail(email, h.FoldPlusAddressing)
+}
+
+// isEmailConflict reports whether err is a unique violation on users.email.
+// Other unique violations (e.g. concurrent event stream versions) don't match.
+func isEmailConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" &&
+		strings.HasPrefix(pqErr.Constraint, "users_email")
+}
+
+// respondEmailConflict answers 409 with a reference to the user that
+// already owns email.
+func (h *UserHandler) respondEmailConflict(c *gin.Context, email string) {
+	resp := gin.H{"error": "email already in use"}
+	var id string
+	if err := h.DB.QueryRowContext(c.Request.Context(), "SELECT id FROM users WHERE lower(email) = lower($1)", email).Scan(&id); err == nil {
+		resp["user_id"] = id
+		c.Header("Location", "/users/"+id)
+	}
+	c.JSON(http.StatusConflict, resp)
+}
+
+// withTx runs fn inside a transaction, committing on success.
+func (h *UserHandler) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -2,16 +2,21 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"awesomeProject/pkg/eventstore"
 	"awesomeProject/pkg/models"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 func init() {
@@ -28,17 +33,52 @@ type publishedMsg struct {
 	RoutingKey    string
 	Body          []byte
 	CorrelationID string
+	MessageID     string
 }
 
-func (m *mockPublisher) Publish(routingKey string, body []byte, correlationID string) error {
+func (m *mockPublisher) Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error {
 	m.published = append(m.published, publishedMsg{
 		RoutingKey:    routingKey,
 		Body:          body,
 		CorrelationID: correlationID,
+		MessageID:     messageID,
 	})
 	return m.err
 }
 
+// expectEventAppend registers the events-table insert made inside the
+// handler's transaction.
+func expectEventAppend(mock sqlmock.Sqlmock, eventType string) {
+	mock.ExpectQuery("INSERT INTO events").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), eventType, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence", "stream_version", "recorded_at"}).
+			AddRow(1, 1, time.Now()))
+}
+
+// expectAuditAppend registers the audit_log insert that follows an event
+// append.
+func expectAuditAppend(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), action, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// userColumnNames mirrors userColumns for sqlmock rows.
+var userColumnNames = []string{"id", "email", "name", "status", "phone", "locale", "timezone",
+	"marketing_email", "marketing_sms", "attributes", "created_at", "updated_at"}
+
+// userRow returns sqlmock row values for a user with an empty profile.
+func userRow(id, email, name string, ts time.Time) []driver.Value {
+	return []driver.Value{id, email, name, "active", "", "", "", false, false, []byte("{}"), ts, ts}
+}
+
+// insertUserArgs matches the INSERT arguments for a user with an empty profile.
+func insertUserArgs(email, name string) []driver.Value {
+	return []driver.Value{sqlmock.AnyArg(), email, name, models.UserActive, "", "", "", false, false,
+		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}
+}
+
 func TestCreateUser_Success(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -46,9 +86,13 @@ func TestCreateUser_Success(t *testing.T) {
 	}
 	defer db.Close()
 
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO users").
-		WithArgs(sqlmock.AnyArg(), "test@example.com", "Test User", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WithArgs(insertUserArgs("test@example.com", "Test User")...).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEventAppend(mock, "user.created")
+	expectAuditAppend(mock, "user.created")
+	mock.ExpectCommit()
 
 	pub := &mockPublisher{}
 	handler := NewUserHandler(db, pub)
@@ -144,9 +188,9 @@ func TestGetUser_Success(t *testing.T) {
 	defer db.Close()
 
 	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
-		AddRow("user-123", "test@example.com", "Test User", now, now)
-	mock.ExpectQuery("SELECT id, email, name, created_at, updated_at FROM users WHERE id = \\$1").
+	rows := sqlmock.NewRows(userColumnNames).
+		AddRow(userRow("user-123", "test@example.com", "Test User", now)...)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE id = \\$1").
 		WithArgs("user-123").
 		WillReturnRows(rows)
 
@@ -182,8 +226,8 @@ func TestGetUser_NotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"})
-	mock.ExpectQuery("SELECT id, email, name, created_at, updated_at FROM users WHERE id = \\$1").
+	rows := sqlmock.NewRows(userColumnNames)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE id = \\$1").
 		WithArgs("nonexistent").
 		WillReturnRows(rows)
 
@@ -208,10 +252,10 @@ func TestListUsers_Success(t *testing.T) {
 	defer db.Close()
 
 	now := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
-		AddRow("user-1", "one@example.com", "User One", now, now).
-		AddRow("user-2", "two@example.com", "User Two", now, now)
-	mock.ExpectQuery("SELECT id, email, name, created_at, updated_at FROM users ORDER BY created_at DESC").
+	rows := sqlmock.NewRows(userColumnNames).
+		AddRow(userRow("user-1", "one@example.com", "User One", now)...).
+		AddRow(userRow("user-2", "two@example.com", "User Two", now)...)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users ORDER BY created_at DESC").
 		WillReturnRows(rows)
 
 	pub := &mockPublisher{}
@@ -246,8 +290,8 @@ func TestListUsers_Empty(t *testing.T) {
 	}
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"})
-	mock.ExpectQuery("SELECT id, email, name, created_at, updated_at FROM users ORDER BY created_at DESC").
+	rows := sqlmock.NewRows(userColumnNames)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users ORDER BY created_at DESC").
 		WillReturnRows(rows)
 
 	pub := &mockPublisher{}
@@ -279,15 +323,19 @@ func TestUpdateUser_Success(t *testing.T) {
 	defer db.Close()
 
 	now := time.Now()
-	selectRows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
-		AddRow("user-123", "old@example.com", "Old Name", now, now)
-	mock.ExpectQuery("SELECT id, email, name, created_at, updated_at FROM users WHERE id = \\$1").
+	selectRows := sqlmock.NewRows(userColumnNames).
+		AddRow(userRow("user-123", "old@example.com", "Old Name", now)...)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE id = \\$1").
 		WithArgs("user-123").
 		WillReturnRows(selectRows)
 
-	mock.ExpectExec("UPDATE users SET email = \\$1, name = \\$2, updated_at = \\$3 WHERE id = \\$4").
-		WithArgs("new@example.com", "New Name", sqlmock.AnyArg(), "user-123").
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET email = \\$1, name = \\$2, phone = \\$3, .* WHERE id = \\$10").
+		WithArgs("new@example.com", "New Name", "", "", "", false, false, sqlmock.AnyArg(), sqlmock.AnyArg(), "user-123").
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEventAppend(mock, "user.updated")
+	expectAuditAppend(mock, "user.updated")
+	mock.ExpectCommit()
 
 	pub := &mockPublisher{}
 	handler := NewUserHandler(db, pub)
@@ -331,8 +379,8 @@ func TestUpdateUser_NotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"})
-	mock.ExpectQuery("SELECT id, email, name, created_at, updated_at FROM users WHERE id = \\$1").
+	rows := sqlmock.NewRows(userColumnNames)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE id = \\$1").
 		WithArgs("nonexistent").
 		WillReturnRows(rows)
 
@@ -386,9 +434,13 @@ func TestCorrelationIDPassedToEvent(t *testing.T) {
 	}
 	defer db.Close()
 
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO users").
-		WithArgs(sqlmock.AnyArg(), "corr@example.com", "Corr Test", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WithArgs(insertUserArgs("corr@example.com", "Corr Test")...).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEventAppend(mock, "user.created")
+	expectAuditAppend(mock, "user.created")
+	mock.ExpectCommit()
 
 	pub := &mockPublisher{}
 	handler := NewUserHandler(db, pub)
@@ -421,4 +473,405 @@ func TestCorrelationIDPassedToEvent(t *testing.T) {
 	if event.CorrelationID != "test-corr-id-123" {
 		t.Errorf("expected event correlation ID test-corr-id-123, got %s", event.CorrelationID)
 	}
+	if event.CausationID != "" {
+		t.Errorf("expected no causation ID for a request, got %s", event.CausationID)
+	}
+	if pub.published[0].MessageID != event.EventID {
+		t.Errorf("expected message ID %s, got %s", event.EventID, pub.published[0].MessageID)
+	}
+}
+
+func TestCreateUser_DBErrorRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("INSERT INTO events").WillReturnError(errors.New("db down"))
+	mock.ExpectRollback()
+
+	pub := &mockPublisher{}
+	handler := NewUserHandler(db, pub)
+	router := NewRouter(handler)
+
+	body := `{"email":"rb@example.com","name":"Rollback"}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("expected no published messages, got %d", len(pub.published))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestListUserEvents_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	payload, _ := json.Marshal(models.UserEvent{
+		EventID:   "evt-1",
+		EventType: models.EventUserCreated,
+		Data:      models.User{ID: "user-123"},
+	})
+	mock.ExpectQuery("SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events").
+		WithArgs("user-123", 0, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence", "stream_id", "stream_version", "recorded_at", "payload"}).
+			AddRow(42, "user-123", 1, time.Now(), payload))
+
+	pub := &mockPublisher{}
+	handler := NewUserHandler(db, pub)
+	router := NewRouter(handler)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/user-123/events", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var events []eventstore.StoredEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(events) != 1 || events[0].Sequence != 42 || events[0].Event.EventID != "evt-1" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestListUserEvents_BadLimit(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	router := NewRouter(handler)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/user-123/events?limit=abc", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestListUsers_Filtered(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	after := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE email = \\$1 AND created_at >= \\$2 ORDER BY created_at DESC").
+		WithArgs("one@example.com", after).
+		WillReturnRows(sqlmock.NewRows(userColumnNames))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users?email=one@example.com&created_after=2026-01-01T00:00:00Z", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCreateUser_EmailConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs(insertUserArgs("jane@example.com", "Jane")...).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_lower_key"})
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT id FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-existing"))
+
+	pub := &mockPublisher{}
+	handler := NewUserHandler(db, pub)
+	handler.FoldPlusAddressing = true
+	router := NewRouter(handler)
+
+	body := `{"email":"Jane+News@Example.com","name":"Jane"}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp["user_id"] != "user-existing" {
+		t.Errorf("expected conflicting user_id, got %v", resp)
+	}
+	if loc := w.Header().Get("Location"); loc != "/users/user-existing" {
+		t.Errorf("unexpected Location header %q", loc)
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("expected no published messages, got %d", len(pub.published))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateUser_ConcurrentAppendIsConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE id = \\$1").
+		WithArgs("user-123").
+		WillReturnRows(sqlmock.NewRows(userColumnNames).
+			AddRow(userRow("user-123", "old@example.com", "Old", now)...))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO events").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "events_stream_id_stream_version_key"})
+	mock.ExpectRollback()
+
+	pub := &mockPublisher{}
+	router := NewRouter(NewUserHandler(db, pub))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/users/user-123", bytes.NewBufferString(`{"name":"New"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("expected nothing published, got %d", len(pub.published))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateUser_EmailConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT id, email, name, .*, created_at, updated_at FROM users WHERE id = \\$1").
+		WithArgs("user-123").
+		WillReturnRows(sqlmock.NewRows(userColumnNames).
+			AddRow(userRow("user-123", "old@example.com", "Old", now)...))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").
+		WithArgs("taken@example.com", "Old", "", "", "", false, false, sqlmock.AnyArg(), sqlmock.AnyArg(), "user-123").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_key"})
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT id FROM users WHERE lower\\(email\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-456"))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+
+	body := `{"email":"Taken@Example.com"}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/users/user-123", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestIsEmailConflict(t *testing.T) {
+	if !isEmailConflict(&pq.Error{Code: "23505", Constraint: "users_email_key"}) {
+		t.Error("expected users_email_key violation to be an email conflict")
+	}
+	if isEmailConflict(&pq.Error{Code: "23505", Constraint: "events_stream_id_stream_version_key"}) {
+		t.Error("stream version violation must not be an email conflict")
+	}
+	if isEmailConflict(errors.New("db down")) {
+		t.Error("plain error must not be an email conflict")
+	}
+}
+
+func TestCreateUser_ProfileValidation(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	schema, err := models.ParseAttributeSchema([]byte(`{"rules": {"plan": {"type": "string", "enum": ["free", "pro"]}}}`))
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	handler := NewUserHandler(db, &mockPublisher{})
+	handler.AttributeSchema = schema
+	router := NewRouter(handler)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"bad phone", `{"email":"p@example.com","name":"P","phone":"555-1234"}`},
+		{"bad locale", `{"email":"p@example.com","name":"P","locale":"not a locale"}`},
+		{"bad timezone", `{"email":"p@example.com","name":"P","timezone":"Mars/Olympus"}`},
+		{"bad attribute", `{"email":"p@example.com","name":"P","attributes":{"plan":"gold"}}`},
+		{"unknown attribute", `{"email":"p@example.com","name":"P","attributes":{"color":"red"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateUser_WithProfile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs(sqlmock.AnyArg(), "p@example.com", "P", models.UserActive, "+14155550123", "en-US", "America/New_York",
+			true, false, []byte(`{"plan":"pro"}`), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEventAppend(mock, "user.created")
+	expectAuditAppend(mock, "user.created")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	router := NewRouter(NewUserHandler(db, pub))
+
+	body := `{"email":"p@example.com","name":"P","phone":"+14155550123","locale":"en-US",
+		"timezone":"America/New_York","marketing_consent":{"email":true},"attributes":{"plan":"pro"}}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var event models.UserEvent
+	if err := json.Unmarshal(pub.published[0].Body, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if event.Data.Timezone != "America/New_York" || !event.Data.MarketingConsent.Email || event.Data.Attributes["plan"] != "pro" {
+		t.Errorf("event is missing profile fields: %+v", event.Data)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateUser_MergesAttributesAndClearsFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT id, email, name, .* FROM users WHERE id = \\$1").
+		WithArgs("user-123").
+		WillReturnRows(sqlmock.NewRows(userColumnNames).
+			AddRow("user-123", "a@example.com", "A", "active", "+14155550123", "en-US", "UTC", true, true,
+				[]byte(`{"plan":"free","seats":3}`), now, now))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").
+		WithArgs("a@example.com", "A", "", "en-US", "UTC", true, true,
+			[]byte(`{"plan":"pro"}`), sqlmock.AnyArg(), "user-123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEventAppend(mock, "user.updated")
+	expectAuditAppend(mock, "user.updated")
+	mock.ExpectCommit()
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+
+	body := `{"phone":"","attributes":{"plan":"pro","seats":null}}`
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/users/user-123", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateUser_DeletedUserIsConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	row := userRow("user-123", "gone@example.com", "Gone", time.Now())
+	row[3] = "deleted"
+	mock.ExpectQuery("SELECT .* FROM users WHERE id = \\$1").
+		WillReturnRows(sqlmock.NewRows(userColumnNames).AddRow(row...))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/users/user-123", bytes.NewBufferString(`{"name":"Back"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"awesomeProject/pkg/audit"
+	"awesomeProject/pkg/correlation"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/saga"
+)
+
+// OnboardingSaga names the onboarding saga.
+const OnboardingSaga = "onboarding"
+
+// Onboarding defines the onboarding saga, run for each user created as
+// pending: wait for the CRM contact, send the welcome message, then
+// activate the user. If the CRM sync fails or takes longer than
+// stepTimeout the user is deleted, which also retires the CRM contact. A
+// user deleted this way keeps their personal data until erased, which
+// EraseUser allows from any status.
+func (h *UserHandler) Onboarding(stepTimeout time.Duration) *saga.Definition {
+	return &saga.Definition{
+		Name:    OnboardingSaga,
+		StartOn: models.EventUserCreated,
+		Start: func(event models.UserEvent) bool {
+			return event.Data.Status == models.UserPending
+		},
+		Steps: []saga.Step{
+			{
+				// Done by the request that started the saga
+				Name: "create_user",
+				Compensate: func(ctx context.Context, s *saga.Saga) error {
+					return h.sagaChangeStatus(ctx, s, models.UserDeleted, models.EventUserDeleted)
+				},
+			},
+			{
+				Name:        "create_crm_contact",
+				CompletedBy: models.EventCRMContactSynced,
+				FailedBy:    models.EventCRMContactSyncFailed,
+				Timeout:     stepTimeout,
+			},
+			{
+				Name: "send_welcome",
+				Do:   h.sendWelcome,
+			},
+			{
+				Name: "mark_active",
+				Do: func(ctx context.Context, s *saga.Saga) error {
+					return h.sagaChangeStatus(ctx, s, models.UserActive, models.EventUserReactivated)
+				},
+			},
+		},
+	}
+}
+
+// sendWelcome stands in for a welcome message; there is no mail service
+// yet.
+func (h *UserHandler) sendWelcome(ctx context.Context, s *saga.Saga) error {
+	logging.FromContext(ctx).Info("welcome message sent", logging.KeyUserID, s.UserID, "saga_id", s.ID)
+	return nil
+}
+
+// sagaChangeStatus moves the saga's user to status `to` on the saga's
+// behalf. A user already there (moved by hand meanwhile) counts as done.
+func (h *UserHandler) sagaChangeStatus(ctx context.Context, s *saga.Saga, to models.UserStatus, eventType models.EventType) error {
+	o := origin{
+		Actor:   &models.Actor{Type: "saga", ID: s.ID},
+		Request: audit.RequestMeta{CorrelationID: correlation.ID(ctx)},
+	}
+	_, err := h.changeStatus(ctx, s.UserID, to, eventType, o)
+	var te *transitionError
+	if errors.As(err, &te) && te.from == to {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
+	"awesomeProject/pkg/saga"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestOnboarding_StartsForPendingUsersOnly(t *testing.T) {
+	def := NewUserHandler(nil, &mockPublisher{}).Onboarding(time.Minute)
+
+	if def.StartOn != models.EventUserCreated {
+		t.Errorf("expected to start on user.created, got %s", def.StartOn)
+	}
+	if !def.Start(models.UserEvent{Data: models.User{Status: models.UserPending}}) {
+		t.Error("expected a pending user to be onboarded")
+	}
+	if def.Start(models.UserEvent{Data: models.User{Status: models.UserActive}}) {
+		t.Error("expected an active user not to be onboarded")
+	}
+}
+
+func TestOnboarding_BuiltInTopologyBindsSagaEvents(t *testing.T) {
+	topology, err := rabbitmq.LoadTopology("")
+	if err != nil {
+		t.Fatalf("failed to load topology: %v", err)
+	}
+	engine := saga.NewEngine(nil, NewUserHandler(nil, &mockPublisher{}).Onboarding(time.Minute))
+	if _, err := topology.Consumer("api-sagas", engine.RoutingKeys()...); err != nil {
+		t.Errorf("expected api.sagas to be bound with the saga's events: %v", err)
+	}
+}
+
+func TestOnboarding_MarkActiveActsAsSaga(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserWithStatus(mock, "user-1", "pending")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET status = \\$1").
+		WithArgs(models.UserActive, sqlmock.AnyArg(), "user-1", models.UserPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEventAppend(mock, "user.reactivated")
+	mock.ExpectExec("DELETE FROM scheduled_messages WHERE key = \\$1").
+		WithArgs("reminder:user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditAppend(mock, "user.reactivated")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	h := NewUserHandler(db, pub)
+	markActive := h.Onboarding(time.Minute).Steps[3]
+	if err := markActive.Do(context.Background(), &saga.Saga{ID: "saga-1", UserID: "user-1"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(pub.published) != 1 {
+		t.Fatalf("expected 1 published event, got %d", len(pub.published))
+	}
+	var event models.UserEvent
+	if err := json.Unmarshal(pub.published[0].Body, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if event.Actor == nil || event.Actor.Type != "saga" || event.Actor.ID != "saga-1" {
+		t.Errorf("expected the saga as actor, got %+v", event.Actor)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestOnboarding_CompensationToleratesDeletedUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	expectUserWithStatus(mock, "user-1", "deleted")
+
+	pub := &mockPublisher{}
+	createUser := NewUserHandler(db, pub).Onboarding(time.Minute).Steps[0]
+	if err := createUser.Compensate(context.Background(), &saga.Saga{ID: "saga-1", UserID: "user-1"}); err != nil {
+		t.Fatalf("expected no error for an already deleted user, got %v", err)
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("expected nothing published, got %d events", len(pub.published))
+	}
+}
+
+func TestListUserSagas(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	steps := []byte(`[{"name":"create_user","status":"completed"},{"name":"create_crm_contact","status":"waiting"}]`)
+	mock.ExpectQuery("SELECT id, name, .* FROM sagas WHERE user_id = \\$1 ORDER BY created_at").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "user_id", "trigger_event_id", "correlation_id", "status", "current_step",
+			"steps", "step_deadline", "error", "created_at", "updated_at"}).
+			AddRow("saga-1", "onboarding", "user-1", "evt-1", "corr-1", "running", 1, steps, now.Add(time.Minute), "", now, now))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/user-1/sagas", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var sagas []saga.Saga
+	if err := json.Unmarshal(w.Body.Bytes(), &sagas); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(sagas) != 1 || sagas[0].Status != saga.StatusRunning || sagas[0].Steps[1].Status != saga.StepWaiting || sagas[0].StepDeadline == nil {
+		t.Errorf("unexpected sagas: %+v", sagas)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultPendingReminderAfter is how long a user may stay pending before
+// being reminded.
+const DefaultPendingReminderAfter = 7 * 24 * time.Hour
+
+// ReminderRoutingKeys are the events HandleReminder consumes.
+var ReminderRoutingKeys = []string{string(models.EventUserReminder)}
+
+// reminderKey groups a user's pending reminders for cancellation.
+func reminderKey(userID string) string {
+	return "reminder:" + userID
+}
+
+// scheduleReminders schedules a user.reminder, PendingReminderAfter out,
+// for each created event whose user is pending. The reminder is caused by
+// the user.created event and shares its correlation ID.
+func (h *UserHandler) scheduleReminders(ctx context.Context, q rabbitmq.Querier, created []models.UserEvent) error {
+	if h.PendingReminderAfter <= 0 {
+		return nil
+	}
+
+	var msgs []rabbitmq.ScheduledMessage
+	for _, e := range created {
+		if e.Data.Status != models.UserPending {
+			continue
+		}
+		reminder := models.NewUserEvent(ctx, models.EventUserReminder, e.Data)
+		reminder.CorrelationID = e.CorrelationID
+		reminder.CausationID = e.EventID
+		reminder.Timestamp = e.Timestamp.Add(h.PendingReminderAfter)
+		body, err := json.Marshal(reminder)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, rabbitmq.ScheduledMessage{
+			Key:           reminderKey(e.Data.ID),
+			RoutingKey:    string(reminder.EventType),
+			Body:          body,
+			CorrelationID: reminder.CorrelationID,
+			MessageID:     reminder.EventID,
+			PublishAt:     reminder.Timestamp,
+		})
+	}
+	_, err := h.Scheduler.Schedule(ctx, q, msgs...)
+	return err
+}
+
+// cancelReminders drops the reminders of a user leaving pending status.
+func (h *UserHandler) cancelReminders(ctx context.Context, q rabbitmq.Querier, userID string) error {
+	_, err := h.Scheduler.CancelKey(ctx, q, reminderKey(userID))
+	return err
+}
+
+// HandleReminder sends a due user.reminder. It stands in for a reminder
+// message, as there is no mail service yet. A user who left pending after
+// the reminder was published, too late to cancel it, is skipped.
+func (h *UserHandler) HandleReminder(ctx context.Context, delivery amqp.Delivery) error {
+	var event models.UserEvent
+	if err := json.Unmarshal(delivery.Body, &event); err != nil {
+		logging.FromContext(ctx).Error("failed to unmarshal reminder", logging.KeyError, err)
+		return err
+	}
+	logger := logging.FromContext(ctx).With(logging.KeyUserID, event.Data.ID, logging.KeyEventID, event.EventID)
+
+	var status models.UserStatus
+	err := h.DB.QueryRowContext(ctx, "SELECT status FROM users WHERE id = $1", event.Data.ID).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status != models.UserPending) {
+		logger.Info("user no longer pending, skipping reminder")
+		return nil
+	}
+	if err != nil {
+		logger.Error("error fetching user for reminder", logging.KeyError, err)
+		return err
+	}
+	logger.Info("pending reminder sent")
+	return nil
+}
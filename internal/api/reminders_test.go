@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestReminders_BuiltInTopologyBindsReminders(t *testing.T) {
+	topology, err := rabbitmq.LoadTopology("")
+	if err != nil {
+		t.Fatalf("failed to load topology: %v", err)
+	}
+	// Unbound, the broker would drop every published reminder
+	if _, err := topology.Consumer("api-reminders", ReminderRoutingKeys...); err != nil {
+		t.Errorf("expected api.reminders to be bound with user.reminder: %v", err)
+	}
+}
+
+func reminderDelivery(t *testing.T, userID string) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(models.UserEvent{EventID: "evt-1", EventType: models.EventUserReminder, Data: models.User{ID: userID}})
+	if err != nil {
+		t.Fatalf("failed to marshal reminder: %v", err)
+	}
+	return amqp.Delivery{Body: body}
+}
+
+func TestHandleReminder(t *testing.T) {
+	for _, status := range []string{"pending", "active"} {
+		t.Run(status, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT status FROM users WHERE id = \\$1").
+				WithArgs("user-1").
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(status))
+
+			h := NewUserHandler(db, &mockPublisher{})
+			if err := h.HandleReminder(context.Background(), reminderDelivery(t, "user-1")); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet sqlmock expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleReminder_InvalidJSON(t *testing.T) {
+	h := NewUserHandler(nil, &mockPublisher{})
+	if err := h.HandleReminder(context.Background(), amqp.Delivery{Body: []byte("not json")}); err == nil {
+		t.Fatal("expected an error for an unparseable reminder")
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"awesomeProject/pkg/metrics"
 	"awesomeProject/pkg/middleware"
 
 	"github.com/gin-gonic/gin"
@@ -8,26 +9,78 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+// Scopes checked on the user routes
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeAuditRead  = "audit:read"
+)
+
 // NewRouter creates and configures the Gin router.
 func NewRouter(h *UserHandler) *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
 
 	// Middleware
-	r.Use(middleware.CorrelationID())
+	r.Use(gin.Recovery(), middleware.Tracing(), middleware.CorrelationID(), middleware.RequestLogger(), middleware.Metrics())
 
-	// Health check
+	// Health checks: /health is a static ping, /livez and /readyz report
+	// on the process and its dependencies
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
+	r.GET("/livez", gin.WrapH(h.Health.LiveHandler()))
+	r.GET("/readyz", gin.WrapH(h.Health.ReadyHandler()))
+
+	// Prometheus metrics
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// Swagger
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	// User routes
-	r.POST("/users", h.CreateUser)
-	r.PUT("/users/:id", h.UpdateUser)
-	r.GET("/users/:id", h.GetUser)
-	r.GET("/users", h.ListUsers)
+	// User routes, grouped by access. With authenticators configured,
+	// reads need the users:read scope, writes users:write and the audit log
+	// audit:read; with a rate limit store each group is limited per client.
+	users := r.Group("/")
+	if len(h.Authenticators) > 0 {
+		users.Use(middleware.Authenticate(h.Authenticators...))
+	}
+	reads := h.routeGroup(users, ScopeUsersRead, h.ReadLimit, "read")
+	writes := h.routeGroup(users, ScopeUsersWrite, h.WriteLimit, "write")
+	audits := h.routeGroup(users, ScopeAuditRead, h.ReadLimit, "audit")
+
+	writes.POST("/users", middleware.Idempotency(h.DB, h.IdempotencyTTL), h.CreateUser)
+	writes.PUT("/users/:id", h.UpdateUser)
+	reads.GET("/users/:id", h.GetUser)
+	reads.GET("/users", h.ListUsers)
+	reads.GET("/users/export", h.ExportUsers)
+	reads.GET("/users/:id/events", h.ListUserEvents)
+	reads.GET("/users/:id/sync-status", h.GetSyncStatus)
+	reads.GET("/users/:id/sagas", h.ListUserSagas)
+	writes.POST("/users/:id/suspend", h.SuspendUser)
+	writes.POST("/users/:id/reactivate", h.ReactivateUser)
+	writes.POST("/users/:id/erase", h.EraseUser)
+
+	writes.POST("/users:action", h.usersAction) // POST /users:bulk
+	reads.GET("/imports/:id", h.GetImportJob)
+
+	reads.GET("/scheduled-messages", h.ListScheduledMessages)
+	writes.DELETE("/scheduled-messages/:id", h.CancelScheduledMessage)
+
+	audits.GET("/audit", h.ListAudit)
 
 	return r
 }
+
+// routeGroup returns a group under parent that requires scope when
+// authentication is on and, with a rate limit store, allows each client
+// limit under the bucket name.
+func (h *UserHandler) routeGroup(parent *gin.RouterGroup, scope string, limit middleware.Limit, name string) *gin.RouterGroup {
+	g := parent.Group("")
+	if len(h.Authenticators) > 0 {
+		g.Use(middleware.RequireScope(scope))
+	}
+	if h.RateLimitStore != nil {
+		g.Use(middleware.RateLimit(h.RateLimitStore, limit, name))
+	}
+	return g
+}
@@ -1,8 +1,17 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"awesomeProject/pkg/health"
+	"awesomeProject/pkg/middleware"
+	"awesomeProject/pkg/models"
+
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 )
@@ -22,11 +31,24 @@ func TestNewRouter_RoutesExist(t *testing.T) {
 
 	routes := router.Routes()
 	expectedRoutes := map[string]string{
-		"GET /health":    "health",
-		"POST /users":    "create",
-		"PUT /users/:id": "update",
-		"GET /users/:id": "get",
-		"GET /users":     "list",
+		"GET /health":                    "health",
+		"GET /livez":                     "liveness",
+		"GET /readyz":                    "readiness",
+		"POST /users":                    "create",
+		"PUT /users/:id":                 "update",
+		"GET /users/:id":                 "get",
+		"GET /users":                     "list",
+		"GET /users/:id/events":          "events",
+		"GET /users/:id/sync-status":     "sync status",
+		"GET /users/:id/sagas":           "sagas",
+		"GET /users/export":              "export",
+		"POST /users:action":             "bulk",
+		"POST /users/:id/suspend":        "suspend",
+		"POST /users/:id/reactivate":     "reactivate",
+		"POST /users/:id/erase":          "erase",
+		"GET /audit":                     "audit",
+		"GET /scheduled-messages":        "scheduled messages",
+		"DELETE /scheduled-messages/:id": "cancel scheduled message",
 	}
 
 	found := make(map[string]bool)
@@ -70,3 +92,175 @@ func TestSwaggerRouteRegistered(t *testing.T) {
 		t.Error("expected /swagger/*any route to be registered")
 	}
 }
+
+// stubAuthenticator authenticates any request carrying a Bearer header as
+// principal.
+type stubAuthenticator struct {
+	principal middleware.Principal
+}
+
+func (a stubAuthenticator) Authenticate(r *http.Request) (*middleware.Principal, error) {
+	if r.Header.Get("Authorization") == "" {
+		return nil, middleware.ErrNoCredentials
+	}
+	p := a.principal
+	return &p, nil
+}
+
+func TestNewRouter_AuthScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	handler.Authenticators = []middleware.Authenticator{stubAuthenticator{
+		principal: middleware.Principal{Type: middleware.PrincipalJWT, ID: "reader", Scopes: []string{ScopeUsersRead}},
+	}}
+	router := NewRouter(handler)
+
+	tests := []struct {
+		method, path string
+		auth         bool
+		want         int
+	}{
+		{http.MethodGet, "/health", false, http.StatusOK},
+		{http.MethodGet, "/users", false, http.StatusUnauthorized},
+		{http.MethodPost, "/users", true, http.StatusForbidden},
+		{http.MethodPost, "/users:bulk", true, http.StatusForbidden},
+		{http.MethodPost, "/users/user-1/erase", true, http.StatusForbidden},
+		{http.MethodGet, "/audit", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		if tt.auth {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, w.Code)
+		}
+	}
+}
+
+func TestCreateUser_RecordsActor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs(insertUserArgs("actor@example.com", "Actor")...).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEventAppend(mock, "user.created")
+	expectAuditAppend(mock, "user.created")
+	mock.ExpectCommit()
+
+	pub := &mockPublisher{}
+	handler := NewUserHandler(db, pub)
+	handler.Authenticators = []middleware.Authenticator{stubAuthenticator{
+		principal: middleware.Principal{Type: middleware.PrincipalAPIKey, ID: "key-1", Scopes: []string{ScopeUsersWrite}},
+	}}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"email":"actor@example.com","name":"Actor"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer token")
+	NewRouter(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(pub.published) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(pub.published))
+	}
+	var event models.UserEvent
+	if err := json.Unmarshal(pub.published[0].Body, &event); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	if event.Actor == nil || event.Actor.Type != "api_key" || event.Actor.ID != "key-1" {
+		t.Errorf("expected api_key actor key-1, got %+v", event.Actor)
+	}
+}
+
+func TestNewRouter_RateLimitsPerGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, &mockPublisher{})
+	handler.RateLimitStore = middleware.NewMemoryRateLimitStore()
+	handler.WriteLimit = middleware.Limit{Rate: 0.01, Burst: 1}
+	router := NewRouter(handler)
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(`{}`))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Invalid bodies fail validation without touching the database
+	if w := send(http.MethodPost, "/users"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected first write to reach the handler, got %d", w.Code)
+	}
+	w := send(http.MethodPost, "/users")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected second write to be limited, got %d %v", w.Code, w.Header())
+	}
+	if w := send(http.MethodGet, "/audit?limit=0"); w.Code != http.StatusBadRequest || w.Header().Get("RateLimit-Limit") != "600" {
+		t.Fatalf("expected reads to use their own bucket, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestReadyz_ReportsDatabaseDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	router := NewRouter(NewUserHandler(db, &mockPublisher{}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", w.Code, w.Body.String())
+	}
+	var report health.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	pg := report.Components["postgres"]
+	if report.Status != health.StatusDown || pg.Status != health.StatusDown || pg.Error != "connection refused" {
+		t.Errorf("expected postgres reported down, got %+v", report)
+	}
+
+	// Liveness doesn't depend on the database
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/livez", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected /livez 200, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
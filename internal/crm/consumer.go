@@ -1,21 +1,33 @@
 package crm
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 
+	"awesomeProject/pkg/logging"
 	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// EventPublisher publishes the consumer's result events.
+type EventPublisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error
+}
+
 // Consumer handles CRM sync events.
 type Consumer struct {
 	DB               *sql.DB
 	SimulateFailures bool
+
+	// Publisher receives crm.contact.synced and crm.contact.sync_failed
+	// events; nil disables them
+	Publisher EventPublisher
 }
 
 // NewConsumer creates a new CRM consumer.
@@ -23,52 +35,170 @@ func NewConsumer(db *sql.DB) *Consumer {
 	return &Consumer{DB: db, SimulateFailures: true}
 }
 
+// Reset deletes the CRM projection (crm_sync_log) and all idempotency keys
+// so that a replay rebuilds it from scratch.
+func (c *Consumer) Reset(ctx context.Context) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM crm_sync_log"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Info("CRM projection and idempotency keys reset")
+	return nil
+}
+
+// scrubUser removes the user's personal data from every sync log entry.
+func (c *Consumer) scrubUser(ctx context.Context, logger *slog.Logger, userID string) error {
+	res, err := c.DB.ExecContext(ctx,
+		"UPDATE crm_sync_log SET user_email = NULL, user_name = NULL, contact = NULL WHERE user_id = $1",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	logger.Info("scrubbed sync log entries for erased user", "entries", n)
+	return nil
+}
+
+// isErased reports whether a user.erased event has been synced for the user.
+func (c *Consumer) isErased(ctx context.Context, userID string) (bool, error) {
+	var erased bool
+	err := c.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM crm_sync_log WHERE user_id = $1 AND event_type = $2)",
+		userID, string(models.EventUserErased),
+	).Scan(&erased)
+	return erased, err
+}
+
 // HandleMessage processes a user event for CRM sync.
-func (c *Consumer) HandleMessage(delivery amqp.Delivery) error {
+func (c *Consumer) HandleMessage(ctx context.Context, delivery amqp.Delivery) error {
 	var event models.UserEvent
 	if err := json.Unmarshal(delivery.Body, &event); err != nil {
-		log.Printf("[CRM] Failed to unmarshal event: %v correlation_id=%s", err, delivery.CorrelationId)
+		logging.FromContext(ctx).Error("failed to unmarshal event", logging.KeyError, err)
 		return err
 	}
 
-	log.Printf("[CRM] Processing event: type=%s event_id=%s correlation_id=%s user_id=%s",
-		event.EventType, event.EventID, event.CorrelationID, event.Data.ID)
+	_, logger := logging.WithEvent(ctx, event)
+	logger.Info("processing event")
+
+	synced, err := c.sync(ctx, logger, event)
+	if err != nil {
+		if rabbitmq.WillRetry(ctx) {
+			return err // reported once the last attempt fails
+		}
+		result := models.NewResultEvent(ctx, models.EventCRMContactSyncFailed, event)
+		result.Error = err.Error()
+		c.publish(ctx, logger, result)
+		return err
+	}
+	if synced {
+		c.publish(ctx, logger, models.NewResultEvent(ctx, models.EventCRMContactSynced, event))
+	}
+	return nil
+}
 
+// sync applies event to the CRM. It reports false for events that needed
+// no sync: duplicates and events for erased users.
+func (c *Consumer) sync(ctx context.Context, logger *slog.Logger, event models.UserEvent) (bool, error) {
 	// Idempotency check
 	var exists bool
-	err := c.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM idempotency_keys WHERE event_id = $1)", event.EventID).Scan(&exists)
+	err := c.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM idempotency_keys WHERE event_id = $1)", event.EventID).Scan(&exists)
 	if err != nil {
-		log.Printf("[CRM] Error checking idempotency: %v correlation_id=%s", err, event.CorrelationID)
-		return err
+		logger.Error("error checking idempotency", logging.KeyError, err)
+		return false, err
 	}
 	if exists {
-		log.Printf("[CRM] Duplicate event ignored: event_id=%s correlation_id=%s", event.EventID, event.CorrelationID)
-		return nil // Already processed — ack it
+		logger.Info("duplicate event ignored")
+		rabbitmq.MarkDuplicate(ctx)
+		return false, nil // Already processed — ack it
 	}
 
 	// Simulate random failure (10% chance) to demonstrate retry + DLQ
 	if c.SimulateFailures && rand.Intn(10) == 0 {
-		log.Printf("[CRM] Simulated failure! event_id=%s correlation_id=%s", event.EventID, event.CorrelationID)
-		return fmt.Errorf("simulated CRM sync failure")
+		logger.Warn("simulated failure")
+		return false, fmt.Errorf("simulated CRM sync failure")
+	}
+
+	if event.EventType == models.EventUserErased {
+		if err := c.scrubUser(ctx, logger, event.Data.ID); err != nil {
+			logger.Error("error scrubbing erased user", logging.KeyError, err)
+			return false, err
+		}
+	} else {
+		// A late or redelivered event must not bring erased data back
+		erased, err := c.isErased(ctx, event.Data.ID)
+		if err != nil {
+			logger.Error("error checking erasure", logging.KeyError, err)
+			return false, err
+		}
+		if erased {
+			logger.Info("dropping event for erased user")
+			_, _ = c.DB.ExecContext(ctx, "INSERT INTO idempotency_keys (event_id) VALUES ($1) ON CONFLICT DO NOTHING", event.EventID)
+			return false, nil
+		}
 	}
 
-	// Simulate CRM sync — write to crm_sync_log
-	_, err = c.DB.Exec(
-		`INSERT INTO crm_sync_log (event_id, correlation_id, event_type, user_id, user_email, user_name)
-		 VALUES ($1, $2, $3, $4, $5, $6)`,
+	contact, err := json.Marshal(ContactFromUser(event.Data))
+	if err != nil {
+		return false, err
+	}
+
+	// Simulate CRM sync — write to crm_sync_log. A requeued event can arrive
+	// after later ones of its stream; it is skipped once a newer stream
+	// version was synced so the contact doesn't go back (events without a
+	// version, from before it was carried, always apply).
+	res, err := c.DB.ExecContext(ctx,
+		`INSERT INTO crm_sync_log (event_id, correlation_id, event_type, user_id, user_email, user_name, contact, stream_version)
+		 SELECT $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::jsonb, $8::integer
+		 WHERE $8::integer = 0 OR NOT EXISTS (
+		 	SELECT 1 FROM crm_sync_log WHERE user_id = $4::varchar AND stream_version >= $8::integer
+		 )`,
 		event.EventID, event.CorrelationID, string(event.EventType),
-		event.Data.ID, event.Data.Email, event.Data.Name,
+		event.Data.ID, event.Data.Email, event.Data.Name, contact, event.StreamVersion,
 	)
 	if err != nil {
-		log.Printf("[CRM] Error writing sync log: %v correlation_id=%s", err, event.CorrelationID)
-		return err
+		logger.Error("error writing sync log", logging.KeyError, err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
 	}
 
 	// Record idempotency key
-	_, _ = c.DB.Exec("INSERT INTO idempotency_keys (event_id) VALUES ($1) ON CONFLICT DO NOTHING", event.EventID)
+	_, _ = c.DB.ExecContext(ctx, "INSERT INTO idempotency_keys (event_id) VALUES ($1) ON CONFLICT DO NOTHING", event.EventID)
 
-	log.Printf("[CRM] Successfully synced: event_id=%s type=%s user=%s correlation_id=%s",
-		event.EventID, event.EventType, event.Data.Email, event.CorrelationID)
+	if n == 0 {
+		// The CRM already holds a later state of the user, which covers this
+		// event, so it still counts as synced
+		logger.Info("skipped stale event, CRM holds a newer stream version", "stream_version", event.StreamVersion)
+		return true, nil
+	}
+	logger.Info("synced to CRM")
 
-	return nil
+	return true, nil
+}
+
+// publish sends a result event, logging (but not returning) failures: the
+// sync itself stands either way.
+func (c *Consumer) publish(ctx context.Context, logger *slog.Logger, event models.ResultEvent) {
+	if c.Publisher == nil {
+		return
+	}
+	body, _ := json.Marshal(event)
+	if err := c.Publisher.Publish(ctx, string(event.EventType), body, event.CorrelationID, event.EventID); err != nil {
+		logger.Error("error publishing result event", logging.KeyError, err, "result_event_type", event.EventType)
+	}
 }
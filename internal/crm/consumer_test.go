@@ -1,11 +1,14 @@
 package crm
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
 	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -47,9 +50,14 @@ func TestHandleMessage_Success(t *testing.T) {
 		WithArgs("evt-001").
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 
+	// Erasure check — user not erased
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM crm_sync_log").
+		WithArgs("user-001", "user.erased").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
 	// CRM sync log insert
 	mock.ExpectExec("INSERT INTO crm_sync_log").
-		WithArgs("evt-001", "corr-001", "user.created", "user-001", "test@example.com", "Test User").
+		WithArgs("evt-001", "corr-001", "user.created", "user-001", "test@example.com", "Test User", sqlmock.AnyArg(), 0).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Idempotency key insert
@@ -58,7 +66,7 @@ func TestHandleMessage_Success(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	delivery := makeDelivery(event)
-	if err := consumer.HandleMessage(delivery); err != nil {
+	if err := consumer.HandleMessage(context.Background(), delivery); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -95,7 +103,7 @@ func TestHandleMessage_DuplicateEvent(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
 
 	delivery := makeDelivery(event)
-	if err := consumer.HandleMessage(delivery); err != nil {
+	if err := consumer.HandleMessage(context.Background(), delivery); err != nil {
 		t.Fatalf("expected no error for duplicate, got %v", err)
 	}
 
@@ -119,7 +127,286 @@ func TestHandleMessage_InvalidJSON(t *testing.T) {
 		CorrelationId: "corr-bad",
 	}
 
-	if err := consumer.HandleMessage(delivery); err == nil {
+	if err := consumer.HandleMessage(context.Background(), delivery); err == nil {
 		t.Fatal("expected error for invalid JSON, got nil")
 	}
 }
+
+func TestReset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM crm_sync_log").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	if err := NewConsumer(db).Reset(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_ErasedScrubsSyncLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+
+	event := models.UserEvent{
+		EventID:   "evt-erase",
+		EventType: models.EventUserErased,
+		Data:      models.User{ID: "user-001", Email: "erased+user-001@erased.invalid", Name: "erased"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-erase").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("UPDATE crm_sync_log SET user_email = NULL, user_name = NULL, contact = NULL WHERE user_id = \\$1").
+		WithArgs("user-001").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO crm_sync_log").
+		WithArgs("evt-erase", "", "user.erased", "user-001", "erased+user-001@erased.invalid", "erased", sqlmock.AnyArg(), 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("evt-erase").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := consumer.HandleMessage(context.Background(), makeDelivery(event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_DropsEventForErasedUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+
+	event := models.UserEvent{
+		EventID:   "evt-late",
+		EventType: models.EventUserUpdated,
+		Data:      models.User{ID: "user-001", Email: "real@example.com", Name: "Real Name"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-late").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM crm_sync_log").
+		WithArgs("user-001", "user.erased").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("evt-late").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := consumer.HandleMessage(context.Background(), makeDelivery(event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+type publishedMsg struct {
+	routingKey    string
+	correlationID string
+	messageID     string
+	event         models.ResultEvent
+}
+
+type mockPublisher struct {
+	messages []publishedMsg
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error {
+	msg := publishedMsg{routingKey: routingKey, correlationID: correlationID, messageID: messageID}
+	if err := json.Unmarshal(body, &msg.event); err != nil {
+		return err
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestHandleMessage_PublishesSynced(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	pub := &mockPublisher{}
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+	consumer.Publisher = pub
+
+	event := models.UserEvent{
+		EventID:       "evt-001",
+		CorrelationID: "corr-001",
+		EventType:     models.EventUserCreated,
+		Data:          models.User{ID: "user-001", Email: "test@example.com", Name: "Test User"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-001").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM crm_sync_log").
+		WithArgs("user-001", "user.erased").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO crm_sync_log").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := rabbitmq.WithDelivery(context.Background(), amqp.Delivery{CorrelationId: "corr-001", MessageId: "evt-001"})
+	if err := consumer.HandleMessage(ctx, makeDelivery(event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(pub.messages) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(pub.messages))
+	}
+	msg := pub.messages[0]
+	if msg.routingKey != "crm.contact.synced" {
+		t.Errorf("expected routing key crm.contact.synced, got %q", msg.routingKey)
+	}
+	if msg.event.CausationID != "evt-001" || msg.event.CorrelationID != "corr-001" || msg.correlationID != "corr-001" {
+		t.Errorf("expected causation evt-001 and correlation corr-001, got %+v", msg)
+	}
+	if msg.event.UserID != "user-001" || msg.event.SourceEventType != models.EventUserCreated {
+		t.Errorf("unexpected result event: %+v", msg.event)
+	}
+	if msg.messageID != msg.event.EventID || msg.event.EventID == "" {
+		t.Errorf("expected message ID to be the result event ID, got %q", msg.messageID)
+	}
+}
+
+func TestHandleMessage_SkipsStaleStreamVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	pub := &mockPublisher{}
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+	consumer.Publisher = pub
+
+	// user.created requeued behind the user's user.updated (version 2)
+	event := models.UserEvent{
+		EventID:       "evt-001",
+		CorrelationID: "corr-001",
+		EventType:     models.EventUserCreated,
+		StreamVersion: 1,
+		Data:          models.User{ID: "user-001", Email: "old@example.com", Name: "Old Name"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-001").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM crm_sync_log").
+		WithArgs("user-001", "user.erased").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO crm_sync_log .* WHERE \\$8::integer = 0 OR NOT EXISTS").
+		WithArgs("evt-001", "corr-001", "user.created", "user-001", "old@example.com", "Old Name", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("evt-001").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := consumer.HandleMessage(context.Background(), makeDelivery(event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(pub.messages) != 1 || pub.messages[0].routingKey != "crm.contact.synced" {
+		t.Errorf("expected the stale event to count as synced, got %+v", pub.messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_PublishesSyncFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	pub := &mockPublisher{}
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+	consumer.Publisher = pub
+
+	event := models.UserEvent{
+		EventID:       "evt-002",
+		CorrelationID: "corr-002",
+		EventType:     models.EventUserUpdated,
+		Data:          models.User{ID: "user-002"},
+	}
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-002").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM crm_sync_log").
+		WithArgs("user-002", "user.erased").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO crm_sync_log").WillReturnError(errors.New("disk full"))
+
+	if err := consumer.HandleMessage(context.Background(), makeDelivery(event)); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	if len(pub.messages) != 1 {
+		t.Fatalf("expected 1 published message, got %d", len(pub.messages))
+	}
+	msg := pub.messages[0]
+	if msg.routingKey != "crm.contact.sync_failed" {
+		t.Errorf("expected routing key crm.contact.sync_failed, got %q", msg.routingKey)
+	}
+	if msg.event.CausationID != "evt-002" || msg.event.CorrelationID != "corr-002" {
+		t.Errorf("expected causation evt-002 and correlation corr-002, got %+v", msg.event)
+	}
+	if msg.event.Error != "disk full" {
+		t.Errorf("expected error %q, got %q", "disk full", msg.event.Error)
+	}
+}
+
+func TestHandleMessage_DuplicatePublishesNothing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	pub := &mockPublisher{}
+	consumer := NewConsumer(db)
+	consumer.SimulateFailures = false
+	consumer.Publisher = pub
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("evt-dup").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	event := models.UserEvent{EventID: "evt-dup", EventType: models.EventUserUpdated, Data: models.User{ID: "user-002"}}
+	if err := consumer.HandleMessage(context.Background(), makeDelivery(event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(pub.messages) != 0 {
+		t.Errorf("expected nothing published for a duplicate, got %+v", pub.messages)
+	}
+}
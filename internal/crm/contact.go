@@ -0,0 +1,58 @@
+package crm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"awesomeProject/pkg/models"
+)
+
+// Contact is a user as the CRM models it. Custom fields are plain strings,
+// which is all most CRMs accept.
+type Contact struct {
+	ExternalID   string            `json:"external_id"`
+	Email        string            `json:"email"`
+	FullName     string            `json:"full_name"`
+	Status       string            `json:"status,omitempty"`
+	Phone        string            `json:"phone,omitempty"`
+	Locale       string            `json:"locale,omitempty"`
+	Timezone     string            `json:"timezone,omitempty"`
+	EmailOptIn   bool              `json:"email_opt_in"`
+	SMSOptIn     bool              `json:"sms_opt_in"`
+	CustomFields map[string]string `json:"custom_fields,omitempty"`
+}
+
+// ContactFromUser maps a user profile onto a CRM contact.
+func ContactFromUser(u models.User) Contact {
+	contact := Contact{
+		ExternalID: u.ID,
+		Email:      u.Email,
+		FullName:   u.Name,
+		Status:     string(u.Status),
+		Phone:      u.Phone,
+		Locale:     u.Locale,
+		Timezone:   u.Timezone,
+		EmailOptIn: u.MarketingConsent.Email,
+		SMSOptIn:   u.MarketingConsent.SMS,
+	}
+	if len(u.Attributes) > 0 {
+		contact.CustomFields = make(map[string]string, len(u.Attributes))
+		for k, v := range u.Attributes {
+			contact.CustomFields[k] = customFieldValue(v)
+		}
+	}
+	return contact
+}
+
+// customFieldValue flattens an attribute value to a string.
+func customFieldValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case bool, float64:
+		return fmt.Sprint(val)
+	default:
+		b, _ := json.Marshal(val)
+		return string(b)
+	}
+}
@@ -0,0 +1,46 @@
+package crm
+
+import (
+	"testing"
+
+	"awesomeProject/pkg/models"
+)
+
+func TestContactFromUser(t *testing.T) {
+	u := models.User{
+		ID:               "user-1",
+		Email:            "jane@example.com",
+		Name:             "Jane",
+		Phone:            "+14155550123",
+		Locale:           "en-US",
+		Timezone:         "America/New_York",
+		MarketingConsent: models.MarketingConsent{Email: true},
+		Attributes: models.Attributes{
+			"plan":  "pro",
+			"seats": float64(12),
+			"beta":  true,
+			"tags":  []interface{}{"a", "b"},
+		},
+	}
+
+	c := ContactFromUser(u)
+	if c.ExternalID != "user-1" || c.FullName != "Jane" || c.Phone != "+14155550123" {
+		t.Errorf("unexpected contact: %+v", c)
+	}
+	if !c.EmailOptIn || c.SMSOptIn {
+		t.Errorf("unexpected consent mapping: email=%v sms=%v", c.EmailOptIn, c.SMSOptIn)
+	}
+
+	want := map[string]string{"plan": "pro", "seats": "12", "beta": "true", "tags": `["a","b"]`}
+	for k, v := range want {
+		if c.CustomFields[k] != v {
+			t.Errorf("custom field %s: expected %q, got %q", k, v, c.CustomFields[k])
+		}
+	}
+}
+
+func TestContactFromUser_NoAttributes(t *testing.T) {
+	if c := ContactFromUser(models.User{ID: "u"}); c.CustomFields != nil {
+		t.Errorf("expected no custom fields, got %v", c.CustomFields)
+	}
+}
@@ -0,0 +1,157 @@
+// Package gdpr assembles data subject access exports across the service
+// databases.
+package gdpr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"awesomeProject/pkg/audit"
+	"awesomeProject/pkg/eventstore"
+	"awesomeProject/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// Sources are the service databases a subject export reads from.
+type Sources struct {
+	API       *sql.DB
+	CRM       *sql.DB
+	Analytics *sql.DB
+}
+
+// SubjectExport is everything the system holds about one user.
+type SubjectExport struct {
+	UserID      string                   `json:"user_id"`
+	GeneratedAt time.Time                `json:"generated_at"`
+	Profile     *models.User             `json:"profile"`
+	Events      []eventstore.StoredEvent `json:"events"`
+	Audit       []audit.Entry            `json:"audit"`
+	CRM         CRMRecords               `json:"crm"`
+	Analytics   AnalyticsRecords         `json:"analytics"`
+}
+
+// CRMRecords is the user's data in crm_db.
+type CRMRecords struct {
+	SyncLog []CRMSyncEntry `json:"sync_log"`
+}
+
+// CRMSyncEntry is one crm_sync_log row.
+type CRMSyncEntry struct {
+	EventID       string          `json:"event_id"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	EventType     string          `json:"event_type"`
+	Email         string          `json:"email,omitempty"`
+	Name          string          `json:"name,omitempty"`
+	Contact       json.RawMessage `json:"contact,omitempty"`
+	SyncedAt      time.Time       `json:"synced_at"`
+}
+
+// AnalyticsRecords is the user's footprint in analytics_db. Analytics keeps
+// only aggregate daily counts, so the only per-user trace is which of the
+// user's events it has counted.
+type AnalyticsRecords struct {
+	ProcessedEventIDs []string `json:"processed_event_ids"`
+}
+
+// Export collects a user's data from all three databases. It returns
+// sql.ErrNoRows if the API database has no such user.
+func Export(ctx context.Context, src Sources, userID string) (*SubjectExport, error) {
+	out := &SubjectExport{UserID: userID, GeneratedAt: time.Now().UTC()}
+
+	profile, err := loadProfile(ctx, src.API, userID)
+	if err != nil {
+		return nil, err
+	}
+	out.Profile = profile
+
+	out.Events, err = eventstore.New(src.API).ListByStream(ctx, userID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Audit, err = audit.New(src.API).List(ctx, userID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out.CRM.SyncLog, err = loadCRMSyncLog(ctx, src.CRM, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	eventIDs := make([]string, len(out.Events))
+	for i, e := range out.Events {
+		eventIDs[i] = e.Event.EventID
+	}
+	out.Analytics.ProcessedEventIDs, err = loadProcessedEventIDs(ctx, src.Analytics, eventIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func loadProfile(ctx context.Context, db *sql.DB, userID string) (*models.User, error) {
+	var u models.User
+	err := db.QueryRowContext(ctx,
+		`SELECT id, email, name, status, phone, locale, timezone, marketing_email, marketing_sms, attributes, created_at, updated_at
+		FROM users WHERE id = $1`, userID,
+	).Scan(&u.ID, &u.Email, &u.Name, &u.Status, &u.Phone, &u.Locale, &u.Timezone,
+		&u.MarketingConsent.Email, &u.MarketingConsent.SMS, &u.Attributes, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func loadCRMSyncLog(ctx context.Context, db *sql.DB, userID string) ([]CRMSyncEntry, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT event_id, correlation_id, event_type, user_email, user_name, contact, synced_at
+		FROM crm_sync_log WHERE user_id = $1 ORDER BY synced_at, id`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []CRMSyncEntry{}
+	for rows.Next() {
+		var e CRMSyncEntry
+		var correlationID, email, name sql.NullString
+		var contact []byte
+		if err := rows.Scan(&e.EventID, &correlationID, &e.EventType, &email, &name, &contact, &e.SyncedAt); err != nil {
+			return nil, err
+		}
+		e.CorrelationID, e.Email, e.Name = correlationID.String, email.String, name.String
+		if len(contact) > 0 {
+			e.Contact = contact
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func loadProcessedEventIDs(ctx context.Context, db *sql.DB, eventIDs []string) ([]string, error) {
+	ids := []string{}
+	if len(eventIDs) == 0 {
+		return ids, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT event_id FROM idempotency_keys WHERE event_id = ANY($1) ORDER BY processed_at", pq.Array(eventIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,96 @@
+package gdpr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func TestExport_AggregatesAllDatabases(t *testing.T) {
+	apiDB, apiMock := newMock(t)
+	crmDB, crmMock := newMock(t)
+	analyticsDB, analyticsMock := newMock(t)
+
+	now := time.Now()
+	apiMock.ExpectQuery("SELECT id, email, name, status, .* FROM users WHERE id = \\$1").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "status", "phone", "locale", "timezone",
+			"marketing_email", "marketing_sms", "attributes", "created_at", "updated_at"}).
+			AddRow("user-1", "jane@example.com", "Jane", "active", "", "en-GB", "", true, false, []byte(`{"plan":"pro"}`), now, now))
+
+	payload, _ := json.Marshal(models.UserEvent{EventID: "evt-1", EventType: models.EventUserCreated})
+	apiMock.ExpectQuery("SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events").
+		WithArgs("user-1", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence", "stream_id", "stream_version", "recorded_at", "payload"}).
+			AddRow(1, "user-1", 1, now, payload))
+
+	apiMock.ExpectQuery("SELECT id, .* FROM audit_log WHERE id > \\$1 AND user_id = \\$2 ORDER BY id").
+		WithArgs(int64(0), "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "occurred_at", "actor_type", "actor_id", "action", "user_id", "before", "after",
+			"method", "path", "client_ip", "user_agent", "correlation_id"}).
+			AddRow(1, now, nil, nil, "user.created", "user-1", nil, []byte(`{"id":"user-1"}`), "POST", "/users", "10.0.0.1", "curl/8", "corr-1"))
+
+	crmMock.ExpectQuery("SELECT event_id, correlation_id, event_type, user_email, user_name, contact, synced_at FROM crm_sync_log").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "correlation_id", "event_type", "user_email", "user_name", "contact", "synced_at"}).
+			AddRow("evt-1", "corr-1", "user.created", "jane@example.com", "Jane", []byte(`{"external_id":"user-1"}`), now))
+
+	analyticsMock.ExpectQuery("SELECT event_id FROM idempotency_keys WHERE event_id = ANY\\(\\$1\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow("evt-1"))
+
+	export, err := Export(context.Background(), Sources{API: apiDB, CRM: crmDB, Analytics: analyticsDB}, "user-1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if export.Profile.Email != "jane@example.com" || export.Profile.Attributes["plan"] != "pro" {
+		t.Errorf("unexpected profile: %+v", export.Profile)
+	}
+	if len(export.Events) != 1 || export.Events[0].Event.EventID != "evt-1" {
+		t.Errorf("unexpected events: %+v", export.Events)
+	}
+	if len(export.Audit) != 1 || export.Audit[0].Action != "user.created" {
+		t.Errorf("unexpected audit entries: %+v", export.Audit)
+	}
+	if len(export.CRM.SyncLog) != 1 || export.CRM.SyncLog[0].Name != "Jane" {
+		t.Errorf("unexpected CRM records: %+v", export.CRM)
+	}
+	if len(export.Analytics.ProcessedEventIDs) != 1 {
+		t.Errorf("unexpected analytics records: %+v", export.Analytics)
+	}
+
+	for name, mock := range map[string]sqlmock.Sqlmock{"api": apiMock, "crm": crmMock, "analytics": analyticsMock} {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: unmet sqlmock expectations: %v", name, err)
+		}
+	}
+}
+
+func TestExport_UnknownUser(t *testing.T) {
+	apiDB, apiMock := newMock(t)
+	crmDB, _ := newMock(t)
+	analyticsDB, _ := newMock(t)
+
+	apiMock.ExpectQuery("SELECT .* FROM users").WillReturnError(sql.ErrNoRows)
+
+	_, err := Export(context.Background(), Sources{API: apiDB, CRM: crmDB, Analytics: analyticsDB}, "nobody")
+	if err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
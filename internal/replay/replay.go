@@ -0,0 +1,200 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"awesomeProject/pkg/eventstore"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/models"
+	"awesomeProject/pkg/rabbitmq"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RoutingKeyPrefix is prepended to replayed events' routing keys so they only
+// reach the targeted consumer (which binds "replay.<target>.#").
+const RoutingKeyPrefix = "replay."
+
+// DefaultPageSize is the number of events read per query from the event store.
+const DefaultPageSize = 500
+
+// Source yields events to replay, in order.
+type Source interface {
+	Each(fn func(event models.UserEvent) error) error
+}
+
+// Sink receives replayed events.
+type Sink interface {
+	Replay(event models.UserEvent) error
+}
+
+// Publisher defines the interface for publishing events.
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error
+}
+
+// Options control which events are replayed.
+type Options struct {
+	// EventTypes restricts the replay to these types; empty means all.
+	EventTypes []models.EventType
+	// DryRun reads and filters events without handing them to the sink.
+	DryRun bool
+}
+
+// Stats summarises a replay run.
+type Stats struct {
+	Read     int
+	Replayed int
+	Skipped  int
+}
+
+// Run feeds every event from src through the filter to sink. It stops at the
+// first sink error, returning the stats gathered so far.
+func Run(src Source, sink Sink, opts Options) (Stats, error) {
+	var stats Stats
+	allowed := make(map[models.EventType]bool, len(opts.EventTypes))
+	for _, t := range opts.EventTypes {
+		allowed[t] = true
+	}
+
+	err := src.Each(func(event models.UserEvent) error {
+		stats.Read++
+		if len(allowed) > 0 && !allowed[event.EventType] {
+			stats.Skipped++
+			return nil
+		}
+		if opts.DryRun {
+			slog.Info("dry run", logging.KeyEventID, event.EventID, logging.KeyEventType, string(event.EventType), logging.KeyUserID, event.Data.ID)
+			stats.Replayed++
+			return nil
+		}
+		if err := sink.Replay(event); err != nil {
+			return fmt.Errorf("replaying event %s: %w", event.EventID, err)
+		}
+		stats.Replayed++
+		return nil
+	})
+	return stats, err
+}
+
+// StoreSource reads events from the API event store in global sequence order.
+type StoreSource struct {
+	Store         *eventstore.Store
+	AfterSequence int64
+	PageSize      int
+}
+
+// Each implements Source.
+func (s *StoreSource) Each(fn func(event models.UserEvent) error) error {
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	after := s.AfterSequence
+	for {
+		page, err := s.Store.ReadAll(context.Background(), after, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range page {
+			if err := fn(e.Event); err != nil {
+				return err
+			}
+			after = e.Sequence
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+	}
+}
+
+// FileSource reads newline-delimited JSON from an export file. Each line is
+// either a bare event envelope or a stored event (as returned by
+// GET /users/:id/events) wrapping one.
+type FileSource struct {
+	Reader io.Reader
+}
+
+// Each implements Source.
+func (s *FileSource) Each(fn func(event models.UserEvent) error) error {
+	scanner := bufio.NewScanner(s.Reader)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(raw) == 0 {
+			continue
+		}
+
+		var wrapped struct {
+			Event *models.UserEvent `json:"event"`
+		}
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		event := wrapped.Event
+		if event == nil {
+			event = &models.UserEvent{}
+			if err := json.Unmarshal(raw, event); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if event.EventID == "" {
+			return fmt.Errorf("line %d: missing event_id", line)
+		}
+
+		if err := fn(*event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// PublishSink republishes events on the replay routing key for Target.
+type PublishSink struct {
+	Publisher Publisher
+	Target    string
+}
+
+// RoutingKey returns the replay routing key for an event type.
+func RoutingKey(target string, eventType models.EventType) string {
+	return RoutingKeyPrefix + target + "." + string(eventType)
+}
+
+// Replay implements Sink.
+func (s *PublishSink) Replay(event models.UserEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.Publisher.Publish(context.Background(), RoutingKey(s.Target, event.EventType), body, event.CorrelationID, event.EventID)
+}
+
+// HandlerSink calls a consumer's message handler in-process, bypassing RabbitMQ.
+type HandlerSink struct {
+	Handler rabbitmq.MessageHandler
+}
+
+// Replay implements Sink.
+func (s *HandlerSink) Replay(event models.UserEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	delivery := amqp.Delivery{
+		Body:          body,
+		ContentType:   "application/json",
+		CorrelationId: event.CorrelationID,
+		MessageId:     event.EventID,
+		RoutingKey:    string(event.EventType),
+	}
+	return s.Handler(rabbitmq.WithDelivery(context.Background(), delivery), delivery)
+}
@@ -0,0 +1,197 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/eventstore"
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// sliceSource replays a fixed list of events.
+type sliceSource []models.UserEvent
+
+func (s sliceSource) Each(fn func(models.UserEvent) error) error {
+	for _, e := range s {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingSink records replayed events, failing on FailOn.
+type recordingSink struct {
+	events []models.UserEvent
+	FailOn string
+}
+
+func (r *recordingSink) Replay(e models.UserEvent) error {
+	if e.EventID == r.FailOn {
+		return errors.New("boom")
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+// mockPublisher records published messages.
+type mockPublisher struct {
+	keys []string
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error {
+	m.keys = append(m.keys, routingKey)
+	return nil
+}
+
+func TestRun_FiltersByType(t *testing.T) {
+	src := sliceSource{
+		{EventID: "1", EventType: models.EventUserCreated},
+		{EventID: "2", EventType: models.EventUserUpdated},
+		{EventID: "3", EventType: models.EventUserCreated},
+	}
+	sink := &recordingSink{}
+
+	stats, err := Run(src, sink, Options{EventTypes: []models.EventType{models.EventUserCreated}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stats.Read != 3 || stats.Replayed != 2 || stats.Skipped != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if len(sink.events) != 2 || sink.events[1].EventID != "3" {
+		t.Errorf("unexpected replayed events: %+v", sink.events)
+	}
+}
+
+func TestRun_StopsOnSinkError(t *testing.T) {
+	src := sliceSource{{EventID: "1"}, {EventID: "2"}, {EventID: "3"}}
+	sink := &recordingSink{FailOn: "2"}
+
+	stats, err := Run(src, sink, Options{})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if stats.Replayed != 1 {
+		t.Errorf("expected 1 replayed before failure, got %d", stats.Replayed)
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	sink := &recordingSink{}
+	stats, err := Run(sliceSource{{EventID: "1"}}, sink, Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stats.Replayed != 1 || len(sink.events) != 0 {
+		t.Errorf("dry run should count but not replay: stats=%+v sink=%d", stats, len(sink.events))
+	}
+}
+
+func TestFileSource_BareAndWrappedLines(t *testing.T) {
+	bare, _ := json.Marshal(models.UserEvent{EventID: "evt-1", EventType: models.EventUserCreated})
+	wrapped, _ := json.Marshal(eventstore.StoredEvent{
+		Sequence: 9,
+		Event:    models.UserEvent{EventID: "evt-2", EventType: models.EventUserUpdated},
+	})
+	input := string(bare) + "\n\n" + string(wrapped) + "\n"
+
+	var got []string
+	err := (&FileSource{Reader: strings.NewReader(input)}).Each(func(e models.UserEvent) error {
+		got = append(got, e.EventID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got) != 2 || got[0] != "evt-1" || got[1] != "evt-2" {
+		t.Errorf("unexpected events: %v", got)
+	}
+}
+
+func TestFileSource_InvalidLine(t *testing.T) {
+	err := (&FileSource{Reader: strings.NewReader("{\"event_id\":\"ok\"}\nnot json\n")}).
+		Each(func(models.UserEvent) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected line 2 error, got %v", err)
+	}
+}
+
+func TestStoreSource_Pages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	cols := []string{"sequence", "stream_id", "stream_version", "recorded_at", "payload"}
+	payload := func(id string) []byte {
+		b, _ := json.Marshal(models.UserEvent{EventID: id})
+		return b
+	}
+	now := time.Now()
+	mock.ExpectQuery("SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events").
+		WithArgs(int64(0), 2).
+		WillReturnRows(sqlmock.NewRows(cols).
+			AddRow(1, "u", 1, now, payload("a")).
+			AddRow(2, "u", 2, now, payload("b")))
+	mock.ExpectQuery("SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events").
+		WithArgs(int64(2), 2).
+		WillReturnRows(sqlmock.NewRows(cols).
+			AddRow(3, "u", 3, now, payload("c")))
+
+	var got []string
+	src := &StoreSource{Store: eventstore.New(db), PageSize: 2}
+	if err := src.Each(func(e models.UserEvent) error {
+		got = append(got, e.EventID)
+		return nil
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("unexpected events: %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPublishSink_UsesReplayRoutingKey(t *testing.T) {
+	pub := &mockPublisher{}
+	sink := &PublishSink{Publisher: pub, Target: "crm"}
+
+	if err := sink.Replay(models.UserEvent{EventID: "1", EventType: models.EventUserUpdated}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(pub.keys) != 1 || pub.keys[0] != "replay.crm.user.updated" {
+		t.Errorf("unexpected routing keys: %v", pub.keys)
+	}
+}
+
+func TestHandlerSink_BuildsDelivery(t *testing.T) {
+	var got amqp.Delivery
+	sink := &HandlerSink{Handler: func(_ context.Context, d amqp.Delivery) error {
+		got = d
+		return nil
+	}}
+
+	event := models.UserEvent{EventID: "evt-9", CorrelationID: "corr-9", EventType: models.EventUserCreated}
+	if err := sink.Replay(event); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got.CorrelationId != "corr-9" || got.RoutingKey != "user.created" {
+		t.Errorf("unexpected delivery: %+v", got)
+	}
+
+	var decoded models.UserEvent
+	if err := json.Unmarshal(got.Body, &decoded); err != nil || decoded.EventID != "evt-9" {
+		t.Errorf("unexpected body: %s (%v)", got.Body, err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package audit records who changed which user, how, and what it looked like
+// before and after.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"awesomeProject/pkg/models"
+)
+
+// RequestMeta describes the HTTP request behind an audited change.
+type RequestMeta struct {
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	ClientIP      string `json:"client_ip"`
+	UserAgent     string `json:"user_agent"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// Entry is one row of the audit_log table.
+type Entry struct {
+	ID         int64         `json:"id"`
+	OccurredAt time.Time     `json:"occurred_at"`
+	Actor      *models.Actor `json:"actor,omitempty"`
+	Action     string        `json:"action"`
+	UserID     string        `json:"user_id"`
+	Before     *models.User  `json:"before"`
+	After      *models.User  `json:"after"`
+	Request    RequestMeta   `json:"request"`
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so entries can be
+// written in the same transaction as the change they describe.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Log is the append-only audit trail.
+type Log struct {
+	DB *sql.DB
+}
+
+// New creates a new Log.
+func New(db *sql.DB) *Log {
+	return &Log{DB: db}
+}
+
+const entryColumns = "occurred_at, actor_type, actor_id, action, user_id, before, after, method, path, client_ip, user_agent, correlation_id"
+
+// Append records a single entry.
+func (l *Log) Append(ctx context.Context, q Querier, e Entry) error {
+	return l.AppendBatch(ctx, q, []Entry{e})
+}
+
+// AppendBatch records several entries with a single multi-row insert.
+func (l *Log) AppendBatch(ctx context.Context, q Querier, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	const cols = 12
+	values := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, len(entries)*cols)
+	for i, e := range entries {
+		before, err := marshalUser(e.Before)
+		if err != nil {
+			return err
+		}
+		after, err := marshalUser(e.After)
+		if err != nil {
+			return err
+		}
+		var actorType, actorID sql.NullString
+		if e.Actor != nil {
+			actorType = sql.NullString{String: e.Actor.Type, Valid: true}
+			actorID = sql.NullString{String: e.Actor.ID, Valid: true}
+		}
+
+		placeholders := make([]string, cols)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*cols+j+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, e.OccurredAt, actorType, actorID, e.Action, e.UserID, before, after,
+			e.Request.Method, e.Request.Path, e.Request.ClientIP, e.Request.UserAgent, e.Request.CorrelationID)
+	}
+
+	_, err := q.ExecContext(ctx, "INSERT INTO audit_log ("+entryColumns+") VALUES "+strings.Join(values, ", "), args...)
+	return err
+}
+
+// ScrubUser drops the before/after snapshots, client IP and user agent of
+// every entry about userID, keeping who did what and when. Used by GDPR
+// erasure.
+func (l *Log) ScrubUser(ctx context.Context, q Querier, userID string) (int64, error) {
+	res, err := q.ExecContext(ctx,
+		"UPDATE audit_log SET before = NULL, after = NULL, client_ip = '', user_agent = '' WHERE user_id = $1",
+		userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// List returns entries in id order starting after afterID, optionally only
+// those about userID. A limit of 0 means no limit.
+func (l *Log) List(ctx context.Context, userID string, afterID int64, limit int) ([]Entry, error) {
+	query := "SELECT id, " + entryColumns + " FROM audit_log WHERE id > $1"
+	args := []interface{}{afterID}
+	if userID != "" {
+		args = append(args, userID)
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	query += " ORDER BY id"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := l.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var actorType, actorID sql.NullString
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &actorType, &actorID, &e.Action, &e.UserID, &before, &after,
+			&e.Request.Method, &e.Request.Path, &e.Request.ClientIP, &e.Request.UserAgent, &e.Request.CorrelationID); err != nil {
+			return nil, err
+		}
+		if actorType.Valid {
+			e.Actor = &models.Actor{Type: actorType.String, ID: actorID.String}
+		}
+		if e.Before, err = unmarshalUser(before); err != nil {
+			return nil, err
+		}
+		if e.After, err = unmarshalUser(after); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func marshalUser(u *models.User) ([]byte, error) {
+	if u == nil {
+		return nil, nil
+	}
+	return json.Marshal(u)
+}
+
+func unmarshalUser(data []byte) (*models.User, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var u models.User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
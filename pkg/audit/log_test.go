@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAppendBatch_MultiRowInsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	after := &models.User{ID: "user-1", Email: "a@example.com"}
+	entries := []Entry{
+		{OccurredAt: now, Actor: &models.Actor{Type: "api_key", ID: "key-1"}, Action: "user.created", UserID: "user-1", After: after},
+		{OccurredAt: now, Action: "user.created", UserID: "user-2"},
+	}
+
+	mock.ExpectExec("INSERT INTO audit_log \\(occurred_at, .*\\) VALUES \\(\\$1, .*\\$12\\), \\(\\$13, .*\\$24\\)").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := New(db).AppendBatch(context.Background(), db, entries); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestList_FiltersAndDecodes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT id, occurred_at, .* FROM audit_log WHERE id > \\$1 AND user_id = \\$2 ORDER BY id LIMIT \\$3").
+		WithArgs(int64(10), "user-1", 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "occurred_at", "actor_type", "actor_id", "action", "user_id", "before", "after",
+			"method", "path", "client_ip", "user_agent", "correlation_id"}).
+			AddRow(11, now, "jwt", "svc", "user.updated", "user-1", []byte(`{"id":"user-1","name":"Old"}`), []byte(`{"id":"user-1","name":"New"}`),
+				"PUT", "/users/user-1", "10.0.0.1", "curl/8", "corr-1").
+			AddRow(12, now, nil, nil, "user.erased", "user-1", nil, nil, "POST", "/users/user-1/erase", "10.0.0.1", "curl/8", "corr-2"))
+
+	entries, err := New(db).List(context.Background(), "user-1", 10, 50)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Actor == nil || entries[0].Actor.ID != "svc" || entries[0].Before.Name != "Old" || entries[0].After.Name != "New" {
+		t.Errorf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Actor != nil || entries[1].Before != nil || entries[1].After != nil {
+		t.Errorf("expected scrubbed entry without actor, got %+v", entries[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestScrubUser_ClearsRequestMeta(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Client IP and user agent are personal data too
+	mock.ExpectExec("UPDATE audit_log SET before = NULL, after = NULL, client_ip = '', user_agent = '' WHERE user_id = \\$1").
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := New(db).ScrubUser(context.Background(), db, "user-1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 scrubbed entries, got %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
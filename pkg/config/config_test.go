@@ -10,6 +10,7 @@ func TestLoadDefaults(t *testing.T) {
 	os.Unsetenv("DATABASE_URL")
 	os.Unsetenv("RABBITMQ_URL")
 	os.Unsetenv("API_PORT")
+	os.Unsetenv("ANALYTICS_PORT")
 
 	cfg := Load()
 
@@ -22,6 +23,9 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.APIPort != "8080" {
 		t.Errorf("unexpected APIPort: %s", cfg.APIPort)
 	}
+	if cfg.AnalyticsPort != "8082" {
+		t.Errorf("unexpected AnalyticsPort: %s", cfg.AnalyticsPort)
+	}
 }
 
 func TestLoadFromEnv(t *testing.T) {
@@ -66,3 +70,31 @@ func TestGetEnvFallback(t *testing.T) {
 		t.Errorf("expected fallback-value, got %s", val)
 	}
 }
+
+func TestGetEnvInt(t *testing.T) {
+	os.Setenv("TEST_INT_KEY", "25")
+	defer os.Unsetenv("TEST_INT_KEY")
+
+	if val := getEnvInt("TEST_INT_KEY", 1); val != 25 {
+		t.Errorf("expected 25, got %d", val)
+	}
+
+	os.Setenv("TEST_INT_KEY", "not-a-number")
+	if val := getEnvInt("TEST_INT_KEY", 1); val != 1 {
+		t.Errorf("expected fallback 1 for invalid value, got %d", val)
+	}
+}
+
+func TestGetEnvBool(t *testing.T) {
+	os.Setenv("TEST_BOOL_KEY", "true")
+	defer os.Unsetenv("TEST_BOOL_KEY")
+
+	if !getEnvBool("TEST_BOOL_KEY", false) {
+		t.Error("expected true")
+	}
+
+	os.Setenv("TEST_BOOL_KEY", "maybe")
+	if getEnvBool("TEST_BOOL_KEY", false) {
+		t.Error("expected fallback false for invalid value")
+	}
+}
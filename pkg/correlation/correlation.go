@@ -0,0 +1,65 @@
+// Package correlation carries the correlation and causation IDs of the work
+// in progress in a context. HTTP requests and AMQP deliveries both seed it,
+// so events emitted further down inherit the IDs without threading them
+// through every call.
+package correlation
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header carrying a correlation ID.
+const Header = "X-Correlation-ID"
+
+// MaxLength is the longest correlation ID accepted from a caller.
+const MaxLength = 128
+
+type idKey struct{}
+type causationKey struct{}
+
+// New returns a fresh correlation ID.
+func New() string {
+	return uuid.New().String()
+}
+
+// Valid reports whether id is an acceptable correlation ID from outside: 1
+// to MaxLength characters of letters, digits, '-', '_', '.' and ':'.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithID returns ctx carrying correlation ID id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ID returns ctx's correlation ID, or "" if it has none.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// WithCausation returns ctx carrying the ID of the event being handled, which
+// becomes the causation ID of any event emitted in response.
+func WithCausation(ctx context.Context, eventID string) context.Context {
+	return context.WithValue(ctx, causationKey{}, eventID)
+}
+
+// Causation returns ctx's causation ID, or "" outside an event handler.
+func Causation(ctx context.Context) string {
+	id, _ := ctx.Value(causationKey{}).(string)
+	return id
+}
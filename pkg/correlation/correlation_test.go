@@ -0,0 +1,44 @@
+package correlation
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"9b2f6c1e-3a4d-4e5f-8a9b-0c1d2e3f4a5b", true},
+		{"order-42.retry_1:eu", true},
+		{strings.Repeat("a", MaxLength), true},
+		{"", false},
+		{strings.Repeat("a", MaxLength+1), false},
+		{"has space", false},
+		{"line\nbreak", false},
+		{"quote\"", false},
+		{"ünïcode", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.valid {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.valid)
+		}
+	}
+	if !Valid(New()) {
+		t.Error("expected a generated ID to be valid")
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if ID(ctx) != "" || Causation(ctx) != "" {
+		t.Fatal("expected no IDs on an empty context")
+	}
+
+	ctx = WithCausation(WithID(ctx, "corr-1"), "evt-1")
+	if ID(ctx) != "corr-1" || Causation(ctx) != "evt-1" {
+		t.Errorf("expected corr-1 caused by evt-1, got %q caused by %q", ID(ctx), Causation(ctx))
+	}
+}
@@ -0,0 +1,227 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/lib/pq"
+)
+
+// ErrVersionConflict is returned by Append and AppendBatch when a concurrent
+// transaction took the next version of the same stream. The caller's
+// transaction is aborted; the request can be retried from the start.
+var ErrVersionConflict = errors.New("concurrent append to event stream")
+
+// versionConflict maps a unique violation on (stream_id, stream_version) to
+// ErrVersionConflict.
+func versionConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "events_stream_id_stream_version_key" {
+		return fmt.Errorf("%w: %s", ErrVersionConflict, pqErr.Message)
+	}
+	return err
+}
+
+// StoredEvent is a published event as persisted in the events table.
+type StoredEvent struct {
+	Sequence      int64            `json:"sequence"`
+	StreamID      string           `json:"stream_id"`
+	StreamVersion int              `json:"stream_version"`
+	RecordedAt    time.Time        `json:"recorded_at"`
+	Event         models.UserEvent `json:"event"`
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so events can be
+// appended in the same transaction as the state change they describe.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Store is an append-only log of user events.
+type Store struct {
+	DB *sql.DB
+}
+
+// New creates a new Store.
+func New(db *sql.DB) *Store {
+	return &Store{DB: db}
+}
+
+// nextVersion is the next stream version of the stream in parameter $n.
+func nextVersion(n int) string {
+	return fmt.Sprintf("(SELECT COALESCE(MAX(stream_version), 0) + 1 FROM events WHERE stream_id = $%d::varchar)", n)
+}
+
+// Append writes the event to the end of the user's stream and returns the
+// assigned global sequence number and per-stream version. The stored
+// payload, and the returned event, carry the version as StreamVersion. It
+// returns ErrVersionConflict if a concurrent append to the stream won.
+func (s *Store) Append(ctx context.Context, q Querier, event models.UserEvent) (StoredEvent, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return StoredEvent{}, err
+	}
+
+	stored := StoredEvent{StreamID: event.Data.ID, Event: event}
+	err = q.QueryRowContext(ctx,
+		`WITH next AS (SELECT `+nextVersion(2)+` AS version)
+		 INSERT INTO events (event_id, stream_id, stream_version, event_type, correlation_id, payload, occurred_at)
+		 SELECT $1, $2::varchar, version, $3, $4, jsonb_set($5::jsonb, '{stream_version}', to_jsonb(version)), $6 FROM next
+		 RETURNING sequence, stream_version, recorded_at`,
+		event.EventID, event.Data.ID, string(event.EventType), event.CorrelationID, payload, event.Timestamp,
+	).Scan(&stored.Sequence, &stored.StreamVersion, &stored.RecordedAt)
+	if err != nil {
+		return StoredEvent{}, versionConflict(err)
+	}
+	stored.Event.StreamVersion = stored.StreamVersion
+	return stored, nil
+}
+
+// AppendBatch writes several events with a single multi-row insert. Stream
+// versions are assigned as in Append, so each event must belong to a
+// different stream (e.g. one user.created per new user), and set on the
+// events in place.
+func (s *Store) AppendBatch(ctx context.Context, q Querier, events []models.UserEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*6)
+	for i, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		n := i * 6
+		values = append(values, fmt.Sprintf(
+			"($%d, $%d::varchar, %s, $%d, $%d, jsonb_set($%d::jsonb, '{stream_version}', to_jsonb(%s)), $%d)",
+			n+1, n+2, nextVersion(n+2), n+3, n+4, n+5, nextVersion(n+2), n+6))
+		args = append(args, event.EventID, event.Data.ID, string(event.EventType), event.CorrelationID, payload, event.Timestamp)
+	}
+
+	rows, err := q.QueryContext(ctx,
+		`INSERT INTO events (event_id, stream_id, stream_version, event_type, correlation_id, payload, occurred_at)
+		 VALUES `+strings.Join(values, ", ")+`
+		 RETURNING event_id, stream_version`,
+		args...,
+	)
+	if err != nil {
+		return versionConflict(err)
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int, len(events))
+	for rows.Next() {
+		var id string
+		var version int
+		if err := rows.Scan(&id, &version); err != nil {
+			return err
+		}
+		versions[id] = version
+	}
+	if err := rows.Err(); err != nil {
+		return versionConflict(err)
+	}
+	for i := range events {
+		events[i].StreamVersion = versions[events[i].EventID]
+	}
+	return nil
+}
+
+// ScrubStream removes personal data from every event of a stream: each
+// event's own user snapshot gets erased's email and name, and loses its
+// phone and attributes. Status, timestamps and the other fields keep the
+// values the event recorded, so the history still replays as it happened.
+// It is the one sanctioned rewrite of history: a GDPR erasure has to remove
+// personal data from stored events too. Returns the number of events changed.
+func (s *Store) ScrubStream(ctx context.Context, q Querier, streamID string, erased models.User) (int64, error) {
+	patch, err := json.Marshal(map[string]string{"email": erased.Email, "name": erased.Name})
+	if err != nil {
+		return 0, err
+	}
+	res, err := q.ExecContext(ctx,
+		`UPDATE events SET payload = jsonb_set(payload, '{data}', (payload->'data' - 'phone' - 'attributes') || $2::jsonb)
+		 WHERE stream_id = $1`,
+		streamID, patch,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListByStream returns a user's events in stream order, starting after the
+// given version. A limit of 0 means no limit.
+func (s *Store) ListByStream(ctx context.Context, streamID string, afterVersion, limit int) ([]StoredEvent, error) {
+	query := `SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events
+		WHERE stream_id = $1 AND stream_version > $2
+		ORDER BY stream_version`
+	args := []interface{}{streamID, afterVersion}
+	if limit > 0 {
+		query += " LIMIT $3"
+		args = append(args, limit)
+	}
+	return s.scan(ctx, s.DB, query, args...)
+}
+
+// LatestEventID returns the ID of the last event in a user's stream, or ""
+// if the stream is empty.
+func (s *Store) LatestEventID(ctx context.Context, streamID string) (string, error) {
+	var id string
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT event_id FROM events WHERE stream_id = $1 ORDER BY stream_version DESC LIMIT 1",
+		streamID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// ReadAll returns events across all streams in global sequence order,
+// starting after the given sequence. A limit of 0 means no limit.
+func (s *Store) ReadAll(ctx context.Context, afterSequence int64, limit int) ([]StoredEvent, error) {
+	query := `SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events
+		WHERE sequence > $1
+		ORDER BY sequence`
+	args := []interface{}{afterSequence}
+	if limit > 0 {
+		query += " LIMIT $2"
+		args = append(args, limit)
+	}
+	return s.scan(ctx, s.DB, query, args...)
+}
+
+func (s *Store) scan(ctx context.Context, q Querier, query string, args ...interface{}) ([]StoredEvent, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []StoredEvent{}
+	for rows.Next() {
+		var e StoredEvent
+		var payload []byte
+		if err := rows.Scan(&e.Sequence, &e.StreamID, &e.StreamVersion, &e.RecordedAt, &payload); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &e.Event); err != nil {
+			return nil, err
+		}
+		// Payloads stored before events carried their version lack it
+		e.Event.StreamVersion = e.StreamVersion
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
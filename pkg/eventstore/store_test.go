@@ -0,0 +1,189 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestAppend(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	event := models.UserEvent{
+		EventID:       "evt-001",
+		CorrelationID: "corr-001",
+		EventType:     models.EventUserCreated,
+		Timestamp:     now,
+		Data:          models.User{ID: "user-001", Email: "a@example.com", Name: "A"},
+	}
+
+	mock.ExpectQuery("INSERT INTO events .* jsonb_set\\(\\$5::jsonb, '\\{stream_version\\}', to_jsonb\\(version\\)\\)").
+		WithArgs("evt-001", "user-001", "user.created", "corr-001", sqlmock.AnyArg(), now).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence", "stream_version", "recorded_at"}).
+			AddRow(7, 3, now))
+
+	store := New(db)
+	stored, err := store.Append(context.Background(), db, event)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stored.Sequence != 7 || stored.StreamVersion != 3 || stored.StreamID != "user-001" {
+		t.Errorf("unexpected stored event: %+v", stored)
+	}
+	if stored.Event.StreamVersion != 3 {
+		t.Errorf("expected the event to carry stream version 3, got %d", stored.Event.StreamVersion)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAppend_VersionConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	event := models.UserEvent{EventID: "evt-002", EventType: models.EventUserUpdated, Data: models.User{ID: "user-001"}}
+	mock.ExpectQuery("INSERT INTO events").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "events_stream_id_stream_version_key"})
+
+	if _, err := New(db).Append(context.Background(), db, event); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+
+	// Other unique violations pass through unchanged
+	mock.ExpectQuery("INSERT INTO events").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "events_event_id_key"})
+	if _, err := New(db).Append(context.Background(), db, event); err == nil || errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected a plain unique violation, got %v", err)
+	}
+}
+
+func TestListByStream(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	p1, _ := json.Marshal(models.UserEvent{EventID: "evt-1", EventType: models.EventUserCreated})
+	p2, _ := json.Marshal(models.UserEvent{EventID: "evt-2", EventType: models.EventUserUpdated})
+
+	mock.ExpectQuery("SELECT sequence, stream_id, stream_version, recorded_at, payload FROM events").
+		WithArgs("user-001", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence", "stream_id", "stream_version", "recorded_at", "payload"}).
+			AddRow(1, "user-001", 1, now, p1).
+			AddRow(5, "user-001", 2, now, p2))
+
+	events, err := New(db).ListByStream(context.Background(), "user-001", 0, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[1].Event.EventType != models.EventUserUpdated || events[1].StreamVersion != 2 {
+		t.Errorf("unexpected second event: %+v", events[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAppendBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	events := []models.UserEvent{
+		{EventID: "evt-1", EventType: models.EventUserCreated, Timestamp: now, Data: models.User{ID: "u1"}},
+		{EventID: "evt-2", EventType: models.EventUserCreated, Timestamp: now, Data: models.User{ID: "u2"}},
+	}
+
+	mock.ExpectQuery("INSERT INTO events .* VALUES \\(\\$1, .*\\), \\(\\$7, .* RETURNING event_id, stream_version").
+		WithArgs("evt-1", "u1", "user.created", "", sqlmock.AnyArg(), now,
+			"evt-2", "u2", "user.created", "", sqlmock.AnyArg(), now).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "stream_version"}).AddRow("evt-2", 4).AddRow("evt-1", 1))
+
+	if err := New(db).AppendBatch(context.Background(), db, events); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if events[0].StreamVersion != 1 || events[1].StreamVersion != 4 {
+		t.Errorf("expected stream versions 1 and 4 set on the events, got %d and %d", events[0].StreamVersion, events[1].StreamVersion)
+	}
+	if err := New(db).AppendBatch(context.Background(), db, nil); err != nil {
+		t.Fatalf("expected no error for empty batch, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestScrubStream(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Only the personal fields are patched; status and timestamps stay
+	erased := models.User{ID: "user-001", Email: "erased+user-001@erased.invalid", Name: "erased", Status: models.UserDeleted}
+	mock.ExpectExec("UPDATE events SET payload = jsonb_set\\(payload, '\\{data\\}', \\(payload->'data' - 'phone' - 'attributes'\\) \\|\\| \\$2::jsonb\\)\\s+WHERE stream_id = \\$1").
+		WithArgs("user-001", []byte(`{"email":"erased+user-001@erased.invalid","name":"erased"}`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := New(db).ScrubStream(context.Background(), db, "user-001", erased)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 scrubbed events, got %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLatestEventID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT event_id FROM events WHERE stream_id = \\$1 ORDER BY stream_version DESC LIMIT 1").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow("evt-9"))
+	mock.ExpectQuery("SELECT event_id FROM events").
+		WithArgs("user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}))
+
+	store := New(db)
+	if id, err := store.LatestEventID(context.Background(), "user-1"); err != nil || id != "evt-9" {
+		t.Errorf("expected evt-9, got %q (%v)", id, err)
+	}
+	if id, err := store.LatestEventID(context.Background(), "user-2"); err != nil || id != "" {
+		t.Errorf("expected no event for an empty stream, got %q (%v)", id, err)
+	}
+}
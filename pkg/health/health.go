@@ -0,0 +1,164 @@
+// Package health serves liveness and readiness probes built from named
+// dependency checks.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Component statuses.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// DefaultTimeout bounds each check when the Checker sets none.
+const DefaultTimeout = 2 * time.Second
+
+// Check inspects one dependency. It returns details worth reporting (nil if
+// none) and a non-nil error when the dependency is unhealthy.
+type Check func(ctx context.Context) (map[string]interface{}, error)
+
+// Component is the outcome of one check.
+type Component struct {
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// Report is the body of a probe response. Status is up only if every
+// component is.
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the checks behind a service's probes. Liveness checks cover
+// failures only a restart fixes; readiness runs them plus the dependency
+// checks, so a service with a database outage is taken out of rotation but
+// not restarted.
+type Checker struct {
+	// Timeout bounds each check; zero means DefaultTimeout
+	Timeout time.Duration
+
+	mu    sync.RWMutex
+	live  []namedCheck
+	ready []namedCheck
+}
+
+// NewChecker returns a Checker with no checks, so both probes report up.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a readiness check.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = append(c.ready, namedCheck{name, check})
+}
+
+// AddLiveness registers a check that both probes run.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live = append(c.live, namedCheck{name, check})
+}
+
+// Live runs the liveness checks.
+func (c *Checker) Live(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.live...)
+	c.mu.RUnlock()
+	return c.run(ctx, checks)
+}
+
+// Ready runs the liveness and readiness checks.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append(append([]namedCheck(nil), c.live...), c.ready...)
+	c.mu.RUnlock()
+	return c.run(ctx, checks)
+}
+
+// run executes checks concurrently, each under the timeout.
+func (c *Checker) run(ctx context.Context, checks []namedCheck) Report {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	results := make([]Component, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check(ctx)
+			results[i] = Component{Status: StatusUp, Details: details, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Status, results[i].Error = StatusDown, err.Error()
+			}
+		}(i, nc.check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp}
+	if len(checks) > 0 {
+		report.Components = make(map[string]Component, len(checks))
+	}
+	for i, nc := range checks {
+		report.Components[nc.name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// LiveHandler serves the liveness report: 200 when up, 503 otherwise.
+func (c *Checker) LiveHandler() http.Handler {
+	return reportHandler(c.Live)
+}
+
+// ReadyHandler serves the readiness report: 200 when up, 503 otherwise.
+func (c *Checker) ReadyHandler() http.Handler {
+	return reportHandler(c.Ready)
+}
+
+func reportHandler(probe func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := probe(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// NewServer returns an HTTP server exposing /livez and /readyz, for
+// binaries that have no other HTTP listener.
+func NewServer(addr string, c *Checker) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/livez", c.LiveHandler())
+	mux.Handle("/readyz", c.ReadyHandler())
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func up(context.Context) (map[string]interface{}, error) { return nil, nil }
+
+func probe(t *testing.T, c *Checker, path string) (int, Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	NewServer(":0", c).Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var report Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+	}
+	return w.Code, report
+}
+
+func TestChecker_ReadyReportsEachComponent(t *testing.T) {
+	c := NewChecker()
+	c.AddLiveness("loop", up)
+	c.Add("db", up)
+	c.Add("queue", func(context.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"messages": 1500}, errors.New("lagging")
+	})
+
+	code, report := probe(t, c, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != StatusDown {
+		t.Fatalf("expected 503 and down, got %d and %q", code, report.Status)
+	}
+	if len(report.Components) != 3 {
+		t.Fatalf("expected three components, got %+v", report.Components)
+	}
+	if report.Components["db"].Status != StatusUp || report.Components["loop"].Status != StatusUp {
+		t.Errorf("expected db and loop up, got %+v", report.Components)
+	}
+	q := report.Components["queue"]
+	if q.Status != StatusDown || q.Error != "lagging" || q.Details["messages"] != float64(1500) {
+		t.Errorf("unexpected queue component %+v", q)
+	}
+
+	// Liveness ignores readiness checks
+	code, report = probe(t, c, "/livez")
+	if code != http.StatusOK || report.Status != StatusUp || len(report.Components) != 1 {
+		t.Errorf("expected 200 with only the liveness check, got %d %+v", code, report)
+	}
+}
+
+func TestChecker_TimesOutSlowChecks(t *testing.T) {
+	c := NewChecker()
+	c.Timeout = 10 * time.Millisecond
+	c.Add("slow", func(ctx context.Context) (map[string]interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	code, report := probe(t, c, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Components["slow"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the slow check to time out, got %d %+v", code, report)
+	}
+}
+
+func TestChecker_NoChecksIsUp(t *testing.T) {
+	code, report := probe(t, NewChecker(), "/readyz")
+	if code != http.StatusOK || report.Status != StatusUp {
+		t.Errorf("expected 200 and up, got %d %+v", code, report)
+	}
+}
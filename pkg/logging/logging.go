@@ -0,0 +1,100 @@
+// Package logging sets up structured (log/slog) logging and carries request-
+// and message-scoped loggers in a context.Context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"awesomeProject/pkg/models"
+)
+
+// Attribute keys shared by every service, so log lines can be joined across
+// services on the same field names.
+const (
+	KeyService       = "service"
+	KeyCorrelationID = "correlation_id"
+	KeyEventID       = "event_id"
+	KeyEventType     = "event_type"
+	KeyUserID        = "user_id"
+	KeyTraceID       = "trace_id"
+	KeySpanID        = "span_id"
+	KeyError         = "error"
+)
+
+// New builds a logger for service writing to w. format is "json" or "text";
+// level is debug, info, warn or error.
+func New(service string, w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return slog.New(h).With(KeyService, service), nil
+}
+
+// Setup builds the service's logger on stderr and installs it as the slog
+// and standard library default, so stray log.Printf calls (including from
+// dependencies) come out in the same format. Invalid settings fall back to
+// JSON at info level.
+func Setup(service, format, level string) *slog.Logger {
+	logger, err := New(service, os.Stderr, format, level)
+	if err != nil {
+		logger, _ = New(service, os.Stderr, "json", "info")
+		logger.Warn("invalid logging config, using json/info", KeyError, err)
+	}
+	slog.SetDefault(logger)
+	return logger
+}
+
+// Fatal logs msg at error level and exits.
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+type ctxKey struct{}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// With adds attributes to ctx's logger, returning both the new context and
+// the enriched logger.
+func With(ctx context.Context, args ...any) (context.Context, *slog.Logger) {
+	logger := FromContext(ctx).With(args...)
+	return WithContext(ctx, logger), logger
+}
+
+// WithEvent adds an event's identifying fields to ctx's logger.
+func WithEvent(ctx context.Context, event models.UserEvent) (context.Context, *slog.Logger) {
+	return With(ctx,
+		KeyEventID, event.EventID,
+		KeyEventType, string(event.EventType),
+		KeyUserID, event.Data.ID,
+		KeyCorrelationID, event.CorrelationID,
+	)
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"awesomeProject/pkg/models"
+)
+
+func TestNew_JSONWithServiceAndLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New("crm-consumer", &buf, "json", "warn")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	logger.Info("dropped")
+	logger.Warn("kept", "n", 1)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "kept" || line[KeyService] != "crm-consumer" || line["level"] != "WARN" {
+		t.Errorf("unexpected log line: %v", line)
+	}
+}
+
+func TestNew_InvalidSettings(t *testing.T) {
+	if _, err := New("svc", &bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := New("svc", &bytes.Buffer{}, "json", "loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestWithEvent_CarriedInContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New("svc", &buf, "json", "info")
+	ctx := WithContext(context.Background(), logger)
+
+	ctx, _ = WithEvent(ctx, models.UserEvent{
+		EventID: "evt-1", EventType: models.EventUserCreated, CorrelationID: "corr-1", Data: models.User{ID: "user-1"},
+	})
+	FromContext(ctx).Info("processed")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("failed to parse log line: %v", err)
+	}
+	for key, want := range map[string]string{KeyEventID: "evt-1", KeyEventType: "user.created", KeyUserID: "user-1", KeyCorrelationID: "corr-1"} {
+		if line[key] != want {
+			t.Errorf("expected %s=%s, got %v", key, want, line[key])
+		}
+	}
+}
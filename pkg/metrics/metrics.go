@@ -0,0 +1,23 @@
+// Package metrics exposes the Prometheus metrics registered by the other
+// packages (via promauto on the default registry).
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// NewServer returns an HTTP server exposing Handler on /metrics, for
+// binaries that have no other HTTP listener.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+}
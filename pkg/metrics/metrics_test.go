@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+func TestNewServer_ServesMetrics(t *testing.T) {
+	promauto.NewCounter(prometheus.CounterOpts{Name: "metrics_test_hits_total", Help: "Hits."}).Inc()
+
+	w := httptest.NewRecorder()
+	NewServer(":0").Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "metrics_test_hits_total 1\n") {
+		t.Errorf("expected counter in output, got:\n%s", w.Body.String())
+	}
+}
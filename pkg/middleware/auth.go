@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"awesomeProject/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const APIKeyHeader = "X-API-Key"
+const PrincipalKey = "principal"
+
+// Principal types
+const (
+	PrincipalAPIKey = "api_key"
+	PrincipalJWT    = "jwt"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// none of the credentials it understands, so the next one can be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Type   string   `json:"type"`
+	ID     string   `json:"id"`
+	Scopes []string `json:"scopes"`
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves the principal behind a request. It returns
+// ErrNoCredentials if the request has no credentials of its kind, and any
+// other error if it has credentials that don't check out.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Authenticate is a Gin middleware that tries each authenticator in order and
+// stores the first principal found in the context. Requests with no
+// credentials, or with credentials that fail, are rejected with 401.
+func Authenticate(authenticators ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(c.Request)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err != nil {
+				Logger(c).Warn("authentication failed", logging.KeyError, err)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+				return
+			}
+			c.Set(PrincipalKey, p)
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="users"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+	}
+}
+
+// RequireScope is a Gin middleware that rejects principals without scope
+// with 403. It must run after Authenticate.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := GetPrincipal(c)
+		if p == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		if !p.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetPrincipal retrieves the authenticated principal from the Gin context,
+// or nil if the route is not authenticated.
+func GetPrincipal(c *gin.Context) *Principal {
+	if p, exists := c.Get(PrincipalKey); exists {
+		return p.(*Principal)
+	}
+	return nil
+}
+
+// APIKeyAuthenticator checks the X-API-Key header against the api_keys
+// table. Only SHA-256 hashes of keys are stored.
+type APIKeyAuthenticator struct {
+	DB *sql.DB
+}
+
+// Authenticate implements Authenticator.
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	p := &Principal{Type: PrincipalAPIKey}
+	err := a.DB.QueryRowContext(r.Context(),
+		"SELECT id, scopes FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL", HashAPIKey(key),
+	).Scan(&p.ID, pq.Array(&p.Scopes))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("unknown or revoked API key")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// HashAPIKey returns the form of key stored in api_keys.key_hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey generates a new key with the given scopes and stores its hash.
+// The returned plaintext key is not recoverable afterwards.
+func CreateAPIKey(db *sql.DB, name string, scopes []string) (id, key string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	id = uuid.New().String()
+	key = "uk_" + hex.EncodeToString(buf)
+
+	_, err = db.Exec(
+		"INSERT INTO api_keys (id, name, key_hash, scopes) VALUES ($1, $2, $3, $4)",
+		id, name, HashAPIKey(key), pq.Array(scopes),
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return id, key, nil
+}
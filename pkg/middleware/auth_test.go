@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// newAuthRouter serves GET /things behind API key auth and the given scope,
+// echoing the principal's ID.
+func newAuthRouter(t *testing.T, scope string) (*gin.Engine, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	r := gin.New()
+	r.GET("/things", Authenticate(&APIKeyAuthenticator{DB: db}), RequireScope(scope), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"principal": GetPrincipal(c).ID})
+	})
+	return r, mock
+}
+
+func getThings(r *gin.Engine, apiKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/things", nil)
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthenticate_APIKeyWithScope(t *testing.T) {
+	r, mock := newAuthRouter(t, "users:read")
+	mock.ExpectQuery("SELECT id, scopes FROM api_keys WHERE key_hash = \\$1 AND revoked_at IS NULL").
+		WithArgs(HashAPIKey("uk_secret")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "scopes"}).AddRow("key-1", "{users:read,users:write}"))
+
+	w := getThings(r, "uk_secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAuthenticate_MissingScope(t *testing.T) {
+	r, mock := newAuthRouter(t, "users:write")
+	mock.ExpectQuery("SELECT id, scopes FROM api_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "scopes"}).AddRow("key-1", "{users:read}"))
+
+	if w := getThings(r, "uk_secret"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+}
+
+func TestAuthenticate_UnknownKey(t *testing.T) {
+	r, mock := newAuthRouter(t, "users:read")
+	mock.ExpectQuery("SELECT id, scopes FROM api_keys").WillReturnError(sql.ErrNoRows)
+
+	if w := getThings(r, "uk_wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestAuthenticate_NoCredentials(t *testing.T) {
+	r, _ := newAuthRouter(t, "users:read")
+
+	w := getThings(r, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected WWW-Authenticate header")
+	}
+}
+
+func TestCreateAPIKey_StoresHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO api_keys").
+		WithArgs(sqlmock.AnyArg(), "ci", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	_, key, err := CreateAPIKey(db, "ci", []string{"users:read"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(key) != 67 || key[:3] != "uk_" {
+		t.Errorf("unexpected key format %q", key)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
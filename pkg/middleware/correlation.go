@@ -1,32 +1,69 @@
 package middleware
 
 import (
+	"log/slog"
+	"time"
+
+	"awesomeProject/pkg/correlation"
+	"awesomeProject/pkg/logging"
+
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
-const CorrelationIDHeader = "X-Correlation-ID"
+const CorrelationIDHeader = correlation.Header
 const CorrelationIDKey = "correlation_id"
 
-// CorrelationID is a Gin middleware that extracts or generates a correlation ID.
+// CorrelationID is a Gin middleware that takes the caller's correlation ID,
+// or generates one if it is missing or invalid (see correlation.Valid). It
+// puts the ID and a logger carrying it on the request context.
 func CorrelationID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		correlationID := c.GetHeader(CorrelationIDHeader)
-		if correlationID == "" {
-			correlationID = uuid.New().String()
+		if !correlation.Valid(correlationID) {
+			if correlationID != "" {
+				Logger(c).Warn("replacing invalid correlation ID", "length", len(correlationID))
+			}
+			correlationID = correlation.New()
 		}
 
 		c.Set(CorrelationIDKey, correlationID)
 		c.Header(CorrelationIDHeader, correlationID)
+		ctx, _ := logging.With(correlation.WithID(c.Request.Context(), correlationID), logging.KeyCorrelationID, correlationID)
+		c.Request = c.Request.WithContext(ctx)
 
 		c.Next()
 	}
 }
 
-// GetCorrelationID retrieves the correlation ID from the Gin context.
+// GetCorrelationID returns the request's correlation ID, or "" if the
+// CorrelationID middleware has not run.
 func GetCorrelationID(c *gin.Context) string {
-	if id, exists := c.Get(CorrelationIDKey); exists {
-		return id.(string)
+	return correlation.ID(c.Request.Context())
+}
+
+// Logger returns the request's logger, carrying its correlation ID.
+func Logger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+// RequestLogger is a Gin middleware that writes one structured access log
+// line per request, at warn level for 5xx responses. Use it after
+// CorrelationID so lines carry the correlation ID.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelWarn
+		}
+		Logger(c).Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
 	}
-	return uuid.New().String()
 }
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"awesomeProject/pkg/correlation"
+	"awesomeProject/pkg/logging"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -68,16 +74,13 @@ func TestCorrelationIDMiddleware_UsesExistingID(t *testing.T) {
 }
 
 func TestGetCorrelationID_NoContext(t *testing.T) {
-	// When called with a context that has no correlation ID set,
-	// it should generate a new UUID
+	// Without the middleware there is no correlation ID, and none is invented
 	r := gin.New()
 	r.GET("/test", func(c *gin.Context) {
-		// No middleware, so no correlation ID in context
-		id := GetCorrelationID(c)
-		if id == "" {
-			t.Error("expected a generated UUID, got empty string")
+		if id := GetCorrelationID(c); id != "" {
+			t.Errorf("expected no correlation ID, got %q", id)
 		}
-		c.String(http.StatusOK, id)
+		c.Status(http.StatusOK)
 	})
 
 	w := httptest.NewRecorder()
@@ -88,3 +91,68 @@ func TestGetCorrelationID_NoContext(t *testing.T) {
 		t.Fatalf("expected status 200, got %d", w.Code)
 	}
 }
+
+func TestCorrelationIDMiddleware_ReplacesInvalidID(t *testing.T) {
+	r := gin.New()
+	r.Use(CorrelationID())
+	r.GET("/test", func(c *gin.Context) {
+		c.String(http.StatusOK, correlation.ID(c.Request.Context()))
+	})
+
+	for _, id := range []string{"has spaces", "new\nline", strings.Repeat("a", correlation.MaxLength+1)} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set(CorrelationIDHeader, id)
+		r.ServeHTTP(w, req)
+
+		got := w.Header().Get(CorrelationIDHeader)
+		if got == id || !correlation.Valid(got) {
+			t.Errorf("expected %q to be replaced by a valid ID, got %q", id, got)
+		}
+		if w.Body.String() != got {
+			t.Errorf("context ID %q does not match header %q", w.Body.String(), got)
+		}
+	}
+}
+
+func TestCorrelationIDMiddleware_LoggerCarriesID(t *testing.T) {
+	var buf bytes.Buffer
+	base, err := logging.New("test", &buf, "json", "info")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), base))
+	}, CorrelationID(), RequestLogger())
+	r.GET("/test", func(c *gin.Context) {
+		Logger(c).Info("handled")
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set(CorrelationIDHeader, "corr-123")
+	r.ServeHTTP(w, req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected handler and access log lines, got %q", buf.String())
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		if entry["correlation_id"] != "corr-123" || entry["service"] != "test" {
+			t.Errorf("expected service and correlation_id on %q", line)
+		}
+	}
+
+	var access map[string]interface{}
+	_ = json.Unmarshal([]byte(lines[1]), &access)
+	if access["msg"] != "request" || access["status"] != float64(http.StatusNoContent) || access["path"] != "/test" {
+		t.Errorf("unexpected access log line: %q", lines[1])
+	}
+}
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"awesomeProject/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// DefaultIdempotencyTTL is how long a stored response is replayed for a key.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencySettleTimeout bounds storing or releasing a key after the
+// handler ran, which happens even if the request was cancelled.
+const idempotencySettleTimeout = 5 * time.Second
+
+// Idempotency is a Gin middleware that makes a route safe to retry. A request
+// carrying an Idempotency-Key header claims the key in the request_idempotency
+// table; once the handler finishes, its status and body are stored and any
+// retry with the same key within ttl gets them back verbatim. Reusing a key
+// with a different request body is rejected with 422, and a retry that
+// arrives while the original is still running gets 409. Server errors
+// release the key so the client can try again. Keys are scoped to the
+// authenticated principal, if any, so clients that happen to pick the same
+// key never see each other's responses.
+func Idempotency(db *sql.DB, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(c.Request.Method, c.Request.URL.Path, body)
+		principal := idempotencyPrincipal(c)
+
+		claimed, err := claimIdempotencyKey(c.Request.Context(), db, principal, key, hash, ttl)
+		if err != nil {
+			Logger(c).Error("error claiming idempotency key", logging.KeyError, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check idempotency key"})
+			return
+		}
+		if !claimed {
+			replayIdempotentResponse(c, db, principal, key, hash)
+			return
+		}
+
+		rec := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+
+		// Settle the key even if the client has gone away (the case retries
+		// exist for), or every retry would get 409 until the key expires
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), idempotencySettleTimeout)
+		defer cancel()
+
+		status := rec.Status()
+		if status >= http.StatusInternalServerError {
+			if _, err := db.ExecContext(ctx, "DELETE FROM request_idempotency WHERE principal = $1 AND key = $2", principal, key); err != nil {
+				Logger(c).Error("error releasing idempotency key", logging.KeyError, err)
+			}
+			return
+		}
+		_, err = db.ExecContext(ctx,
+			"UPDATE request_idempotency SET status_code = $3, content_type = $4, response_body = $5, completed_at = NOW() WHERE principal = $1 AND key = $2",
+			principal, key, status, rec.Header().Get("Content-Type"), rec.body.Bytes(),
+		)
+		if err != nil {
+			Logger(c).Error("error storing idempotent response", logging.KeyError, err)
+		}
+	}
+}
+
+// idempotencyPrincipal scopes keys to the authenticated principal; it is
+// empty on unauthenticated routes.
+func idempotencyPrincipal(c *gin.Context) string {
+	if p := GetPrincipal(c); p != nil {
+		return p.Type + ":" + p.ID
+	}
+	return ""
+}
+
+// claimIdempotencyKey records the principal's key as in flight. It succeeds
+// for a new key or one whose previous use has expired, and reports false if
+// the key is live.
+func claimIdempotencyKey(ctx context.Context, db *sql.DB, principal, key, hash string, ttl time.Duration) (bool, error) {
+	res, err := db.ExecContext(ctx,
+		`INSERT INTO request_idempotency (principal, key, request_hash, created_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (principal, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = NULL,
+			content_type = NULL, response_body = NULL, created_at = NOW(), completed_at = NULL
+		WHERE request_idempotency.created_at < NOW() - $4 * INTERVAL '1 second'`,
+		principal, key, hash, int64(ttl/time.Second),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// replayIdempotentResponse answers a request whose key is already claimed.
+func replayIdempotentResponse(c *gin.Context, db *sql.DB, principal, key, hash string) {
+	var storedHash string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err := db.QueryRowContext(c.Request.Context(),
+		"SELECT request_hash, status_code, content_type, response_body FROM request_idempotency WHERE principal = $1 AND key = $2",
+		principal, key,
+	).Scan(&storedHash, &status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		// Released by a failed original between our claim and this read
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this Idempotency-Key is being retried, try again"})
+		return
+	}
+	if err != nil {
+		Logger(c).Error("error reading idempotency key", logging.KeyError, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check idempotency key"})
+		return
+	}
+
+	if storedHash != hash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+		return
+	}
+	if !status.Valid {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this Idempotency-Key is still in progress"})
+		return
+	}
+
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Data(int(status.Int64), contentType.String, body)
+	c.Abort()
+}
+
+// PurgeIdempotencyKeys deletes keys older than ttl and returns how many went.
+func PurgeIdempotencyKeys(db *sql.DB, ttl time.Duration) (int64, error) {
+	res, err := db.Exec(
+		"DELETE FROM request_idempotency WHERE created_at < NOW() - $1 * INTERVAL '1 second'",
+		int64(ttl/time.Second),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// requestHash fingerprints the parts of a request a key must not change.
+func requestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bodyRecorder tees everything the handler writes into body.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *bodyRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
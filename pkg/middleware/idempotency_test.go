@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// newIdempotentRouter serves POST /things through the middleware, counting
+// how often the handler actually runs.
+func newIdempotentRouter(t *testing.T, status int) (*gin.Engine, sqlmock.Sqlmock, *int) {
+	t.Helper()
+	calls := 0
+	r, mock := idempotentRouter(t, nil, func(c *gin.Context) {
+		calls++
+		c.JSON(status, gin.H{"id": "thing-1"})
+	})
+	return r, mock, &calls
+}
+
+// idempotentRouter serves POST /things with handler behind the middleware,
+// as principal if it is non-nil.
+func idempotentRouter(t *testing.T, principal *Principal, handler gin.HandlerFunc) (*gin.Engine, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	r := gin.New()
+	if principal != nil {
+		r.Use(func(c *gin.Context) { c.Set(PrincipalKey, principal) })
+	}
+	r.POST("/things", Idempotency(db, time.Hour), handler)
+	return r, mock
+}
+
+func postThing(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/things", bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency_FirstRequestStoresResponse(t *testing.T) {
+	r, mock, calls := newIdempotentRouter(t, http.StatusCreated)
+
+	hash := requestHash(http.MethodPost, "/things", []byte(`{"a":1}`))
+	mock.ExpectExec("INSERT INTO request_idempotency").
+		WithArgs("", "key-1", hash, int64(3600)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE request_idempotency SET status_code").
+		WithArgs("", "key-1", http.StatusCreated, "application/json; charset=utf-8", []byte(`{"id":"thing-1"}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := postThing(r, "key-1", `{"a":1}`)
+	if w.Code != http.StatusCreated || *calls != 1 {
+		t.Fatalf("expected handler to run once with 201, got %d after %d calls", w.Code, *calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestIdempotency_ReplaysStoredResponse(t *testing.T) {
+	r, mock, calls := newIdempotentRouter(t, http.StatusCreated)
+
+	hash := requestHash(http.MethodPost, "/things", []byte(`{"a":1}`))
+	mock.ExpectExec("INSERT INTO request_idempotency").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT request_hash, status_code, content_type, response_body FROM request_idempotency").
+		WithArgs("", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+			AddRow(hash, 201, "application/json", []byte(`{"id":"original"}`)))
+
+	w := postThing(r, "key-1", `{"a":1}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected replayed 201, got %d", w.Code)
+	}
+	if w.Body.String() != `{"id":"original"}` {
+		t.Errorf("expected original body, got %s", w.Body.String())
+	}
+	if w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Error("expected Idempotent-Replayed header")
+	}
+	if *calls != 0 {
+		t.Errorf("handler must not run on replay, ran %d times", *calls)
+	}
+}
+
+func TestIdempotency_DifferentBodyIsRejected(t *testing.T) {
+	r, mock, calls := newIdempotentRouter(t, http.StatusCreated)
+
+	mock.ExpectExec("INSERT INTO request_idempotency").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT request_hash").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+			AddRow("some-other-hash", 201, "application/json", []byte(`{}`)))
+
+	w := postThing(r, "key-1", `{"a":2}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", w.Code)
+	}
+	if *calls != 0 {
+		t.Errorf("handler must not run, ran %d times", *calls)
+	}
+}
+
+func TestIdempotency_InFlightIsConflict(t *testing.T) {
+	r, mock, _ := newIdempotentRouter(t, http.StatusCreated)
+
+	hash := requestHash(http.MethodPost, "/things", []byte(`{}`))
+	mock.ExpectExec("INSERT INTO request_idempotency").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT request_hash").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+			AddRow(hash, nil, nil, nil))
+
+	if w := postThing(r, "key-1", `{}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+}
+
+func TestIdempotency_ServerErrorReleasesKey(t *testing.T) {
+	r, mock, _ := newIdempotentRouter(t, http.StatusInternalServerError)
+
+	mock.ExpectExec("INSERT INTO request_idempotency").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM request_idempotency WHERE principal = \\$1 AND key = \\$2").
+		WithArgs("", "key-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if w := postThing(r, "key-1", `{}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestIdempotency_NoKeyPassesThrough(t *testing.T) {
+	r, mock, calls := newIdempotentRouter(t, http.StatusCreated)
+
+	postThing(r, "", `{}`)
+	postThing(r, "", `{}`)
+	if *calls != 2 {
+		t.Errorf("expected handler to run twice without a key, ran %d times", *calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected database access: %v", err)
+	}
+}
+
+func TestIdempotency_StoresResponseAfterClientCancels(t *testing.T) {
+	var cancel context.CancelFunc
+	r, mock := idempotentRouter(t, nil, func(c *gin.Context) {
+		cancel() // the client disconnects while the handler runs
+		c.JSON(http.StatusCreated, gin.H{"id": "thing-1"})
+	})
+
+	mock.ExpectExec("INSERT INTO request_idempotency").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE request_idempotency SET status_code").
+		WithArgs("", "key-1", http.StatusCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancel = cancelFn
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/things", bytes.NewBufferString(`{}`))
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the response to be stored despite the cancelled request: %v", err)
+	}
+}
+
+func TestIdempotency_KeysAreScopedByPrincipal(t *testing.T) {
+	r, mock := idempotentRouter(t, &Principal{Type: "api_key", ID: "key-a"}, func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"id": "thing-1"})
+	})
+
+	mock.ExpectExec("INSERT INTO request_idempotency \\(principal, key, request_hash, created_at\\)").
+		WithArgs("api_key:key-a", "shared-key", sqlmock.AnyArg(), int64(3600)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE request_idempotency SET status_code .* WHERE principal = \\$1 AND key = \\$2").
+		WithArgs("api_key:key-a", "shared-key", http.StatusCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if w := postThing(r, "shared-key", `{}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
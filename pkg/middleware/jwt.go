@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWKS is a set of verification keys loaded from a JSON Web Key Set file.
+// "oct" keys verify HS256 tokens and "RSA" keys verify RS256 tokens.
+type JWKS struct {
+	keys []jwk
+}
+
+type jwk struct {
+	kid    string
+	alg    string
+	secret []byte
+	rsa    *rsa.PublicKey
+}
+
+// LoadJWKS reads a JWKS file.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses a JWKS document ({"keys": [...]}).
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	set := &JWKS{}
+	for i, k := range doc.Keys {
+		key := jwk{kid: k.Kid, alg: k.Alg}
+		switch k.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("JWKS key %d: invalid oct key", i)
+			}
+			key.secret = secret
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+				return nil, fmt.Errorf("JWKS key %d: invalid RSA key", i)
+			}
+			key.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		default:
+			return nil, fmt.Errorf("JWKS key %d: unsupported kty %q", i, k.Kty)
+		}
+		set.keys = append(set.keys, key)
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("JWKS has no keys")
+	}
+	return set, nil
+}
+
+// lookup finds the key for a token header. Keys are matched on kid, and
+// always on type, so an RS256 public key can never be used as an HS256
+// secret. Without a kid the token must match exactly one key.
+func (s *JWKS) lookup(alg, kid string) (jwk, error) {
+	var found []jwk
+	for _, k := range s.keys {
+		if alg == "HS256" && k.secret == nil || alg == "RS256" && k.rsa == nil {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		found = append(found, k)
+	}
+	if len(found) != 1 {
+		return jwk{}, fmt.Errorf("no unique %s key for kid %q", alg, kid)
+	}
+	return found[0], nil
+}
+
+// JWTAuthenticator validates "Authorization: Bearer" tokens signed with
+// HS256 or RS256 against a JWKS. The subject becomes the principal ID and
+// the "scope" (space separated) or "scp" (array) claim its scopes.
+type JWTAuthenticator struct {
+	Keys *JWKS
+
+	// Issuer and Audience are checked when set
+	Issuer   string
+	Audience string
+
+	// Leeway allows for clock skew on exp and nbf
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       []string        `json:"scp"`
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := claims.Scp
+	if claims.Scope != "" {
+		scopes = strings.Fields(claims.Scope)
+	}
+	return &Principal{Type: PrincipalJWT, ID: claims.Subject, Scopes: scopes}, nil
+}
+
+// verify checks the token's signature and registered claims.
+func (a *JWTAuthenticator) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var head struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if head.Alg != "HS256" && head.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported alg %q", head.Alg)
+	}
+	key, err := a.Keys.lookup(head.Alg, head.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch head.Alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("invalid token signature")
+		}
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key.rsa, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no exp")
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(a.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(a.Leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no sub")
+	}
+	if a.Issuer != "" && claims.Issuer != a.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if a.Audience != "" && !audienceContains(claims.Audience, a.Audience) {
+		return nil, errors.New("token not issued for this audience")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// audienceContains handles aud as either a string or an array of strings.
+func audienceContains(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, a := range many {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
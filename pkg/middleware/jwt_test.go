@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var hmacSecret = []byte("test-secret-test-secret-test-sec")
+
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+func signHS256(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	head, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": kid})
+	body, _ := json.Marshal(claims)
+	signed := b64(head) + "." + b64(body)
+	mac := hmac.New(sha256.New, hmacSecret)
+	mac.Write([]byte(signed))
+	return signed + "." + b64(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	head, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	body, _ := json.Marshal(claims)
+	signed := b64(head) + "." + b64(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return signed + "." + b64(sig)
+}
+
+// newJWTAuthenticator returns an authenticator trusting hmacSecret as "hs"
+// and a fresh RSA key as "rs".
+func newJWTAuthenticator(t *testing.T) (*JWTAuthenticator, *rsa.PrivateKey) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "oct", "kid": "hs", "k": %q},
+		{"kty": "RSA", "kid": "rs", "alg": "RS256", "n": %q, "e": %q}
+	]}`, b64(hmacSecret), b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()))
+
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatalf("failed to parse JWKS: %v", err)
+	}
+	return &JWTAuthenticator{Keys: keys, Issuer: "https://issuer.test", Audience: "users-api"}, rsaKey
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "svc-billing",
+		"iss":   "https://issuer.test",
+		"aud":   []string{"users-api", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "users:read users:write",
+	}
+}
+
+func bearer(token string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWT_HS256(t *testing.T) {
+	a, _ := newJWTAuthenticator(t)
+
+	p, err := a.Authenticate(bearer(signHS256(t, "hs", validClaims())))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if p.Type != PrincipalJWT || p.ID != "svc-billing" || !p.HasScope("users:write") {
+		t.Errorf("unexpected principal: %+v", p)
+	}
+}
+
+func TestJWT_RS256(t *testing.T) {
+	a, rsaKey := newJWTAuthenticator(t)
+	claims := validClaims()
+	delete(claims, "scope")
+	claims["scp"] = []string{"users:read"}
+
+	p, err := a.Authenticate(bearer(signRS256(t, rsaKey, "rs", claims)))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !p.HasScope("users:read") || p.HasScope("users:write") {
+		t.Errorf("unexpected scopes: %v", p.Scopes)
+	}
+}
+
+func TestJWT_Rejected(t *testing.T) {
+	a, rsaKey := newJWTAuthenticator(t)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAud := validClaims()
+	wrongAud["aud"] = "someone-else"
+	noExp := validClaims()
+	delete(noExp, "exp")
+
+	tests := map[string]string{
+		"expired":          signHS256(t, "hs", expired),
+		"wrong audience":   signHS256(t, "hs", wrongAud),
+		"no exp":           signHS256(t, "hs", noExp),
+		"unknown kid":      signHS256(t, "nope", validClaims()),
+		"wrong signer":     signRS256(t, otherKey, "rs", validClaims()),
+		"alg/key mismatch": signRS256(t, rsaKey, "hs", validClaims()),
+		"malformed":        "not-a-token",
+	}
+	for name, token := range tests {
+		if _, err := a.Authenticate(bearer(token)); err == nil || err == ErrNoCredentials {
+			t.Errorf("%s: expected a validation error, got %v", name, err)
+		}
+	}
+}
+
+func TestJWT_NoBearer(t *testing.T) {
+	a, _ := newJWTAuthenticator(t)
+	req, _ := http.NewRequest(http.MethodGet, "/users", nil)
+
+	if _, err := a.Authenticate(req); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials, got %v", err)
+	}
+}
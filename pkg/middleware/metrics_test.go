@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// requestCount is how many requests the latency histogram has recorded for
+// the given labels.
+func requestCount(t *testing.T, method, route, status string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := httpRequestDuration.WithLabelValues(method, route, status).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics_RecordsRouteTemplate(t *testing.T) {
+	r := gin.New()
+	r.Use(Metrics())
+	r.GET("/metrics-test/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/metrics-test-missing"} {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if n := requestCount(t, "GET", "/metrics-test/:id", "200"); n != 2 {
+		t.Errorf("expected two requests on the route template, got %d", n)
+	}
+	if n := requestCount(t, "GET", "unmatched", "404"); n == 0 {
+		t.Error("expected the unmatched request to be recorded")
+	}
+}
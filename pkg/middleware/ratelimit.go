@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"awesomeProject/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Rate per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests per minute, all of which may arrive at once.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed bool
+	// Tokens left in the bucket after this request
+	Remaining float64
+}
+
+// RateLimitStore keeps token buckets. Take refills the bucket for key,
+// removes one token if there is one, and reports what happened.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// RateLimit is a Gin middleware that gives each client a token bucket per
+// scope (a route group name). Clients are keyed by authenticated principal,
+// else by IP; an unverified X-API-Key is ignored, as a client could send a
+// new one with each request to get a fresh bucket. Every response carries RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers; requests over the limit
+// get 429 with Retry-After. If the store fails the request is let through.
+func RateLimit(store RateLimitStore, limit Limit, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d, err := store.Take(c.Request.Context(), scope+":"+rateLimitClient(c), limit)
+		if err != nil {
+			Logger(c).Error("rate limit store error, allowing request", logging.KeyError, err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Floor(d.Remaining))))
+		c.Header("RateLimit-Reset", strconv.Itoa(secondsUntil(float64(limit.Burst)-d.Remaining, limit.Rate)))
+		if !d.Allowed {
+			c.Header("Retry-After", strconv.Itoa(secondsUntil(1-d.Remaining, limit.Rate)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitClient identifies who a request counts against.
+func rateLimitClient(c *gin.Context) string {
+	if p := GetPrincipal(c); p != nil {
+		return p.Type + ":" + p.ID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// secondsUntil is how long, rounded up, it takes to refill tokens at rate.
+func secondsUntil(tokens, rate float64) int {
+	if tokens <= 0 || rate <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / rate))
+}
+
+// MemoryRateLimitStore keeps buckets in process. Each API replica then
+// enforces its own limits; use PostgresRateLimitStore to share them.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Take implements RateLimitStore.
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return Decision{Allowed: false, Remaining: b.tokens}, nil
+	}
+	b.tokens--
+	return Decision{Allowed: true, Remaining: b.tokens}, nil
+}
+
+// sweep drops buckets that have refilled completely, at most once a minute,
+// so idle clients don't accumulate.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// PostgresRateLimitStore keeps buckets in the rate_limit_buckets table so
+// every API replica draws from the same bucket. Refill and take happen in a
+// single upsert, timed by the database clock.
+type PostgresRateLimitStore struct {
+	DB *sql.DB
+}
+
+// refilled is the bucket's token count after refilling up to now.
+const refilled = "LEAST($2, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM (NOW() - rate_limit_buckets.updated_at)) * $3)"
+
+// Take implements RateLimitStore.
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, limit Limit) (Decision, error) {
+	var d Decision
+	err := s.DB.QueryRowContext(ctx, fmt.Sprintf(
+		`INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at) VALUES ($1, $2 - 1, TRUE, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE WHEN %[1]s >= 1 THEN %[1]s - 1 ELSE %[1]s END,
+			allowed = %[1]s >= 1,
+			updated_at = NOW()
+		RETURNING allowed, tokens`, refilled),
+		key, float64(limit.Burst), limit.Rate,
+	).Scan(&d.Allowed, &d.Remaining)
+	return d, err
+}
+
+// PurgeRateLimitBuckets deletes shared buckets untouched for longer than idle
+// and returns how many went.
+func PurgeRateLimitBuckets(db *sql.DB, idle time.Duration) (int64, error) {
+	res, err := db.Exec(
+		"DELETE FROM rate_limit_buckets WHERE updated_at < NOW() - $1 * INTERVAL '1 second'",
+		int64(idle/time.Second),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func newLimitedRouter(store RateLimitStore, limit Limit) *gin.Engine {
+	r := gin.New()
+	r.POST("/things", RateLimit(store, limit, "write"), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"id": "thing-1"})
+	})
+	return r
+}
+
+func postFrom(r *gin.Engine, ip string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/things", nil)
+	req.RemoteAddr = ip + ":1234"
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMemoryRateLimitStore_RefillsOverTime(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if d, _ := store.Take(context.Background(), "k", limit); !d.Allowed {
+			t.Fatalf("request %d: expected allowed", i+1)
+		}
+	}
+	if d, _ := store.Take(context.Background(), "k", limit); d.Allowed {
+		t.Fatal("expected third request to be limited")
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	d, _ := store.Take(context.Background(), "k", limit)
+	if !d.Allowed || d.Remaining < 0.49 || d.Remaining > 0.51 {
+		t.Fatalf("expected allowed with 0.5 tokens left after refill, got %+v", d)
+	}
+}
+
+func TestRateLimit_HeadersAnd429(t *testing.T) {
+	r := newLimitedRouter(NewMemoryRateLimitStore(), Limit{Rate: 0.5, Burst: 2})
+
+	w := postFrom(r, "10.0.0.1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" || w.Header().Get("RateLimit-Reset") != "2" {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+
+	postFrom(r, "10.0.0.1")
+	w = postFrom(r, "10.0.0.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected headers on 429: %v", w.Header())
+	}
+
+	// Other clients have their own bucket
+	if w := postFrom(r, "10.0.0.2"); w.Code != http.StatusCreated {
+		t.Fatalf("expected a different IP to be allowed, got %d", w.Code)
+	}
+}
+
+func TestRateLimit_KeysByPrincipal(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	r := gin.New()
+	r.POST("/things", func(c *gin.Context) {
+		c.Set(PrincipalKey, &Principal{Type: PrincipalAPIKey, ID: c.GetHeader("X-Test-Principal")})
+	}, RateLimit(store, Limit{Rate: 0.01, Burst: 1}, "write"), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	send := func(principal string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/things", nil)
+		req.Header.Set("X-Test-Principal", principal)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if send("key-1") != http.StatusCreated || send("key-1") != http.StatusTooManyRequests {
+		t.Fatal("expected key-1 to be limited on its second request")
+	}
+	if send("key-2") != http.StatusCreated {
+		t.Fatal("expected key-2 to have its own bucket from the same IP")
+	}
+}
+
+func TestRateLimit_IgnoresUnverifiedAPIKeys(t *testing.T) {
+	r := newLimitedRouter(NewMemoryRateLimitStore(), Limit{Rate: 0.01, Burst: 2})
+
+	// Without a verified principal, every key from one IP shares its bucket
+	var code int
+	for _, key := range []string{"bogus-1", "bogus-2", "bogus-3"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/things", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(APIKeyHeader, key)
+		r.ServeHTTP(w, req)
+		code = w.Code
+	}
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("expected rotating API keys to be limited, got %d", code)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (Decision, error) {
+	return Decision{}, errors.New("db down")
+}
+
+func TestRateLimit_StoreErrorFailsOpen(t *testing.T) {
+	if w := postFrom(newLimitedRouter(failingStore{}, PerMinute(1)), "10.0.0.1"); w.Code != http.StatusCreated {
+		t.Fatalf("expected request through on store error, got %d", w.Code)
+	}
+}
+
+func TestPostgresRateLimitStore_Take(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO rate_limit_buckets .* ON CONFLICT \\(key\\) DO UPDATE SET .* RETURNING allowed, tokens").
+		WithArgs("write:ip:10.0.0.1", float64(60), float64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"allowed", "tokens"}).AddRow(false, 0.25))
+
+	d, err := (&PostgresRateLimitStore{DB: db}).Take(context.Background(), "write:ip:10.0.0.1", PerMinute(60))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if d.Allowed || d.Remaining != 0.25 {
+		t.Errorf("unexpected decision: %+v", d)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
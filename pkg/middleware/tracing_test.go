@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"awesomeProject/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider and W3C propagator for the test and
+// returns the recorder that sees every ended span.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return rec
+}
+
+func TestTracing_ContinuesTraceparentAndNamesRoute(t *testing.T) {
+	rec := recordSpans(t)
+
+	r := gin.New()
+	r.Use(Tracing())
+	var handlerSpan trace.SpanContext
+	r.GET("/tracing-test/:id", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/tracing-test/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "GET /tracing-test/:id" {
+		t.Errorf("expected span named after the route template, got %q", s.Name())
+	}
+	if s.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the caller's trace ID, got %s", s.SpanContext().TraceID())
+	}
+	if s.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the caller's span as parent, got %s", s.Parent().SpanID())
+	}
+	if handlerSpan.SpanID() != s.SpanContext().SpanID() {
+		t.Error("expected the span on the request context")
+	}
+}
+
+func TestTracing_AddsIDsToRequestLogger(t *testing.T) {
+	rec := recordSpans(t)
+	var buf bytes.Buffer
+	base, err := logging.New("test", &buf, "json", "info")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(logging.WithContext(c.Request.Context(), base))
+	}, Tracing(), CorrelationID())
+	r.GET("/tracing-test", func(c *gin.Context) {
+		Logger(c).Info("handled")
+		c.Status(http.StatusNoContent)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tracing-test", nil))
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON log line %q: %v", buf.String(), err)
+	}
+	sc := spans[0].SpanContext()
+	if entry[logging.KeyTraceID] != sc.TraceID().String() || entry[logging.KeySpanID] != sc.SpanID().String() {
+		t.Errorf("expected trace and span IDs of %v in log line, got %v", sc, entry)
+	}
+	if entry[logging.KeyCorrelationID] == nil {
+		t.Errorf("expected correlation ID alongside trace IDs, got %v", entry)
+	}
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Attributes is a free-form map of user profile attributes, stored as JSONB.
+type Attributes map[string]interface{}
+
+// Value implements driver.Valuer, encoding nil as an empty object.
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]interface{}(a))
+}
+
+// Scan implements sql.Scanner for JSONB columns.
+func (a *Attributes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("attributes: unsupported type %T", src)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*a = m
+	return nil
+}
+
+// Merge applies patch to a copy of a: keys in patch overwrite, and keys whose
+// patch value is null are removed.
+func (a Attributes) Merge(patch Attributes) Attributes {
+	out := make(Attributes, len(a)+len(patch))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		out[k] = v
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// Attribute value types accepted by AttributeRule.Type.
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+)
+
+// AttributeRule constrains the value of one attribute key.
+type AttributeRule struct {
+	Type      string   `json:"type"`
+	Required  bool     `json:"required,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// AttributeSchema holds the per-key attribute rules loaded at startup. Keys
+// without a rule are rejected unless AllowUnknown is set.
+type AttributeSchema struct {
+	Rules        map[string]*AttributeRule `json:"rules"`
+	AllowUnknown bool                      `json:"allow_unknown"`
+}
+
+// ParseAttributeSchema decodes a JSON schema and compiles its patterns.
+func ParseAttributeSchema(data []byte) (*AttributeSchema, error) {
+	var s AttributeSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid attribute schema: %w", err)
+	}
+	for key, rule := range s.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("attribute %q: rule must be an object, got null", key)
+		}
+		switch rule.Type {
+		case AttributeString, AttributeNumber, AttributeBoolean:
+		default:
+			return nil, fmt.Errorf("attribute %q: unknown type %q", key, rule.Type)
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %q: invalid pattern: %w", key, err)
+			}
+			rule.pattern = re
+		}
+	}
+	return &s, nil
+}
+
+// Validate checks attrs against the schema. A nil schema accepts anything.
+func (s *AttributeSchema) Validate(attrs Attributes) error {
+	if s == nil {
+		return nil
+	}
+
+	var problems []string
+	for key, rule := range s.Rules {
+		if _, ok := attrs[key]; !ok && rule.Required {
+			problems = append(problems, fmt.Sprintf("attributes.%s is required", key))
+		}
+	}
+	for key, value := range attrs {
+		rule, ok := s.Rules[key]
+		if !ok {
+			if !s.AllowUnknown {
+				problems = append(problems, fmt.Sprintf("attributes.%s is not a known attribute", key))
+			}
+			continue
+		}
+		if err := rule.check(value); err != nil {
+			problems = append(problems, fmt.Sprintf("attributes.%s %v", key, err))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%s", strings.Join(problems, "; "))
+}
+
+// check validates a single decoded JSON value against the rule.
+func (r *AttributeRule) check(value interface{}) error {
+	switch r.Type {
+	case AttributeString:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if r.MaxLength > 0 && utf8.RuneCountInString(v) > r.MaxLength {
+			return fmt.Errorf("must be at most %d characters", r.MaxLength)
+		}
+		if len(r.Enum) > 0 && !containsString(r.Enum, v) {
+			return fmt.Errorf("must be one of %s", strings.Join(r.Enum, ", "))
+		}
+		if r.pattern != nil && !r.pattern.MatchString(v) {
+			return fmt.Errorf("must match %s", r.Pattern)
+		}
+	case AttributeNumber:
+		v, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("must be a number")
+		}
+		if r.Min != nil && v < *r.Min {
+			return fmt.Errorf("must be at least %g", *r.Min)
+		}
+		if r.Max != nil && v > *r.Max {
+			return fmt.Errorf("must be at most %g", *r.Max)
+		}
+	case AttributeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
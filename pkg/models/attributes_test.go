@@ -0,0 +1,101 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"rules": {
+		"plan":  {"type": "string", "required": true, "enum": ["free", "pro"]},
+		"seats": {"type": "number", "min": 1, "max": 500},
+		"beta":  {"type": "boolean"},
+		"sku":   {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$", "max_length": 12},
+		"city":  {"type": "string", "max_length": 6}
+	}
+}`
+
+func TestAttributeSchema_Validate(t *testing.T) {
+	schema, err := ParseAttributeSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		attrs   Attributes
+		wantErr string
+	}{
+		{"valid", Attributes{"plan": "pro", "seats": float64(10), "beta": true, "sku": "ABC-1"}, ""},
+		{"missing required", Attributes{"seats": float64(1)}, "attributes.plan is required"},
+		{"not in enum", Attributes{"plan": "gold"}, "must be one of free, pro"},
+		{"wrong type", Attributes{"plan": "free", "seats": "ten"}, "attributes.seats must be a number"},
+		{"below min", Attributes{"plan": "free", "seats": float64(0)}, "must be at least 1"},
+		{"pattern", Attributes{"plan": "free", "sku": "abc"}, "attributes.sku must match"},
+		{"max length counts characters", Attributes{"plan": "free", "city": "Zürich"}, ""},
+		{"too long", Attributes{"plan": "free", "city": "Münster"}, "attributes.city must be at most 6 characters"},
+		{"unknown key", Attributes{"plan": "free", "color": "red"}, "attributes.color is not a known attribute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(tt.attrs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAttributeSchema_AllowUnknownAndNil(t *testing.T) {
+	schema, err := ParseAttributeSchema([]byte(`{"allow_unknown": true}`))
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	if err := schema.Validate(Attributes{"anything": "goes"}); err != nil {
+		t.Errorf("expected unknown keys to be allowed, got %v", err)
+	}
+
+	var none *AttributeSchema
+	if err := none.Validate(Attributes{"x": 1}); err != nil {
+		t.Errorf("nil schema should accept anything, got %v", err)
+	}
+}
+
+func TestParseAttributeSchema_Invalid(t *testing.T) {
+	for _, in := range []string{
+		`{"rules": {"a": {"type": "date"}}}`,
+		`{"rules": {"a": {"type": "string", "pattern": "("}}}`,
+		`{"rules": {"a": null}}`,
+		`not json`,
+	} {
+		if _, err := ParseAttributeSchema([]byte(in)); err == nil {
+			t.Errorf("expected error for %s", in)
+		}
+	}
+}
+
+func TestAttributes_MergeAndScan(t *testing.T) {
+	merged := Attributes{"a": "1", "b": "2"}.Merge(Attributes{"b": nil, "c": "3"})
+	if len(merged) != 2 || merged["a"] != "1" || merged["c"] != "3" {
+		t.Errorf("unexpected merge result: %v", merged)
+	}
+
+	var scanned Attributes
+	if err := scanned.Scan([]byte(`{"plan":"pro"}`)); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if scanned["plan"] != "pro" {
+		t.Errorf("unexpected scanned attributes: %v", scanned)
+	}
+
+	v, err := Attributes(nil).Value()
+	if err != nil || string(v.([]byte)) != "{}" {
+		t.Errorf("expected nil attributes to encode as {}, got %s (%v)", v, err)
+	}
+}
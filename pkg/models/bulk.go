@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Bulk import row statuses.
+const (
+	BulkRowCreated = "created"
+	BulkRowError   = "error"
+)
+
+// Import job statuses.
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// BulkRowResult is the outcome for one row of a bulk import.
+type BulkRowResult struct {
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkImportResult summarises a bulk import.
+type BulkImportResult struct {
+	Total     int             `json:"total"`
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Results   []BulkRowResult `json:"results"`
+}
+
+// ImportJob tracks an asynchronous bulk import.
+type ImportJob struct {
+	ID         string            `json:"id"`
+	Status     string            `json:"status"`
+	Total      int               `json:"total"`
+	Result     *BulkImportResult `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
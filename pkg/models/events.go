@@ -1,21 +1,113 @@
 package models
 
-import "time"
+import (
+	"context"
+	"time"
+
+	"awesomeProject/pkg/correlation"
+
+	"github.com/google/uuid"
+)
 
 // EventType represents the type of domain event.
 type EventType string
 
 const (
-	EventUserCreated EventType = "user.created"
-	EventUserUpdated EventType = "user.updated"
-	EventUserDeleted EventType = "user.deleted"
+	EventUserCreated     EventType = "user.created"
+	EventUserUpdated     EventType = "user.updated"
+	EventUserDeleted     EventType = "user.deleted"
+	EventUserSuspended   EventType = "user.suspended"
+	EventUserReactivated EventType = "user.reactivated"
+	EventUserErased      EventType = "user.erased"
+	// EventUserReminder nudges a user still pending; it is scheduled, not
+	// stored in the event store
+	EventUserReminder EventType = "user.reminder"
+)
+
+// Result events, emitted by consumers once they have handled a user event.
+const (
+	EventCRMContactSynced     EventType = "crm.contact.synced"
+	EventCRMContactSyncFailed EventType = "crm.contact.sync_failed"
+	EventAnalyticsUpdated     EventType = "analytics.updated"
 )
 
 // UserEvent represents an event related to a user.
 type UserEvent struct {
 	EventID       string    `json:"event_id"`
 	CorrelationID string    `json:"correlation_id"`
+	CausationID   string    `json:"causation_id,omitempty"` // event that led to this one; empty if a request did
 	EventType     EventType `json:"event_type"`
 	Timestamp     time.Time `json:"timestamp"`
 	Data          User      `json:"data"`
+
+	// Actor is who caused the event; nil when the API runs without auth
+	Actor *Actor `json:"actor,omitempty"`
+
+	// StreamVersion is the event's position in the user's stream, set by
+	// the event store on append; zero for events it doesn't store
+	StreamVersion int `json:"stream_version,omitempty"`
+}
+
+// NewUserEvent builds an event about user with a fresh ID, taking its
+// correlation and causation IDs from ctx. Outside a request or delivery it
+// starts a new correlation.
+func NewUserEvent(ctx context.Context, eventType EventType, user User) UserEvent {
+	correlationID := correlation.ID(ctx)
+	if correlationID == "" {
+		correlationID = correlation.New()
+	}
+	return UserEvent{
+		EventID:       uuid.New().String(),
+		CorrelationID: correlationID,
+		CausationID:   correlation.Causation(ctx),
+		EventType:     eventType,
+		Timestamp:     time.Now(),
+		Data:          user,
+	}
+}
+
+// ResultEvent reports how a consumer handled a user event. Its causation ID
+// is always the source event's ID.
+type ResultEvent struct {
+	EventID         string    `json:"event_id"`
+	CorrelationID   string    `json:"correlation_id"`
+	CausationID     string    `json:"causation_id"`
+	EventType       EventType `json:"event_type"`
+	Timestamp       time.Time `json:"timestamp"`
+	UserID          string    `json:"user_id"`
+	SourceEventType EventType `json:"source_event_type"`
+	// SourceVersion is the source event's stream version, which orders
+	// results for the same user however late they arrive
+	SourceVersion int `json:"source_version,omitempty"`
+
+	// Error says why handling failed; set on failure events only
+	Error string `json:"error,omitempty"`
+}
+
+// NewResultEvent builds a result event about source with a fresh ID,
+// continuing ctx's correlation (or source's, outside a delivery).
+func NewResultEvent(ctx context.Context, eventType EventType, source UserEvent) ResultEvent {
+	correlationID := correlation.ID(ctx)
+	if correlationID == "" {
+		correlationID = source.CorrelationID
+	}
+	if correlationID == "" {
+		correlationID = correlation.New()
+	}
+	return ResultEvent{
+		EventID:         uuid.New().String(),
+		CorrelationID:   correlationID,
+		CausationID:     source.EventID,
+		EventType:       eventType,
+		Timestamp:       time.Now(),
+		UserID:          source.Data.ID,
+		SourceEventType: source.EventType,
+		SourceVersion:   source.StreamVersion,
+	}
+}
+
+// Actor identifies the authenticated principal behind an event.
+type Actor struct {
+	Type string `json:"type"` // api_key, jwt, or saga for a step a saga took
+	ID   string `json:"id"`
 }
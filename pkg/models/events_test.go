@@ -1,9 +1,12 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
+
+	"awesomeProject/pkg/correlation"
 )
 
 func TestEventTypeConstants(t *testing.T) {
@@ -68,3 +71,42 @@ func TestUserEventJSON(t *testing.T) {
 		t.Errorf("Data.Email: expected %q, got %q", event.Data.Email, decoded.Data.Email)
 	}
 }
+
+func TestNewUserEvent_TakesIDsFromContext(t *testing.T) {
+	ctx := correlation.WithCausation(correlation.WithID(context.Background(), "corr-1"), "evt-0")
+	event := NewUserEvent(ctx, EventUserUpdated, User{ID: "user-1"})
+
+	if event.EventID == "" || event.Timestamp.IsZero() {
+		t.Errorf("expected an ID and timestamp, got %+v", event)
+	}
+	if event.CorrelationID != "corr-1" || event.CausationID != "evt-0" {
+		t.Errorf("expected corr-1 caused by evt-0, got %q caused by %q", event.CorrelationID, event.CausationID)
+	}
+
+	// Outside a request or delivery a new correlation starts
+	event = NewUserEvent(context.Background(), EventUserCreated, User{ID: "user-1"})
+	if event.CorrelationID == "" || event.CausationID != "" {
+		t.Errorf("expected a new correlation and no cause, got %q caused by %q", event.CorrelationID, event.CausationID)
+	}
+}
+
+func TestNewResultEvent_CausedBySource(t *testing.T) {
+	source := UserEvent{EventID: "evt-0", CorrelationID: "corr-0", EventType: EventUserCreated, Data: User{ID: "user-1"}, StreamVersion: 3}
+
+	event := NewResultEvent(correlation.WithID(context.Background(), "corr-1"), EventCRMContactSynced, source)
+	if event.EventID == "" || event.EventID == source.EventID || event.Timestamp.IsZero() {
+		t.Errorf("expected a fresh ID and timestamp, got %+v", event)
+	}
+	if event.CorrelationID != "corr-1" || event.CausationID != "evt-0" {
+		t.Errorf("expected corr-1 caused by evt-0, got %q caused by %q", event.CorrelationID, event.CausationID)
+	}
+	if event.UserID != "user-1" || event.SourceEventType != EventUserCreated || event.SourceVersion != 3 {
+		t.Errorf("unexpected result event: %+v", event)
+	}
+
+	// Outside a delivery the source's correlation continues
+	event = NewResultEvent(context.Background(), EventCRMContactSynced, source)
+	if event.CorrelationID != "corr-0" {
+		t.Errorf("expected corr-0, got %q", event.CorrelationID)
+	}
+}
@@ -0,0 +1,28 @@
+package models
+
+// UserStatus is a user's lifecycle state.
+type UserStatus string
+
+const (
+	UserPending   UserStatus = "pending"
+	UserActive    UserStatus = "active"
+	UserSuspended UserStatus = "suspended"
+	UserDeleted   UserStatus = "deleted"
+)
+
+// userTransitions lists the states each status may move to. Deleted is final.
+var userTransitions = map[UserStatus][]UserStatus{
+	UserPending:   {UserActive, UserDeleted},
+	UserActive:    {UserSuspended, UserDeleted},
+	UserSuspended: {UserActive, UserDeleted},
+}
+
+// CanTransition reports whether a user may move from one status to another.
+func (s UserStatus) CanTransition(to UserStatus) bool {
+	for _, allowed := range userTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestUserStatus_CanTransition(t *testing.T) {
+	tests := []struct {
+		from, to UserStatus
+		want     bool
+	}{
+		{UserPending, UserActive, true},
+		{UserPending, UserSuspended, false},
+		{UserActive, UserSuspended, true},
+		{UserActive, UserActive, false},
+		{UserSuspended, UserActive, true},
+		{UserSuspended, UserDeleted, true},
+		{UserDeleted, UserActive, false},
+		{UserStatus("bogus"), UserActive, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransition(tt.to); got != tt.want {
+			t.Errorf("%s -> %s: expected %v, got %v", tt.from, tt.to, tt.want, got)
+		}
+	}
+}
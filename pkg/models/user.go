@@ -1,24 +1,71 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // User represents a user in the system.
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email" binding:"required,email"`
-	Name      string    `json:"name" db:"name" binding:"required"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID               string           `json:"id" db:"id"`
+	Email            string           `json:"email" db:"email" binding:"required,email"`
+	Name             string           `json:"name" db:"name" binding:"required"`
+	Status           UserStatus       `json:"status" db:"status"`
+	Phone            string           `json:"phone,omitempty" db:"phone"`
+	Locale           string           `json:"locale,omitempty" db:"locale"`
+	Timezone         string           `json:"timezone,omitempty" db:"timezone"`
+	MarketingConsent MarketingConsent `json:"marketing_consent"`
+	Attributes       Attributes       `json:"attributes,omitempty" db:"attributes"`
+	CreatedAt        time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at" db:"updated_at"`
+}
+
+// MarketingConsent records which marketing channels a user has opted into.
+type MarketingConsent struct {
+	Email bool `json:"email" db:"marketing_email"`
+	SMS   bool `json:"sms" db:"marketing_sms"`
 }
 
 // CreateUserRequest is the request body for creating a user.
 type CreateUserRequest struct {
-	Email string `json:"email" binding:"required,email" example:"john@example.com"`
-	Name  string `json:"name" binding:"required" example:"John Doe"`
+	Email            string           `json:"email" binding:"required,email" example:"john@example.com"`
+	Name             string           `json:"name" binding:"required" example:"John Doe"`
+	Status           UserStatus       `json:"status,omitempty" binding:"omitempty,oneof=pending active" example:"active"`
+	Phone            string           `json:"phone,omitempty" binding:"omitempty,e164" example:"+14155550123"`
+	Locale           string           `json:"locale,omitempty" binding:"omitempty,bcp47_language_tag" example:"en-US"`
+	Timezone         string           `json:"timezone,omitempty" binding:"omitempty,timezone" example:"America/New_York"`
+	MarketingConsent MarketingConsent `json:"marketing_consent"`
+	Attributes       Attributes       `json:"attributes,omitempty"`
 }
 
-// UpdateUserRequest is the request body for updating a user.
+// UpdateUserRequest is the request body for updating a user. Omitted fields
+// are left unchanged; an empty phone, locale or timezone clears it.
+// Attributes are merged key by key, and a null value removes the key.
 type UpdateUserRequest struct {
-	Email string `json:"email,omitempty" binding:"omitempty,email" example:"john@example.com"`
-	Name  string `json:"name,omitempty" binding:"omitempty" example:"John Doe"`
+	Email            string            `json:"email,omitempty" binding:"omitempty,email" example:"john@example.com"`
+	Name             string            `json:"name,omitempty" binding:"omitempty" example:"John Doe"`
+	Phone            *string           `json:"phone,omitempty" binding:"omitempty,len=0|e164" example:"+14155550123"`
+	Locale           *string           `json:"locale,omitempty" binding:"omitempty,len=0|bcp47_language_tag" example:"en-US"`
+	Timezone         *string           `json:"timezone,omitempty" binding:"omitempty,len=0|timezone" example:"America/New_York"`
+	MarketingConsent *MarketingConsent `json:"marketing_consent,omitempty"`
+	Attributes       Attributes        `json:"attributes,omitempty"`
+}
+
+// NormalizeEmail returns the canonical form of an email address: trimmed and
+// lowercased. With foldPlus, a "+tag" suffix on the local part is dropped so
+// that jane+news@example.com and jane@example.com are the same identity.
+func NormalizeEmail(email string, foldPlus bool) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !foldPlus {
+		return email
+	}
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at:]
+	if plus := strings.IndexByte(local, '+'); plus > 0 {
+		local = local[:plus]
+	}
+	return local + domain
 }
@@ -64,3 +64,22 @@ func TestUpdateUserRequestJSON(t *testing.T) {
 		t.Errorf("Name: expected empty, got %q", req.Name)
 	}
 }
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in       string
+		foldPlus bool
+		want     string
+	}{
+		{"  Jane@Example.COM ", false, "jane@example.com"},
+		{"Jane+News@example.com", false, "jane+news@example.com"},
+		{"Jane+News@example.com", true, "jane@example.com"},
+		{"+tag@example.com", true, "+tag@example.com"},
+		{"not-an-email", true, "not-an-email"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.in, tt.foldPlus); got != tt.want {
+			t.Errorf("NormalizeEmail(%q, %v) = %q, want %q", tt.in, tt.foldPlus, got, tt.want)
+		}
+	}
+}
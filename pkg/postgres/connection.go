@@ -4,21 +4,28 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
-// Connect establishes a connection to PostgreSQL with retries.
+// Connect establishes a connection to PostgreSQL with retries. Statements
+// run through the returned pool are traced as children of the span in their
+// context.
 func Connect(databaseURL string) (*sql.DB, error) {
 	var db *sql.DB
 	var err error
 
 	for i := 0; i < 30; i++ {
-		db, err = sql.Open("postgres", databaseURL)
+		db, err = otelsql.Open("postgres", databaseURL,
+			otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+			otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+		)
 		if err != nil {
-			log.Printf("Failed to open database: %v, retrying in 2s...", err)
+			slog.Warn("failed to open database, retrying in 2s", "error", err, "attempt", i+1)
 			time.Sleep(2 * time.Second)
 			continue
 		}
@@ -27,11 +34,11 @@ func Connect(databaseURL string) (*sql.DB, error) {
 		err = db.PingContext(ctx)
 		cancel()
 		if err == nil {
-			log.Println("Connected to PostgreSQL")
+			slog.Info("connected to PostgreSQL")
 			return db, nil
 		}
 
-		log.Printf("Failed to ping database: %v, retrying in 2s...", err)
+		slog.Warn("failed to ping database, retrying in 2s", "error", err, "attempt", i+1)
 		time.Sleep(2 * time.Second)
 	}
 
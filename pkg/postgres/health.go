@@ -0,0 +1,20 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"awesomeProject/pkg/health"
+)
+
+// Check returns a health check that pings db and reports its pool usage.
+func Check(db *sql.DB) health.Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		stats := db.Stats()
+		details := map[string]interface{}{
+			"open_connections": stats.OpenConnections,
+			"in_use":           stats.InUse,
+		}
+		return details, db.PingContext(ctx)
+	}
+}
@@ -2,7 +2,7 @@ package postgres
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 )
 
 // RunMigrations executes database migrations.
@@ -13,7 +13,7 @@ func RunMigrations(db *sql.DB, service string) error {
 			return err
 		}
 	}
-	log.Printf("Migrations completed for service: %s", service)
+	slog.Info("migrations completed", "migrations", len(migrations))
 	return nil
 }
 
@@ -34,7 +34,174 @@ func getServiceMigrations(service string) []string {
 
 	switch service {
 	case "api":
-		return common
+		return append(common,
+			// Emails are stored normalised. Normalise rows written before
+			// that, refusing to start (naming the user IDs) if two of them
+			// would end up with the same email: the unique index below could
+			// not be built, and merging users is for an operator to do
+			`DO $$
+			DECLARE
+				collisions TEXT;
+			BEGIN
+				SELECT string_agg(ids, '; ') INTO collisions FROM (
+					SELECT string_agg(id, ', ' ORDER BY created_at) AS ids FROM users
+					GROUP BY lower(trim(email)) HAVING COUNT(*) > 1
+				) dupes;
+				IF collisions IS NOT NULL THEN
+					RAISE EXCEPTION 'users whose emails differ only in case or spacing must be merged before the api can start: %', collisions;
+				END IF;
+				UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+			END $$`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email))`,
+			`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS phone VARCHAR(32) NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS locale VARCHAR(35) NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS marketing_email BOOLEAN NOT NULL DEFAULT FALSE,
+				ADD COLUMN IF NOT EXISTS marketing_sms BOOLEAN NOT NULL DEFAULT FALSE,
+				ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}'`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP`,
+			`CREATE TABLE IF NOT EXISTS events (
+				sequence BIGSERIAL PRIMARY KEY,
+				event_id VARCHAR(36) NOT NULL UNIQUE,
+				stream_id VARCHAR(36) NOT NULL,
+				stream_version INTEGER NOT NULL,
+				event_type VARCHAR(50) NOT NULL,
+				correlation_id VARCHAR(255),
+				payload JSONB NOT NULL,
+				occurred_at TIMESTAMP NOT NULL,
+				recorded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				UNIQUE (stream_id, stream_version)
+			)`,
+			`CREATE TABLE IF NOT EXISTS import_jobs (
+				id VARCHAR(36) PRIMARY KEY,
+				status VARCHAR(20) NOT NULL,
+				total INTEGER NOT NULL,
+				result JSONB,
+				error TEXT,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				finished_at TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS request_idempotency (
+				key VARCHAR(255) PRIMARY KEY,
+				request_hash CHAR(64) NOT NULL,
+				status_code INTEGER,
+				content_type VARCHAR(255),
+				response_body BYTEA,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				completed_at TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS api_keys (
+				id VARCHAR(36) PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				key_hash CHAR(64) NOT NULL UNIQUE,
+				scopes TEXT[] NOT NULL DEFAULT '{}',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				revoked_at TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id BIGSERIAL PRIMARY KEY,
+				occurred_at TIMESTAMP NOT NULL,
+				actor_type VARCHAR(20),
+				actor_id VARCHAR(255),
+				action VARCHAR(50) NOT NULL,
+				user_id VARCHAR(36) NOT NULL,
+				before JSONB,
+				after JSONB,
+				method VARCHAR(10) NOT NULL,
+				path TEXT NOT NULL,
+				client_ip VARCHAR(45) NOT NULL,
+				user_agent TEXT NOT NULL,
+				correlation_id VARCHAR(255) NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_user_id_idx ON audit_log (user_id, id)`,
+			`CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+				key VARCHAR(255) PRIMARY KEY,
+				tokens DOUBLE PRECISION NOT NULL,
+				allowed BOOLEAN NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+			// Fed by the consumers' result events; see pkg/syncstatus
+			`CREATE TABLE IF NOT EXISTS sync_status (
+				user_id VARCHAR(36) NOT NULL,
+				downstream VARCHAR(32) NOT NULL,
+				last_event_id VARCHAR(36),
+				last_event_type VARCHAR(50),
+				last_applied_at TIMESTAMP,
+				failed_event_id VARCHAR(36),
+				failed_event_type VARCHAR(50),
+				failure_error TEXT,
+				failed_at TIMESTAMP,
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, downstream)
+			)`,
+			// Saga progress; see pkg/saga
+			`CREATE TABLE IF NOT EXISTS sagas (
+				id VARCHAR(36) PRIMARY KEY,
+				name VARCHAR(50) NOT NULL,
+				user_id VARCHAR(36) NOT NULL,
+				trigger_event_id VARCHAR(36) NOT NULL,
+				correlation_id VARCHAR(255) NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				current_step INTEGER NOT NULL,
+				steps JSONB NOT NULL,
+				step_deadline TIMESTAMP,
+				error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				UNIQUE (name, user_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS sagas_trigger_event_id_idx ON sagas (trigger_event_id)`,
+			`CREATE INDEX IF NOT EXISTS sagas_step_deadline_idx ON sagas (step_deadline) WHERE status = 'running'`,
+			// Saga steps and compensations that succeeded; written outside the
+			// saga's transaction so a redelivery doesn't repeat them
+			`CREATE TABLE IF NOT EXISTS saga_step_runs (
+				saga_id VARCHAR(36) NOT NULL,
+				step VARCHAR(50) NOT NULL,
+				action VARCHAR(20) NOT NULL,
+				ran_at TIMESTAMP NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (saga_id, step, action)
+			)`,
+			// Delayed messages; see rabbitmq.Scheduler
+			`CREATE TABLE IF NOT EXISTS scheduled_messages (
+				id VARCHAR(36) PRIMARY KEY,
+				key VARCHAR(255) NOT NULL DEFAULT '',
+				routing_key VARCHAR(255) NOT NULL,
+				body BYTEA NOT NULL,
+				correlation_id VARCHAR(255) NOT NULL,
+				message_id VARCHAR(36) NOT NULL,
+				publish_at TIMESTAMP NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE INDEX IF NOT EXISTS scheduled_messages_publish_at_idx ON scheduled_messages (publish_at)`,
+			`CREATE INDEX IF NOT EXISTS scheduled_messages_key_idx ON scheduled_messages (key) WHERE key <> ''`,
+			// Running import jobs touch heartbeat_at so a restart can tell
+			// which ones lost their process
+			`ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP`,
+			// Idempotency keys are scoped by principal ('' without
+			// authentication) so clients choosing the same key stay apart
+			`ALTER TABLE request_idempotency ADD COLUMN IF NOT EXISTS principal VARCHAR(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE request_idempotency DROP CONSTRAINT IF EXISTS request_idempotency_pkey`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS request_idempotency_principal_key_idx ON request_idempotency (principal, key)`,
+			// Results are ordered by their source event's stream version;
+			// rows from before it was carried count as version 0
+			`ALTER TABLE sync_status
+				ADD COLUMN IF NOT EXISTS last_event_version INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS failed_event_version INTEGER NOT NULL DEFAULT 0`,
+			// Saga step events handled before their saga started; see
+			// saga.Engine
+			`CREATE TABLE IF NOT EXISTS saga_early_events (
+				event_id VARCHAR(36) PRIMARY KEY,
+				trigger_event_id VARCHAR(36) NOT NULL,
+				event_type VARCHAR(50) NOT NULL,
+				error TEXT NOT NULL DEFAULT '',
+				received_at TIMESTAMP NOT NULL DEFAULT NOW()
+			)`,
+			`CREATE INDEX IF NOT EXISTS saga_early_events_trigger_idx ON saga_early_events (trigger_event_id)`,
+		)
 	case "crm":
 		return []string{
 			`CREATE TABLE IF NOT EXISTS idempotency_keys (
@@ -51,6 +218,13 @@ func getServiceMigrations(service string) []string {
 				user_name VARCHAR(255),
 				synced_at TIMESTAMP NOT NULL DEFAULT NOW()
 			)`,
+			`ALTER TABLE crm_sync_log ADD COLUMN IF NOT EXISTS contact JSONB`,
+			// Caller-supplied correlation IDs may be up to 128 characters
+			`ALTER TABLE crm_sync_log ALTER COLUMN correlation_id TYPE VARCHAR(255)`,
+			// Stream version of the synced event, so a requeued older event
+			// doesn't overwrite a newer one; 0 for rows from before
+			`ALTER TABLE crm_sync_log ADD COLUMN IF NOT EXISTS stream_version INTEGER NOT NULL DEFAULT 0`,
+			`CREATE INDEX IF NOT EXISTS crm_sync_log_user_version_idx ON crm_sync_log (user_id, stream_version)`,
 		}
 	case "analytics":
 		return []string{
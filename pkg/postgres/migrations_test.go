@@ -1,20 +1,36 @@
 package postgres
 
 import (
+	"strings"
 	"testing"
 )
 
 func TestGetServiceMigrations_API(t *testing.T) {
 	migrations := getServiceMigrations("api")
-	if len(migrations) != 2 {
-		t.Fatalf("expected 2 migrations for api, got %d", len(migrations))
+	if len(migrations) != 29 {
+		t.Fatalf("expected 29 migrations for api, got %d", len(migrations))
+	}
+}
+
+func TestGetServiceMigrations_API_NormalisesEmailsBeforeIndexing(t *testing.T) {
+	normalise, index := -1, -1
+	for i, m := range getServiceMigrations("api") {
+		if strings.Contains(m, "UPDATE users SET email = lower(trim(email))") {
+			normalise = i
+		}
+		if strings.Contains(m, "users_email_lower_key") {
+			index = i
+		}
+	}
+	if normalise < 0 || index < 0 || normalise > index {
+		t.Errorf("expected emails to be normalised before the lower(email) index is built, got migrations %d and %d", normalise, index)
 	}
 }
 
 func TestGetServiceMigrations_CRM(t *testing.T) {
 	migrations := getServiceMigrations("crm")
-	if len(migrations) != 2 {
-		t.Fatalf("expected 2 migrations for crm, got %d", len(migrations))
+	if len(migrations) != 6 {
+		t.Fatalf("expected 6 migrations for crm, got %d", len(migrations))
 	}
 }
 
@@ -2,7 +2,7 @@ package rabbitmq
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -22,10 +22,10 @@ func Connect(url string) (*Connection, error) {
 	for i := 0; i < 30; i++ {
 		conn, err = amqp.Dial(url)
 		if err == nil {
-			log.Println("Connected to RabbitMQ")
+			slog.Info("connected to RabbitMQ")
 			return &Connection{URL: url, Conn: conn}, nil
 		}
-		log.Printf("Failed to connect to RabbitMQ: %v, retrying in 2s...", err)
+		slog.Warn("failed to connect to RabbitMQ, retrying in 2s", "error", err, "attempt", i+1)
 		time.Sleep(2 * time.Second)
 	}
 
@@ -1,7 +1,15 @@
 package rabbitmq
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"awesomeProject/pkg/correlation"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/tracing"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -12,82 +20,391 @@ type ConsumerConfig struct {
 	DLQName      string
 	RoutingKeys  []string
 	ConsumerName string
+
+	// QueueType is classic (default) or quorum. DeliveryLimit (quorum
+	// only) is how many times a failed message is requeued, going by its
+	// x-delivery-count, before it is dead-lettered; the broker enforces it
+	// too. Zero dead-letters on the first failure. SingleActiveConsumer has
+	// the broker deliver to one consumer of the queue at a time.
+	QueueType            string
+	DeliveryLimit        int
+	SingleActiveConsumer bool
+
+	// Topology, if set, is declared in place of the queue, DLQ and bindings
+	// derived from the fields above; Topology.Consumer sets it
+	Topology *Topology
+
+	// Batch mode (SetupBatchConsumer only)
+	BatchSize    int
+	BatchTimeout time.Duration
 }
 
-// MessageHandler is a function that processes a delivered message.
+// Defaults applied by SetupBatchConsumer when the config leaves them unset.
+const (
+	DefaultBatchSize    = 50
+	DefaultBatchTimeout = 500 * time.Millisecond
+)
+
+// MessageHandler is a function that processes a delivered message. ctx
+// carries a logger already annotated with the delivery's fields.
 // Return nil to ack, return error to nack (triggers retry/DLQ).
-type MessageHandler func(delivery amqp.Delivery) error
+type MessageHandler func(ctx context.Context, delivery amqp.Delivery) error
 
-// SetupConsumer declares queues (main + DLQ), binds them, and starts consuming.
-func SetupConsumer(conn *Connection, cfg ConsumerConfig, handler MessageHandler) error {
-	ch, err := conn.Channel()
-	if err != nil {
-		return err
+// BatchHandler processes a batch of deliveries as a unit. ctx carries a
+// logger annotated with the consumer and batch size.
+// Return nil to ack the whole batch, return error to nack all of it to the DLQ.
+type BatchHandler func(ctx context.Context, deliveries []amqp.Delivery) error
+
+// WithDelivery returns ctx carrying msg's correlation ID (a new one if msg
+// has none, or an invalid one) and its message ID as the causation ID, so
+// events emitted while handling msg continue its correlation. SetupConsumer
+// does this for every delivery; batch handlers call it per message.
+func WithDelivery(ctx context.Context, msg amqp.Delivery) context.Context {
+	correlationID := msg.CorrelationId
+	if !correlation.Valid(correlationID) {
+		correlationID = correlation.New()
 	}
+	ctx = correlation.WithID(ctx, correlationID)
+	if msg.MessageId != "" {
+		ctx = correlation.WithCausation(ctx, msg.MessageId)
+	}
+	return ctx
+}
 
-	// Declare the topic exchange (idempotent)
-	err = ch.ExchangeDeclare(
-		ExchangeName,
-		"topic",
-		true,  // durable
-		false, // auto-deleted
-		false, // internal
-		false, // no-wait
-		nil,
+// deliveryLogger annotates ctx's logger with a delivery's routing fields
+// and ctx's correlation ID.
+func deliveryLogger(ctx context.Context, msg amqp.Delivery) (context.Context, *slog.Logger) {
+	return logging.With(ctx,
+		"routing_key", msg.RoutingKey,
+		"delivery_tag", msg.DeliveryTag,
+		"message_id", msg.MessageId,
+		"redelivered", msg.Redelivered,
+		logging.KeyCorrelationID, correlation.ID(ctx),
 	)
+}
+
+// DeliveryCount returns how many times msg was delivered before and
+// returned, from the x-delivery-count header quorum queues set; zero for a
+// first delivery or a classic queue.
+func DeliveryCount(msg amqp.Delivery) int64 {
+	switch n := msg.Headers["x-delivery-count"].(type) {
+	case int64:
+		return n
+	case int32:
+		return int64(n)
+	case int:
+		return int64(n)
+	}
+	return 0
+}
+
+// retries reports whether msg is requeued rather than dead-lettered if its
+// handler fails.
+func (cfg ConsumerConfig) retries(msg amqp.Delivery) bool {
+	return cfg.DeliveryLimit > 0 && DeliveryCount(msg) < int64(cfg.DeliveryLimit)
+}
+
+type willRetryKey struct{}
+
+// WillRetry reports whether the delivery being handled is requeued for
+// another attempt if the handler fails, rather than dead-lettered, so
+// handlers can report a failure only once it is final. It is false in
+// batch mode and outside a consumer.
+func WillRetry(ctx context.Context) bool {
+	retry, _ := ctx.Value(willRetryKey{}).(bool)
+	return retry
+}
+
+type rejectionsKey struct{}
+
+// rejections collects the deliveries a handler rejected.
+type rejections struct {
+	mu   sync.Mutex
+	msgs []amqp.Delivery
+}
+
+func withRejections(ctx context.Context) (context.Context, *rejections) {
+	r := &rejections{}
+	return context.WithValue(ctx, rejectionsKey{}, r), r
+}
+
+func (r *rejections) deliveries() []amqp.Delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.msgs
+}
+
+// Reject tells the consumer framework to nack msg on its own, like a failed
+// message (requeued under the delivery limit, otherwise dead-lettered),
+// while the rest of the batch is settled by the handler's result. Batch
+// handlers use it for a message they cannot process, such as one that does
+// not parse, so it doesn't fail the whole batch. It does nothing outside a
+// consumer.
+func Reject(ctx context.Context, msg amqp.Delivery) {
+	r, ok := ctx.Value(rejectionsKey{}).(*rejections)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.msgs {
+		if m.DeliveryTag == msg.DeliveryTag {
+			return
+		}
+	}
+	r.msgs = append(r.msgs, msg)
+}
+
+// nackFailed settles deliveries whose handler failed, requeueing those
+// still under cfg.DeliveryLimit and dead-lettering the rest, and returns
+// how many it requeued. Without a delivery limit one nack on the last
+// delivery dead-letters them all.
+func nackFailed(cfg ConsumerConfig, msgs []amqp.Delivery) int {
+	if cfg.DeliveryLimit <= 0 {
+		_ = msgs[len(msgs)-1].Nack(len(msgs) > 1, false) // don't requeue — goes to DLQ
+		deadLetteredTotal.WithLabelValues(cfg.ConsumerName, cfg.DLQName).Add(float64(len(msgs)))
+		return 0
+	}
+
+	requeued := 0
+	for _, msg := range msgs {
+		retry := cfg.retries(msg)
+		_ = msg.Nack(false, retry)
+		if retry {
+			requeued++
+		}
+	}
+	requeuedTotal.WithLabelValues(cfg.ConsumerName).Add(float64(requeued))
+	deadLetteredTotal.WithLabelValues(cfg.ConsumerName, cfg.DLQName).Add(float64(len(msgs) - requeued))
+	return requeued
+}
+
+// errRejected fails a single message its handler rejected.
+var errRejected = errors.New("rejected by handler")
+
+// Consumer is a running consumer, returned by SetupConsumer and
+// SetupBatchConsumer for health checks.
+type Consumer struct {
+	conn *Connection
+	ch   *amqp.Channel
+	cfg  ConsumerConfig
+	done chan struct{} // closed when the delivery loop exits
+}
+
+// SetupConsumer declares queues (main + DLQ), binds them, and starts consuming.
+func SetupConsumer(conn *Connection, cfg ConsumerConfig, handler MessageHandler) (*Consumer, error) {
+	ch, msgs, err := declareAndConsume(conn, cfg, 1)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Declare DLQ
-	_, err = ch.QueueDeclare(
-		cfg.DLQName,
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,
-	)
+	c := &Consumer{conn: conn, ch: ch, cfg: cfg, done: make(chan struct{})}
+	base, logger := consumerLogger(cfg)
+	go func() {
+		defer close(c.done)
+		for msg := range msgs {
+			ctx, span := startDeliverySpan(WithDelivery(base, msg), cfg, msg)
+			ctx, logger := deliveryLogger(ctx, msg)
+			logger.Debug("received message")
+
+			ctx = context.WithValue(ctx, willRetryKey{}, cfg.retries(msg))
+			rejected, err := instrument(ctx, cfg, 1, func(ctx context.Context) error { return handler(ctx, msg) })
+			if err == nil && len(rejected) > 0 {
+				err = errRejected
+			}
+			tracing.RecordError(span, err)
+			span.End()
+			if err != nil {
+				if nackFailed(cfg, []amqp.Delivery{msg}) > 0 {
+					logger.Warn("error processing message, requeueing for retry", logging.KeyError, err,
+						"delivery_count", DeliveryCount(msg), "delivery_limit", cfg.DeliveryLimit)
+				} else {
+					logger.Error("error processing message, nacking to DLQ", logging.KeyError, err)
+				}
+			} else {
+				_ = msg.Ack(false)
+			}
+		}
+	}()
+
+	logger.Info("consumer started", "queue", cfg.QueueName)
+	return c, nil
+}
+
+// SetupBatchConsumer is like SetupConsumer but hands the handler up to
+// cfg.BatchSize deliveries at a time, flushing early after cfg.BatchTimeout.
+// The whole batch is acked (or nacked to the DLQ) with multiple=true; with a
+// DeliveryLimit a failed batch is nacked message by message instead.
+// Deliveries the handler passes to Reject are nacked on their own.
+func SetupBatchConsumer(conn *Connection, cfg ConsumerConfig, handler BatchHandler) (*Consumer, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = DefaultBatchTimeout
+	}
+
+	// Prefetch must cover a full batch or the broker would stall us
+	ch, msgs, err := declareAndConsume(conn, cfg, cfg.BatchSize)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Declare main queue with DLQ settings
-	args := amqp.Table{
-		"x-dead-letter-exchange":    "",          // default exchange
-		"x-dead-letter-routing-key": cfg.DLQName, // route to DLQ
+	c := &Consumer{conn: conn, ch: ch, cfg: cfg, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		runBatchLoop(msgs, cfg, handler)
+	}()
+
+	_, logger := consumerLogger(cfg)
+	logger.Info("batch consumer started", "queue", cfg.QueueName,
+		"batch_size", cfg.BatchSize, "batch_timeout", cfg.BatchTimeout.String())
+	return c, nil
+}
+
+// consumerLogger returns a background context whose logger names the consumer.
+func consumerLogger(cfg ConsumerConfig) (context.Context, *slog.Logger) {
+	return logging.With(context.Background(), "consumer", cfg.ConsumerName)
+}
+
+// settleBatch settles a batch the handler succeeded on: each rejected
+// delivery is nacked on its own, then the rest are acked with one
+// multiple=true ack on the last of them.
+func settleBatch(logger *slog.Logger, cfg ConsumerConfig, batch, rejected []amqp.Delivery) {
+	isRejected := make(map[uint64]bool, len(rejected))
+	requeued := 0
+	for _, msg := range rejected {
+		isRejected[msg.DeliveryTag] = true
+		requeued += nackFailed(cfg, []amqp.Delivery{msg})
+	}
+	if len(rejected) > 0 {
+		logFailed(logger, "messages rejected in batch", nil, len(rejected), requeued)
 	}
 
-	_, err = ch.QueueDeclare(
-		cfg.QueueName,
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		args,
-	)
+	for i := len(batch) - 1; i >= 0; i-- {
+		if !isRejected[batch[i].DeliveryTag] {
+			_ = batch[i].Ack(true) // the rejected ones are settled already
+			return
+		}
+	}
+}
+
+// logFailed logs n failed deliveries, of which requeued will be retried.
+func logFailed(logger *slog.Logger, msg string, err error, n, requeued int) {
+	attrs := []interface{}{"requeued", requeued, "dead_lettered", n - requeued}
 	if err != nil {
-		return err
+		attrs = append(attrs, logging.KeyError, err)
+	}
+	if requeued > 0 {
+		logger.Warn(msg+", requeueing for retry", attrs...)
+	} else {
+		logger.Error(msg+", nacking to DLQ", attrs...)
 	}
+}
+
+// runBatchLoop accumulates deliveries into batches and settles each batch
+// with a single multiple=true ack/nack on its last delivery tag, apart from
+// deliveries the handler rejected.
+func runBatchLoop(msgs <-chan amqp.Delivery, cfg ConsumerConfig, handler BatchHandler) {
+	batch := make([]amqp.Delivery, 0, cfg.BatchSize)
+	timer := time.NewTimer(cfg.BatchTimeout)
+	timer.Stop()
+	base, _ := consumerLogger(cfg)
 
-	// Bind queue to exchange with routing keys
-	for _, key := range cfg.RoutingKeys {
-		err = ch.QueueBind(
-			cfg.QueueName,
-			key,
-			ExchangeName,
-			false,
-			nil,
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		last := batch[len(batch)-1]
+		ctx, span := startBatchSpan(base, cfg, batch)
+		defer span.End()
+		ctx, logger := logging.With(ctx,
+			"batch_size", len(batch),
+			"first_delivery_tag", batch[0].DeliveryTag,
+			"last_delivery_tag", last.DeliveryTag,
 		)
+		rejected, err := instrument(ctx, cfg, len(batch), func(ctx context.Context) error { return handler(ctx, batch) })
+		tracing.RecordError(span, err)
 		if err != nil {
-			return err
+			logFailed(logger, "error processing batch", err, len(batch), nackFailed(cfg, batch))
+		} else {
+			settleBatch(logger, cfg, batch, rejected)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				timer.Stop()
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(cfg.BatchTimeout)
+			}
+			batch = append(batch, msg)
+			if len(batch) >= cfg.BatchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
 		}
 	}
+}
+
+// topology returns the topology the consumer declares: cfg.Topology, or
+// the topic exchange, the DLQ and the main queue bound with the routing keys.
+func (cfg ConsumerConfig) topology() *Topology {
+	if cfg.Topology != nil {
+		return cfg.Topology
+	}
+	queueType := cfg.QueueType
+	if queueType == "" {
+		queueType = QueueClassic
+	}
+	t := &Topology{
+		Exchanges: []ExchangeSpec{{Name: ExchangeName, Type: "topic"}},
+		Queues: []QueueSpec{{
+			Name:                 cfg.QueueName,
+			Type:                 queueType,
+			DeadLetterQueue:      cfg.DLQName,
+			DeliveryLimit:        cfg.DeliveryLimit,
+			SingleActiveConsumer: cfg.SingleActiveConsumer,
+		}},
+	}
+	if len(cfg.RoutingKeys) > 0 {
+		t.Queues[0].Bindings = []BindingSpec{{Exchange: ExchangeName, RoutingKeys: cfg.RoutingKeys}}
+	}
+	if cfg.DLQName != "" {
+		t.Queues = append(t.Queues, QueueSpec{Name: cfg.DLQName, Type: QueueClassic})
+	}
+	return t
+}
+
+// declareAndConsume declares the consumer's topology, sets the prefetch
+// count and starts consuming on a new channel, which it returns alongside
+// the deliveries.
+func declareAndConsume(conn *Connection, cfg ConsumerConfig, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	t := cfg.topology()
+	if err := t.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.Declare(ch); err != nil {
+		return nil, nil, err
+	}
 
 	// Set prefetch count
-	err = ch.Qos(1, 0, false)
+	err = ch.Qos(prefetch, 0, false)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Start consuming
@@ -101,24 +418,7 @@ func SetupConsumer(conn *Connection, cfg ConsumerConfig, handler MessageHandler)
 		nil,
 	)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	go func() {
-		for msg := range msgs {
-			log.Printf("[%s] Received message: routing_key=%s correlation_id=%s",
-				cfg.ConsumerName, msg.RoutingKey, msg.CorrelationId)
-
-			if err := handler(msg); err != nil {
-				log.Printf("[%s] Error processing message: %v — nacking (will go to DLQ)",
-					cfg.ConsumerName, err)
-				_ = msg.Nack(false, false) // don't requeue — goes to DLQ
-			} else {
-				_ = msg.Ack(false)
-			}
-		}
-	}()
-
-	log.Printf("[%s] Consumer started, listening on queue: %s", cfg.ConsumerName, cfg.QueueName)
-	return nil
+	return ch, msgs, nil
 }
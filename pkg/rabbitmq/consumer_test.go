@@ -0,0 +1,256 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/correlation"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAcker records ack/nack calls made through amqp.Delivery.
+type fakeAcker struct {
+	mu    sync.Mutex
+	acks  []ackCall
+	nacks []ackCall
+}
+
+type ackCall struct {
+	Tag      uint64
+	Multiple bool
+	Requeue  bool
+}
+
+func (f *fakeAcker) Ack(tag uint64, multiple bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acks = append(f.acks, ackCall{Tag: tag, Multiple: multiple})
+	return nil
+}
+
+func (f *fakeAcker) Nack(tag uint64, multiple, requeue bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nacks = append(f.nacks, ackCall{tag, multiple, requeue})
+	return nil
+}
+
+func (f *fakeAcker) Reject(tag uint64, requeue bool) error {
+	return f.Nack(tag, false, requeue)
+}
+
+func TestRunBatchLoop_FlushesOnSize(t *testing.T) {
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 5)
+	for i := 1; i <= 5; i++ {
+		msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i)}
+	}
+	close(msgs)
+
+	var sizes []int
+	cfg := ConsumerConfig{ConsumerName: "test", BatchSize: 2, BatchTimeout: time.Hour}
+	runBatchLoop(msgs, cfg, func(_ context.Context, batch []amqp.Delivery) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	})
+
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("expected batches [2 2 1], got %v", sizes)
+	}
+
+	expected := []ackCall{{Tag: 2, Multiple: true}, {Tag: 4, Multiple: true}, {Tag: 5, Multiple: true}}
+	if len(acker.acks) != len(expected) {
+		t.Fatalf("expected %d acks, got %v", len(expected), acker.acks)
+	}
+	for i, a := range expected {
+		if acker.acks[i] != a {
+			t.Errorf("ack %d: expected %+v, got %+v", i, a, acker.acks[i])
+		}
+	}
+}
+
+func TestRunBatchLoop_FlushesOnTimeout(t *testing.T) {
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery)
+	handled := make(chan int, 1)
+
+	cfg := ConsumerConfig{ConsumerName: "test", BatchSize: 100, BatchTimeout: 20 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		runBatchLoop(msgs, cfg, func(_ context.Context, batch []amqp.Delivery) error {
+			handled <- len(batch)
+			return nil
+		})
+		close(done)
+	}()
+
+	msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 1}
+	msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 2}
+
+	select {
+	case n := <-handled:
+		if n != 2 {
+			t.Errorf("expected batch of 2, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batch was not flushed after timeout")
+	}
+
+	close(msgs)
+	<-done
+}
+
+func TestRunBatchLoop_NacksBatchOnError(t *testing.T) {
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 3)
+	for i := 1; i <= 3; i++ {
+		msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i)}
+	}
+	close(msgs)
+
+	cfg := ConsumerConfig{ConsumerName: "test", BatchSize: 3, BatchTimeout: time.Hour}
+	runBatchLoop(msgs, cfg, func(_ context.Context, batch []amqp.Delivery) error {
+		return errors.New("boom")
+	})
+
+	if len(acker.acks) != 0 {
+		t.Errorf("expected no acks, got %v", acker.acks)
+	}
+	if len(acker.nacks) != 1 || acker.nacks[0] != (ackCall{Tag: 3, Multiple: true}) {
+		t.Errorf("expected one multiple nack on tag 3, got %v", acker.nacks)
+	}
+}
+
+func TestRunBatchLoop_RequeuesUnderDeliveryLimit(t *testing.T) {
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 3)
+	msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 1}
+	msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 2, Headers: amqp.Table{"x-delivery-count": int64(2)}}
+	msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 3, Headers: amqp.Table{"x-delivery-count": int64(3)}}
+	close(msgs)
+
+	cfg := ConsumerConfig{ConsumerName: "retry-test", DLQName: "dlq.retry-test", DeliveryLimit: 3, BatchSize: 3, BatchTimeout: time.Hour}
+	runBatchLoop(msgs, cfg, func(_ context.Context, batch []amqp.Delivery) error {
+		return errors.New("boom")
+	})
+
+	expected := []ackCall{{Tag: 1, Requeue: true}, {Tag: 2, Requeue: true}, {Tag: 3}}
+	if len(acker.nacks) != len(expected) {
+		t.Fatalf("expected %d nacks, got %v", len(expected), acker.nacks)
+	}
+	for i, n := range expected {
+		if acker.nacks[i] != n {
+			t.Errorf("nack %d: expected %+v, got %+v", i, n, acker.nacks[i])
+		}
+	}
+	if got := testutil.ToFloat64(requeuedTotal.WithLabelValues("retry-test")); got != 2 {
+		t.Errorf("expected 2 requeued messages, got %v", got)
+	}
+	if got := testutil.ToFloat64(deadLetteredTotal.WithLabelValues("retry-test", "dlq.retry-test")); got != 1 {
+		t.Errorf("expected 1 dead-lettered message, got %v", got)
+	}
+}
+
+func TestWillRetry(t *testing.T) {
+	cfg := ConsumerConfig{DeliveryLimit: 2}
+	tests := []struct {
+		headers amqp.Table
+		want    bool
+	}{
+		{nil, true},
+		{amqp.Table{"x-delivery-count": int32(1)}, true},
+		{amqp.Table{"x-delivery-count": int64(2)}, false},
+	}
+	for _, tt := range tests {
+		ctx := context.WithValue(context.Background(), willRetryKey{}, cfg.retries(amqp.Delivery{Headers: tt.headers}))
+		if got := WillRetry(ctx); got != tt.want {
+			t.Errorf("headers %v: expected %t, got %t", tt.headers, tt.want, got)
+		}
+	}
+	if (ConsumerConfig{}).retries(amqp.Delivery{}) {
+		t.Error("expected no retries without a delivery limit")
+	}
+	if WillRetry(context.Background()) {
+		t.Error("expected false outside a consumer")
+	}
+}
+
+func TestRunBatchLoop_NacksRejectedOnly(t *testing.T) {
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 3)
+	for i := 1; i <= 3; i++ {
+		msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i)}
+	}
+	close(msgs)
+
+	cfg := ConsumerConfig{ConsumerName: "reject-test", DLQName: "dlq.reject-test", BatchSize: 3, BatchTimeout: time.Hour}
+	runBatchLoop(msgs, cfg, func(ctx context.Context, batch []amqp.Delivery) error {
+		Reject(ctx, batch[2])
+		return nil
+	})
+
+	if len(acker.nacks) != 1 || acker.nacks[0] != (ackCall{Tag: 3}) {
+		t.Errorf("expected a single nack on tag 3, got %v", acker.nacks)
+	}
+	if len(acker.acks) != 1 || acker.acks[0] != (ackCall{Tag: 2, Multiple: true}) {
+		t.Errorf("expected one multiple ack on tag 2, got %v", acker.acks)
+	}
+	for result, want := range map[string]float64{"processed": 2, "failed": 1} {
+		if got := testutil.ToFloat64(consumedTotal.WithLabelValues("reject-test", result)); got != want {
+			t.Errorf("expected %v %s messages, got %v", want, result, got)
+		}
+	}
+	if got := testutil.ToFloat64(deadLetteredTotal.WithLabelValues("reject-test", "dlq.reject-test")); got != 1 {
+		t.Errorf("expected 1 dead-lettered message, got %v", got)
+	}
+}
+
+func TestRunBatchLoop_RecordsMetrics(t *testing.T) {
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 5)
+	for i := 1; i <= 5; i++ {
+		msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i)}
+	}
+	close(msgs)
+
+	cfg := ConsumerConfig{ConsumerName: "metrics-test", DLQName: "dlq.metrics-test", BatchSize: 3, BatchTimeout: time.Hour}
+	runBatchLoop(msgs, cfg, func(ctx context.Context, batch []amqp.Delivery) error {
+		if len(batch) == 2 {
+			return errors.New("boom")
+		}
+		MarkDuplicate(ctx)
+		return nil
+	})
+
+	for result, want := range map[string]float64{"processed": 2, "duplicate": 1, "failed": 2} {
+		if got := testutil.ToFloat64(consumedTotal.WithLabelValues("metrics-test", result)); got != want {
+			t.Errorf("expected %v %s messages, got %v", want, result, got)
+		}
+	}
+	if got := testutil.ToFloat64(deadLetteredTotal.WithLabelValues("metrics-test", "dlq.metrics-test")); got != 2 {
+		t.Errorf("expected 2 dead-lettered messages, got %v", got)
+	}
+	if got := testutil.ToFloat64(inFlight.WithLabelValues("metrics-test")); got != 0 {
+		t.Errorf("expected nothing in flight, got %v", got)
+	}
+}
+
+func TestWithDelivery(t *testing.T) {
+	ctx := WithDelivery(context.Background(), amqp.Delivery{CorrelationId: "corr-1", MessageId: "evt-1"})
+	if correlation.ID(ctx) != "corr-1" || correlation.Causation(ctx) != "evt-1" {
+		t.Errorf("expected corr-1 caused by evt-1, got %q caused by %q", correlation.ID(ctx), correlation.Causation(ctx))
+	}
+
+	ctx = WithDelivery(context.Background(), amqp.Delivery{CorrelationId: "bad id"})
+	if id := correlation.ID(ctx); id == "bad id" || !correlation.Valid(id) {
+		t.Errorf("expected the invalid ID to be replaced, got %q", id)
+	}
+	if correlation.Causation(ctx) != "" {
+		t.Errorf("expected no causation without a message ID, got %q", correlation.Causation(ctx))
+	}
+}
@@ -0,0 +1,83 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"awesomeProject/pkg/health"
+)
+
+// Check reports whether the AMQP connection is open. It implements
+// health.Check.
+func (c *Connection) Check(context.Context) (map[string]interface{}, error) {
+	if c.Conn == nil || c.Conn.IsClosed() {
+		return nil, errors.New("connection closed")
+	}
+	return nil, nil
+}
+
+// Check reports whether the publishing channel is open. It implements
+// health.Check.
+func (p *Publisher) Check(context.Context) (map[string]interface{}, error) {
+	if p.channel.IsClosed() {
+		return nil, errors.New("channel closed")
+	}
+	return nil, nil
+}
+
+// Alive reports whether the delivery loop is still running. Nothing restarts
+// it once its channel closes, so it makes a liveness check. It implements
+// health.Check.
+func (c *Consumer) Alive(context.Context) (map[string]interface{}, error) {
+	select {
+	case <-c.done:
+		return nil, errors.New("delivery loop stopped")
+	default:
+		return nil, nil
+	}
+}
+
+// Check returns a readiness check that the consumer's channel is open, the
+// broker has a consumer registered on the queue, and the queue holds no more
+// than maxMessages ready messages (0 disables the lag threshold). Queue and
+// dead-letter depths are reported as details.
+func (c *Consumer) Check(maxMessages int) health.Check {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		if c.ch.IsClosed() {
+			return nil, errors.New("consumer channel closed")
+		}
+
+		// Inspect on a separate channel: a failed passive declare closes
+		// the channel it runs on
+		ch, err := c.conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		defer ch.Close()
+
+		q, err := ch.QueueDeclarePassive(c.cfg.QueueName, true, false, false, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]interface{}{
+			"queue":     q.Name,
+			"messages":  q.Messages,
+			"consumers": q.Consumers,
+		}
+		if dlq, err := ch.QueueDeclarePassive(c.cfg.DLQName, true, false, false, false, nil); err == nil {
+			details["dead_lettered"] = dlq.Messages
+		}
+		if maxMessages > 0 {
+			details["max_messages"] = maxMessages
+		}
+
+		if q.Consumers == 0 {
+			return details, errors.New("no consumer registered on queue")
+		}
+		if maxMessages > 0 && q.Messages > maxMessages {
+			return details, fmt.Errorf("queue lag of %d messages is over the threshold of %d", q.Messages, maxMessages)
+		}
+		return details, nil
+	}
+}
@@ -0,0 +1,94 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher and consumer metrics, recorded by Publish, SetupConsumer and
+// SetupBatchConsumer so handlers don't have to.
+var (
+	publishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rabbitmq_published_total",
+		Help: "Messages published, by routing key and result (success or failure).",
+	}, []string{"routing_key", "result"})
+	consumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_messages_total",
+		Help: "Messages handled, by consumer and result (processed, failed or duplicate).",
+	}, []string{"consumer", "result"})
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "consumer_handler_duration_seconds",
+		Help:    "Time spent in the message handler per call (one message, or one batch).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"consumer"})
+	inFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumer_messages_in_flight",
+		Help: "Messages handed to the handler and not yet settled.",
+	}, []string{"consumer"})
+	deadLetteredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_dead_lettered_total",
+		Help: "Messages nacked without requeue, so routed to the dead-letter queue.",
+	}, []string{"consumer", "dlq"})
+	requeuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_requeued_total",
+		Help: "Failed messages requeued for another attempt, under the queue's delivery limit.",
+	}, []string{"consumer"})
+)
+
+type duplicatesKey struct{}
+
+// MarkDuplicate tells the consumer framework that the handler skipped a
+// message it had already processed, so it is counted as a duplicate rather
+// than processed. In batch mode call it once per skipped message. It does
+// nothing outside a consumer.
+func MarkDuplicate(ctx context.Context) {
+	if n, ok := ctx.Value(duplicatesKey{}).(*atomic.Int64); ok {
+		n.Add(1)
+	}
+}
+
+// withDuplicates returns ctx carrying a fresh duplicate count.
+func withDuplicates(ctx context.Context) (context.Context, *atomic.Int64) {
+	n := new(atomic.Int64)
+	return context.WithValue(ctx, duplicatesKey{}, n), n
+}
+
+// instrument runs a handler call over size messages, tracking it in the
+// in-flight gauge and latency histogram and counting its outcome. It
+// returns the deliveries the handler rejected.
+func instrument(ctx context.Context, cfg ConsumerConfig, size int, call func(context.Context) error) ([]amqp.Delivery, error) {
+	gauge := inFlight.WithLabelValues(cfg.ConsumerName)
+	gauge.Add(float64(size))
+	defer gauge.Sub(float64(size))
+
+	ctx, duplicates := withDuplicates(ctx)
+	ctx, rejections := withRejections(ctx)
+	start := time.Now()
+	err := call(ctx)
+	handlerDuration.WithLabelValues(cfg.ConsumerName).Observe(time.Since(start).Seconds())
+	rejected := rejections.deliveries()
+	recordOutcome(cfg, size, duplicates.Load(), int64(len(rejected)), err)
+	return rejected, err
+}
+
+// recordOutcome counts a settled handler call over size messages.
+func recordOutcome(cfg ConsumerConfig, size int, duplicates, rejected int64, err error) {
+	if err != nil {
+		consumedTotal.WithLabelValues(cfg.ConsumerName, "failed").Add(float64(size))
+		return
+	}
+	if rejected > int64(size) {
+		rejected = int64(size)
+	}
+	if duplicates > int64(size)-rejected {
+		duplicates = int64(size) - rejected
+	}
+	consumedTotal.WithLabelValues(cfg.ConsumerName, "failed").Add(float64(rejected))
+	consumedTotal.WithLabelValues(cfg.ConsumerName, "duplicate").Add(float64(duplicates))
+	consumedTotal.WithLabelValues(cfg.ConsumerName, "processed").Add(float64(int64(size) - rejected - duplicates))
+}
@@ -2,9 +2,11 @@ package rabbitmq
 
 import (
 	"context"
-	"log"
 	"time"
 
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/tracing"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -39,14 +41,20 @@ func NewPublisher(conn *Connection) (*Publisher, error) {
 	return &Publisher{channel: ch}, nil
 }
 
-// Publish sends a message to the exchange with the given routing key.
-func (p *Publisher) Publish(routingKey string, body []byte, correlationID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Publish sends a message to the exchange with the given routing key, in a
+// producer span whose trace context travels in the message headers.
+// messageID identifies the message (for events, the event ID); consumers
+// take it as the causation ID of anything they emit in response.
+func (p *Publisher) Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error {
+	ctx, span, headers := startPublishSpan(ctx, routingKey)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	log.Printf("[Publisher] Publishing event: routing_key=%s correlation_id=%s", routingKey, correlationID)
+	logging.FromContext(ctx).Debug("publishing event", "routing_key", routingKey, logging.KeyCorrelationID, correlationID, "message_id", messageID)
 
-	return p.channel.PublishWithContext(
+	err := p.channel.PublishWithContext(
 		ctx,
 		ExchangeName,
 		routingKey,
@@ -55,11 +63,21 @@ func (p *Publisher) Publish(routingKey string, body []byte, correlationID string
 		amqp.Publishing{
 			ContentType:   "application/json",
 			CorrelationId: correlationID,
+			MessageId:     messageID,
+			Headers:       headers,
 			Body:          body,
 			DeliveryMode:  amqp.Persistent,
 			Timestamp:     time.Now(),
 		},
 	)
+	tracing.RecordError(span, err)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	publishedTotal.WithLabelValues(routingKey, result).Inc()
+	return err
 }
 
 // Close closes the publisher channel.
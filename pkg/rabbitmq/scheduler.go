@@ -0,0 +1,222 @@
+package rabbitmq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"awesomeProject/pkg/logging"
+
+	"github.com/google/uuid"
+)
+
+// Scheduler defaults.
+const (
+	// DefaultSchedulerBatch is how many due messages PublishDue takes at once
+	DefaultSchedulerBatch = 100
+	// SchedulerRetryDelay postpones a message whose publish failed
+	SchedulerRetryDelay = 30 * time.Second
+)
+
+// MessagePublisher is what the Scheduler publishes due messages with;
+// *Publisher satisfies it.
+type MessagePublisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error
+}
+
+// ScheduledMessage is a message to publish at a later time.
+type ScheduledMessage struct {
+	ID string `json:"id"`
+	// Key groups messages so they can be cancelled together (e.g.
+	// "reminder:<user id>"); may be empty
+	Key           string          `json:"key,omitempty"`
+	RoutingKey    string          `json:"routing_key"`
+	Body          json.RawMessage `json:"body"`
+	CorrelationID string          `json:"correlation_id"`
+	MessageID     string          `json:"message_id"`
+	PublishAt     time.Time       `json:"publish_at"`
+	// Attempts and LastError record failed publishes
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx, so messages can be
+// scheduled and cancelled in the same transaction as the change behind them.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Scheduler delays messages in the scheduled_messages table until they are
+// due. PublishDue locks due rows with SKIP LOCKED, so any number of
+// replicas can poll the same table; a message is published at least once.
+type Scheduler struct {
+	DB        *sql.DB
+	Publisher MessagePublisher
+
+	// BatchSize bounds each PublishDue; zero means DefaultSchedulerBatch
+	BatchSize int
+}
+
+// NewScheduler creates a Scheduler publishing with pub.
+func NewScheduler(db *sql.DB, pub MessagePublisher) *Scheduler {
+	return &Scheduler{DB: db, Publisher: pub}
+}
+
+const scheduledColumns = "id, key, routing_key, body, correlation_id, message_id, publish_at, attempts, last_error, created_at"
+
+// Schedule stores msgs with a single multi-row insert, assigning missing
+// IDs. It returns the messages as stored.
+func (s *Scheduler) Schedule(ctx context.Context, q Querier, msgs ...ScheduledMessage) ([]ScheduledMessage, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	const cols = 7
+	values := make([]string, len(msgs))
+	args := make([]interface{}, 0, len(msgs)*cols)
+	for i := range msgs {
+		m := &msgs[i]
+		if m.ID == "" {
+			m.ID = uuid.New().String()
+		}
+		if m.MessageID == "" {
+			m.MessageID = m.ID
+		}
+		m.CreatedAt = time.Now()
+		placeholders := make([]string, cols)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*cols+j+1)
+		}
+		values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+		args = append(args, m.ID, m.Key, m.RoutingKey, []byte(m.Body), m.CorrelationID, m.MessageID, m.PublishAt)
+	}
+
+	_, err := q.ExecContext(ctx,
+		"INSERT INTO scheduled_messages (id, key, routing_key, body, correlation_id, message_id, publish_at) VALUES "+
+			strings.Join(values, ", "),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// Cancel drops a pending message. It reports false if there was none with
+// that ID (never scheduled, already published or cancelled).
+func (s *Scheduler) Cancel(ctx context.Context, q Querier, id string) (bool, error) {
+	res, err := q.ExecContext(ctx, "DELETE FROM scheduled_messages WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CancelKey drops every pending message with key and returns how many.
+func (s *Scheduler) CancelKey(ctx context.Context, q Querier, key string) (int64, error) {
+	res, err := q.ExecContext(ctx, "DELETE FROM scheduled_messages WHERE key = $1", key)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// List returns pending messages, soonest first, optionally only those with
+// key or routingKey. A limit of 0 means no limit.
+func (s *Scheduler) List(ctx context.Context, key, routingKey string, limit int) ([]ScheduledMessage, error) {
+	query := "SELECT " + scheduledColumns + " FROM scheduled_messages WHERE TRUE"
+	var args []interface{}
+	if key != "" {
+		args = append(args, key)
+		query += fmt.Sprintf(" AND key = $%d", len(args))
+	}
+	if routingKey != "" {
+		args = append(args, routingKey)
+		query += fmt.Sprintf(" AND routing_key = $%d", len(args))
+	}
+	query += " ORDER BY publish_at, id"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return s.query(ctx, s.DB, query, args...)
+}
+
+// PublishDue publishes the messages whose time has come and returns how
+// many went out. A message whose publish fails is retried after
+// SchedulerRetryDelay.
+func (s *Scheduler) PublishDue(ctx context.Context) (int, error) {
+	limit := s.BatchSize
+	if limit <= 0 {
+		limit = DefaultSchedulerBatch
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now()
+	due, err := s.query(ctx, tx,
+		"SELECT "+scheduledColumns+" FROM scheduled_messages WHERE publish_at <= $1 ORDER BY publish_at, id LIMIT $2 FOR UPDATE SKIP LOCKED",
+		now, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, m := range due {
+		if pubErr := s.Publisher.Publish(ctx, m.RoutingKey, m.Body, m.CorrelationID, m.MessageID); pubErr != nil {
+			logging.FromContext(ctx).Error("error publishing scheduled message", "scheduled_id", m.ID,
+				"routing_key", m.RoutingKey, logging.KeyError, pubErr)
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE scheduled_messages SET attempts = attempts + 1, last_error = $1, publish_at = $2 WHERE id = $3",
+				pubErr.Error(), now.Add(SchedulerRetryDelay), m.ID,
+			); err != nil {
+				return published, err
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM scheduled_messages WHERE id = $1", m.ID); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return published, nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (s *Scheduler) query(ctx context.Context, q queryer, query string, args ...interface{}) ([]ScheduledMessage, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []ScheduledMessage{}
+	for rows.Next() {
+		var m ScheduledMessage
+		var body []byte
+		if err := rows.Scan(&m.ID, &m.Key, &m.RoutingKey, &body, &m.CorrelationID, &m.MessageID,
+			&m.PublishAt, &m.Attempts, &m.LastError, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Body = body
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
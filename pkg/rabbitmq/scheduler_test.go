@@ -0,0 +1,143 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// recordingPublisher records Publish calls, failing those for failKey.
+type recordingPublisher struct {
+	routingKeys []string
+	messageIDs  []string
+	failKey     string
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, routingKey string, body []byte, correlationID, messageID string) error {
+	if routingKey == p.failKey {
+		return errors.New("channel closed")
+	}
+	p.routingKeys = append(p.routingKeys, routingKey)
+	p.messageIDs = append(p.messageIDs, messageID)
+	return nil
+}
+
+var scheduledColumnNames = []string{"id", "key", "routing_key", "body", "correlation_id", "message_id",
+	"publish_at", "attempts", "last_error", "created_at"}
+
+func TestSchedule_MultiRowInsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	at := time.Now().Add(time.Hour)
+	mock.ExpectExec("INSERT INTO scheduled_messages .* VALUES \\(\\$1, .*\\$7\\), \\(\\$8, .*\\$14\\)").
+		WithArgs(sqlmock.AnyArg(), "reminder:user-1", "user.reminder", []byte(`{"a":1}`), "corr-1", "msg-1", at,
+			"fixed-id", "", "user.reminder", []byte(`{"a":2}`), "corr-2", "fixed-id", at).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	s := NewScheduler(db, &recordingPublisher{})
+	msgs, err := s.Schedule(context.Background(), db,
+		ScheduledMessage{Key: "reminder:user-1", RoutingKey: "user.reminder", Body: []byte(`{"a":1}`),
+			CorrelationID: "corr-1", MessageID: "msg-1", PublishAt: at},
+		ScheduledMessage{ID: "fixed-id", RoutingKey: "user.reminder", Body: []byte(`{"a":2}`),
+			CorrelationID: "corr-2", PublishAt: at},
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if msgs[0].ID == "" || msgs[1].MessageID != "fixed-id" {
+		t.Errorf("expected IDs to be assigned, got %+v", msgs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPublishDue_PublishesAndRetries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM scheduled_messages WHERE publish_at <= \\$1 .* FOR UPDATE SKIP LOCKED").
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnRows(sqlmock.NewRows(scheduledColumnNames).
+			AddRow("sched-1", "reminder:user-1", "user.reminder", []byte(`{}`), "corr-1", "msg-1", now, 0, "", now).
+			AddRow("sched-2", "", "broken.key", []byte(`{}`), "corr-2", "msg-2", now, 0, "", now))
+	mock.ExpectExec("DELETE FROM scheduled_messages WHERE id = \\$1").
+		WithArgs("sched-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE scheduled_messages SET attempts = attempts \\+ 1").
+		WithArgs("channel closed", sqlmock.AnyArg(), "sched-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pub := &recordingPublisher{failKey: "broken.key"}
+	s := NewScheduler(db, pub)
+	s.BatchSize = 2
+	n, err := s.PublishDue(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 published, got %d", n)
+	}
+	if len(pub.messageIDs) != 1 || pub.messageIDs[0] != "msg-1" {
+		t.Errorf("expected msg-1 published under its own message ID, got %v", pub.messageIDs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestList_Filters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM scheduled_messages WHERE TRUE AND key = \\$1 AND routing_key = \\$2 ORDER BY publish_at, id LIMIT \\$3").
+		WithArgs("reminder:user-1", "user.reminder", 10).
+		WillReturnRows(sqlmock.NewRows(scheduledColumnNames))
+
+	msgs, err := NewScheduler(db, nil).List(context.Background(), "reminder:user-1", "user.reminder", 10)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if msgs == nil || len(msgs) != 0 {
+		t.Errorf("expected an empty, non-nil list, got %#v", msgs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCancel_ReportsMissing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM scheduled_messages WHERE id = \\$1").
+		WithArgs("gone").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	cancelled, err := NewScheduler(db, nil).Cancel(context.Background(), db, "gone")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cancelled {
+		t.Error("expected nothing cancelled")
+	}
+}
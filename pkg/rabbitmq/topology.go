@@ -0,0 +1,356 @@
+package rabbitmq
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/yaml.v3"
+)
+
+// Queue types.
+const (
+	QueueClassic = "classic"
+	QueueQuorum  = "quorum"
+)
+
+//go:embed topology.yaml
+var defaultTopology []byte
+
+// Topology declares the exchanges, queues and bindings the services use.
+// All exchanges and queues are durable.
+type Topology struct {
+	Exchanges []ExchangeSpec `yaml:"exchanges"`
+	Queues    []QueueSpec    `yaml:"queues"`
+}
+
+// ExchangeSpec describes an exchange.
+type ExchangeSpec struct {
+	Name string `yaml:"name"`
+	// Type is direct, fanout, topic (default) or headers
+	Type string `yaml:"type"`
+}
+
+// QueueSpec describes a queue and its bindings.
+type QueueSpec struct {
+	Name string `yaml:"name"`
+	// Type is classic (default) or quorum
+	Type string `yaml:"type"`
+	// Consumer is the consumer tag of the service reading the queue; see
+	// Topology.Consumer
+	Consumer string `yaml:"consumer,omitempty"`
+	// DeadLetterQueue receives rejected messages through the default
+	// exchange; it must be declared too
+	DeadLetterQueue string `yaml:"dead_letter_queue,omitempty"`
+	// MessageTTL expires messages left in the queue that long; zero keeps
+	// them
+	MessageTTL time.Duration `yaml:"message_ttl,omitempty"`
+	// DeliveryLimit (quorum queues only) is how many times a failed message
+	// is requeued before it is dead-lettered; zero dead-letters it at once
+	DeliveryLimit int `yaml:"delivery_limit,omitempty"`
+	// SingleActiveConsumer has the broker deliver to one consumer at a
+	// time, the others standing by
+	SingleActiveConsumer bool          `yaml:"single_active_consumer,omitempty"`
+	Bindings             []BindingSpec `yaml:"bindings,omitempty"`
+}
+
+// BindingSpec binds a queue to an exchange with each of RoutingKeys.
+type BindingSpec struct {
+	Exchange    string   `yaml:"exchange"`
+	RoutingKeys []string `yaml:"routing_keys"`
+}
+
+// Binding is a single exchange-to-queue binding.
+type Binding struct {
+	Exchange   string
+	Queue      string
+	RoutingKey string
+}
+
+func (b Binding) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", b.Exchange, b.Queue, b.RoutingKey)
+}
+
+// Arguments returns the x-arguments the queue is declared with.
+func (q QueueSpec) Arguments() amqp.Table {
+	args := amqp.Table{}
+	if q.Type == QueueQuorum {
+		args["x-queue-type"] = QueueQuorum
+	}
+	if q.DeadLetterQueue != "" {
+		args["x-dead-letter-exchange"] = "" // default exchange
+		args["x-dead-letter-routing-key"] = q.DeadLetterQueue
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL.Milliseconds()
+	}
+	if q.DeliveryLimit > 0 {
+		args["x-delivery-limit"] = int64(q.DeliveryLimit)
+	}
+	if q.SingleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+	return args
+}
+
+// LoadTopology reads a YAML (or JSON) topology from path, or the built-in
+// one if path is empty, and validates it.
+func LoadTopology(path string) (*Topology, error) {
+	data := defaultTopology
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return ParseTopology(data)
+}
+
+// ParseTopology decodes and validates a YAML (or JSON) topology, filling in
+// default exchange and queue types.
+func ParseTopology(data []byte) (*Topology, error) {
+	var t Topology
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid topology: %w", err)
+	}
+	for i := range t.Exchanges {
+		if t.Exchanges[i].Type == "" {
+			t.Exchanges[i].Type = "topic"
+		}
+	}
+	for i := range t.Queues {
+		if t.Queues[i].Type == "" {
+			t.Queues[i].Type = QueueClassic
+		}
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Validate checks that names are unique, types are known, and that
+// bindings and dead-letter queues refer to declared exchanges and queues.
+func (t *Topology) Validate() error {
+	exchanges := make(map[string]bool, len(t.Exchanges))
+	for _, e := range t.Exchanges {
+		if e.Name == "" {
+			return errors.New("exchange without a name")
+		}
+		if exchanges[e.Name] {
+			return fmt.Errorf("exchange %q declared twice", e.Name)
+		}
+		exchanges[e.Name] = true
+		switch e.Type {
+		case "direct", "fanout", "topic", "headers":
+		default:
+			return fmt.Errorf("exchange %q: unknown type %q", e.Name, e.Type)
+		}
+	}
+
+	queues := make(map[string]bool, len(t.Queues))
+	consumers := make(map[string]bool)
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			return errors.New("queue without a name")
+		}
+		if queues[q.Name] {
+			return fmt.Errorf("queue %q declared twice", q.Name)
+		}
+		queues[q.Name] = true
+		if q.Consumer != "" {
+			if consumers[q.Consumer] {
+				return fmt.Errorf("queue %q: consumer %q already reads another queue", q.Name, q.Consumer)
+			}
+			consumers[q.Consumer] = true
+		}
+	}
+
+	for _, q := range t.Queues {
+		if q.Type != QueueClassic && q.Type != QueueQuorum {
+			return fmt.Errorf("queue %q: type must be classic or quorum, got %q", q.Name, q.Type)
+		}
+		if q.DeadLetterQueue != "" && !queues[q.DeadLetterQueue] {
+			return fmt.Errorf("queue %q: dead-letter queue %q is not declared", q.Name, q.DeadLetterQueue)
+		}
+		if q.MessageTTL < 0 {
+			return fmt.Errorf("queue %q: negative message_ttl", q.Name)
+		}
+		if q.DeliveryLimit < 0 {
+			return fmt.Errorf("queue %q: negative delivery_limit", q.Name)
+		}
+		if q.DeliveryLimit > 0 && q.Type != QueueQuorum {
+			return fmt.Errorf("queue %q: delivery_limit needs a quorum queue", q.Name)
+		}
+		for _, b := range q.Bindings {
+			if !exchanges[b.Exchange] {
+				return fmt.Errorf("queue %q: binding to undeclared exchange %q", q.Name, b.Exchange)
+			}
+			if len(b.RoutingKeys) == 0 {
+				return fmt.Errorf("queue %q: binding to %q has no routing keys", q.Name, b.Exchange)
+			}
+		}
+	}
+	return nil
+}
+
+// Bindings returns every binding, queue by queue.
+func (t *Topology) Bindings() []Binding {
+	var bindings []Binding
+	for _, q := range t.Queues {
+		bindings = append(bindings, q.bindings()...)
+	}
+	return bindings
+}
+
+func (q QueueSpec) bindings() []Binding {
+	var bindings []Binding
+	for _, b := range q.Bindings {
+		for _, key := range b.RoutingKeys {
+			bindings = append(bindings, Binding{Exchange: b.Exchange, Queue: q.Name, RoutingKey: key})
+		}
+	}
+	return bindings
+}
+
+// Consumer returns the ConsumerConfig for the queue read by consumer, which
+// declares just that queue, its dead-letter queue and the exchanges it is
+// bound to. It fails unless the queue is bound on ExchangeName with each of
+// required, the keys the consumer's handler relies on.
+func (t *Topology) Consumer(consumer string, required ...string) (ConsumerConfig, error) {
+	var q *QueueSpec
+	for i := range t.Queues {
+		if t.Queues[i].Consumer == consumer {
+			q = &t.Queues[i]
+			break
+		}
+	}
+	if q == nil {
+		return ConsumerConfig{}, fmt.Errorf("topology has no queue for consumer %q", consumer)
+	}
+
+	part := &Topology{Queues: []QueueSpec{*q}}
+	if q.DeadLetterQueue != "" {
+		part.Queues = append(part.Queues, *t.queue(q.DeadLetterQueue))
+	}
+	for _, e := range t.Exchanges {
+		for _, b := range q.Bindings {
+			if b.Exchange == e.Name {
+				part.Exchanges = append(part.Exchanges, e)
+				break
+			}
+		}
+	}
+
+	cfg := ConsumerConfig{
+		QueueName:            q.Name,
+		DLQName:              q.DeadLetterQueue,
+		ConsumerName:         consumer,
+		QueueType:            q.Type,
+		DeliveryLimit:        q.DeliveryLimit,
+		SingleActiveConsumer: q.SingleActiveConsumer,
+		Topology:             part,
+	}
+	for _, b := range q.bindings() {
+		if b.Exchange == ExchangeName {
+			cfg.RoutingKeys = append(cfg.RoutingKeys, b.RoutingKey)
+		}
+	}
+	for _, key := range required {
+		if !contains(cfg.RoutingKeys, key) {
+			return ConsumerConfig{}, fmt.Errorf("queue %q is not bound to %s with %q", q.Name, ExchangeName, key)
+		}
+	}
+	return cfg, nil
+}
+
+func (t *Topology) queue(name string) *QueueSpec {
+	for i := range t.Queues {
+		if t.Queues[i].Name == name {
+			return &t.Queues[i]
+		}
+	}
+	return nil
+}
+
+func (t *Topology) exchange(name string) *ExchangeSpec {
+	for i := range t.Exchanges {
+		if t.Exchanges[i].Name == name {
+			return &t.Exchanges[i]
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Declarer declares topology on a broker; *amqp.Channel satisfies it.
+type Declarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	QueueUnbind(name, key, exchange string, args amqp.Table) error
+}
+
+// Declare declares every exchange, then every queue, then every binding.
+// Declaring is idempotent, but a queue that exists with other arguments
+// fails with PRECONDITION_FAILED and closes the channel.
+func (t *Topology) Declare(ch Declarer) error {
+	for _, e := range t.Exchanges {
+		if err := declareExchange(ch, e); err != nil {
+			return err
+		}
+	}
+	for _, q := range t.Queues {
+		if err := declareQueue(ch, q); err != nil {
+			return err
+		}
+	}
+	for _, b := range t.Bindings() {
+		if err := ch.QueueBind(b.Queue, b.RoutingKey, b.Exchange, false, nil); err != nil {
+			return fmt.Errorf("binding %s: %w", b, err)
+		}
+	}
+	return nil
+}
+
+func declareExchange(ch Declarer, e ExchangeSpec) error {
+	err := ch.ExchangeDeclare(
+		e.Name,
+		e.Type,
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("exchange %q: %w", e.Name, err)
+	}
+	return nil
+}
+
+func declareQueue(ch Declarer, q QueueSpec) error {
+	_, err := ch.QueueDeclare(
+		q.Name,
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		q.Arguments(),
+	)
+	if err != nil {
+		return fmt.Errorf("queue %q: %w", q.Name, err)
+	}
+	return nil
+}
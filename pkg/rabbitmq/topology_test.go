@@ -0,0 +1,218 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeDeclarer records declarations.
+type fakeDeclarer struct {
+	calls []string
+	args  map[string]amqp.Table
+}
+
+func (f *fakeDeclarer) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	f.calls = append(f.calls, "exchange "+name+" "+kind)
+	return nil
+}
+
+func (f *fakeDeclarer) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	if f.args == nil {
+		f.args = make(map[string]amqp.Table)
+	}
+	f.args[name] = args
+	f.calls = append(f.calls, "queue "+name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (f *fakeDeclarer) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	f.calls = append(f.calls, "bind "+exchange+" "+name+" "+key)
+	return nil
+}
+
+func (f *fakeDeclarer) QueueUnbind(name, key, exchange string, args amqp.Table) error {
+	f.calls = append(f.calls, "unbind "+exchange+" "+name+" "+key)
+	return nil
+}
+
+func TestLoadTopology_Default(t *testing.T) {
+	topology, err := LoadTopology("")
+	if err != nil {
+		t.Fatalf("expected the built-in topology to load, got %v", err)
+	}
+
+	cfg, err := topology.Consumer("crm-consumer", "user.created", "user.erased")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.QueueName != "crm.user.events" || cfg.DLQName != "dlq.crm.user.events" || cfg.ConsumerName != "crm-consumer" {
+		t.Errorf("unexpected consumer config: %+v", cfg)
+	}
+	if len(cfg.Topology.Queues) != 2 || len(cfg.Topology.Exchanges) != 1 {
+		t.Errorf("expected the queue, its DLQ and one exchange, got %+v", cfg.Topology)
+	}
+
+	if _, err := topology.Consumer("crm-consumer", "crm.contact.synced"); err == nil {
+		t.Error("expected an error for a routing key the queue is not bound with")
+	}
+	if _, err := topology.Consumer("nobody"); err == nil {
+		t.Error("expected an error for an unknown consumer")
+	}
+}
+
+func TestParseTopology_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown exchange type":  `{"exchanges": [{"name": "events", "type": "fan"}]}`,
+		"undeclared exchange":    `{"queues": [{"name": "q", "bindings": [{"exchange": "events", "routing_keys": ["a"]}]}]}`,
+		"undeclared DLQ":         `{"queues": [{"name": "q", "dead_letter_queue": "dlq.q"}]}`,
+		"unknown queue type":     `{"queues": [{"name": "q", "type": "stream"}]}`,
+		"duplicate queue":        `{"queues": [{"name": "q"}, {"name": "q"}]}`,
+		"shared consumer":        `{"queues": [{"name": "a", "consumer": "c"}, {"name": "b", "consumer": "c"}]}`,
+		"classic delivery limit": `{"queues": [{"name": "q", "delivery_limit": 3}]}`,
+	}
+	for name, doc := range tests {
+		if _, err := ParseTopology([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDeclare_QueueArguments(t *testing.T) {
+	topology, err := ParseTopology([]byte(`
+exchanges:
+  - name: events
+queues:
+  - name: work
+    type: quorum
+    dead_letter_queue: dlq.work
+    message_ttl: 1h
+    delivery_limit: 5
+    single_active_consumer: true
+    bindings:
+      - exchange: events
+        routing_keys: [a.#]
+  - name: dlq.work
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	ch := &fakeDeclarer{}
+	if err := topology.Declare(ch); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := []string{"exchange events topic", "queue work", "queue dlq.work", "bind events work a.#"}
+	if strings.Join(ch.calls, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %v, got %v", want, ch.calls)
+	}
+	args := ch.args["work"]
+	if args["x-queue-type"] != "quorum" || args["x-dead-letter-routing-key"] != "dlq.work" || args["x-message-ttl"] != int64(time.Hour/time.Millisecond) ||
+		args["x-delivery-limit"] != int64(5) || args["x-single-active-consumer"] != true {
+		t.Errorf("unexpected queue arguments: %v", args)
+	}
+	if len(ch.args["dlq.work"]) != 0 {
+		t.Errorf("expected a plain classic DLQ, got %v", ch.args["dlq.work"])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	topology, err := ParseTopology([]byte(`
+exchanges:
+  - name: events
+queues:
+  - name: work
+    dead_letter_queue: dlq.work
+    bindings:
+      - exchange: events
+        routing_keys: [a, b]
+  - name: dlq.work
+  - name: other
+    type: quorum
+`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	actual := &BrokerTopology{
+		Exchanges: []BrokerExchange{{Name: "", Type: "direct", Durable: true}, {Name: "amq.topic", Type: "topic", Durable: true},
+			{Name: "events", Type: "topic", Durable: true}},
+		Queues: []BrokerQueue{
+			// JSON numbers decode as float64
+			{Name: "work", Type: "classic", Durable: true, Arguments: map[string]interface{}{
+				"x-dead-letter-exchange": "", "x-dead-letter-routing-key": "dlq.work"}},
+			{Name: "other", Type: "classic", Durable: true, Arguments: map[string]interface{}{"x-message-ttl": float64(1000)}},
+			{Name: "stray", Type: "classic", Durable: true},
+		},
+		Bindings: []BrokerBinding{
+			{Source: "", Destination: "work", DestinationType: "queue", RoutingKey: "work"},
+			{Source: "events", Destination: "work", DestinationType: "queue", RoutingKey: "a"},
+			{Source: "events", Destination: "work", DestinationType: "queue", RoutingKey: "old"},
+		},
+	}
+
+	var got []string
+	for _, c := range topology.Diff(actual) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"+ queue dlq.work",
+		"~ queue other: type is classic, want quorum, unexpected x-message-ttl 1000",
+		"? queue stray",
+		"+ binding events -> work (b)",
+		"- binding events -> work (old)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	ch := &fakeDeclarer{}
+	n, err := Apply(ch, topology.Diff(actual))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	wantCalls := []string{"queue dlq.work", "bind events work b", "unbind events work old"}
+	if n != 3 || strings.Join(ch.calls, "|") != strings.Join(wantCalls, "|") {
+		t.Errorf("expected %v, got %d changes: %v", wantCalls, n, ch.calls)
+	}
+}
+
+func TestManagement_Fetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "guest" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/exchanges/%2F":
+			_ = json.NewEncoder(w).Encode([]BrokerExchange{{Name: "events", Type: "topic", Durable: true}})
+		case "/api/queues/%2F":
+			_, _ = w.Write([]byte(`[{"name": "work", "type": "quorum", "durable": true, "arguments": {"x-queue-type": "quorum"}, "messages": 3}]`))
+		case "/api/bindings/%2F":
+			_ = json.NewEncoder(w).Encode([]BrokerBinding{{Source: "events", Destination: "work", DestinationType: "queue", RoutingKey: "a"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	mgmt := NewManagement(strings.Replace(srv.URL, "http://", "http://guest:secret@", 1) + "/")
+	bt, err := mgmt.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(bt.Exchanges) != 1 || len(bt.Queues) != 1 || bt.Queues[0].Type != "quorum" || len(bt.Bindings) != 1 {
+		t.Errorf("unexpected broker topology: %+v", bt)
+	}
+
+	mgmt.Vhost = "missing"
+	if _, err := mgmt.Fetch(context.Background()); err == nil {
+		t.Error("expected an error for a 404")
+	}
+}
@@ -0,0 +1,92 @@
+package rabbitmq
+
+import (
+	"context"
+
+	"awesomeProject/pkg/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier adapts AMQP message headers for trace context propagation.
+type headerCarrier amqp.Table
+
+func (h headerCarrier) Get(key string) string {
+	v, _ := h[key].(string)
+	return v
+}
+
+func (h headerCarrier) Set(key, value string) { h[key] = value }
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startPublishSpan starts a producer span for routingKey and returns headers
+// carrying its trace context (traceparent).
+func startPublishSpan(ctx context.Context, routingKey string) (context.Context, trace.Span, amqp.Table) {
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+routingKey,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(ExchangeName),
+			semconv.MessagingRabbitmqDestinationRoutingKey(routingKey),
+		),
+	)
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	return ctx, span, headers
+}
+
+// startDeliverySpan continues the publisher's trace from msg's headers with a
+// consumer span.
+func startDeliverySpan(ctx context.Context, cfg ConsumerConfig, msg amqp.Delivery) (context.Context, trace.Span) {
+	if msg.Headers != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Headers))
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "process "+cfg.QueueName,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(cfg.QueueName),
+			semconv.MessagingRabbitmqDestinationRoutingKey(msg.RoutingKey),
+			semconv.MessagingMessageID(msg.MessageId),
+		),
+	)
+	return tracing.WithLogFields(ctx), span
+}
+
+// startBatchSpan starts a consumer span for a batch, linked to the trace of
+// every message in it.
+func startBatchSpan(ctx context.Context, cfg ConsumerConfig, batch []amqp.Delivery) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, msg := range batch {
+		if msg.Headers == nil {
+			continue
+		}
+		sc := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(msg.Headers)))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "process "+cfg.QueueName,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(cfg.QueueName),
+			semconv.MessagingBatchMessageCount(len(batch)),
+		),
+	)
+	return tracing.WithLogFields(ctx), span
+}
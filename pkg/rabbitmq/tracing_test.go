@@ -0,0 +1,93 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider and W3C propagator for the test and
+// returns the recorder that sees every ended span.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return rec
+}
+
+func TestTracing_ConsumerContinuesPublisherTrace(t *testing.T) {
+	recordSpans(t)
+
+	_, pub, headers := startPublishSpan(context.Background(), "user.created")
+	pub.End()
+	if _, ok := headers["traceparent"].(string); !ok {
+		t.Fatalf("expected traceparent header, got %v", headers)
+	}
+
+	cfg := ConsumerConfig{QueueName: "crm.user-events"}
+	ctx, span := startDeliverySpan(context.Background(), cfg, amqp.Delivery{Headers: headers, RoutingKey: "user.created"})
+	span.End()
+
+	got := span.(sdktrace.ReadOnlySpan)
+	if got.Parent().SpanID() != pub.SpanContext().SpanID() {
+		t.Errorf("expected consumer span to be a child of the publish span")
+	}
+	if got.SpanContext().TraceID() != pub.SpanContext().TraceID() {
+		t.Errorf("expected consumer span in the publisher's trace")
+	}
+	if got.SpanKind() != trace.SpanKindConsumer || got.Name() != "process crm.user-events" {
+		t.Errorf("unexpected span %q of kind %v", got.Name(), got.SpanKind())
+	}
+	if trace.SpanFromContext(ctx) != span {
+		t.Error("expected the span on the handler context")
+	}
+}
+
+func TestRunBatchLoop_LinksBatchSpanToMessages(t *testing.T) {
+	rec := recordSpans(t)
+
+	acker := &fakeAcker{}
+	msgs := make(chan amqp.Delivery, 3)
+	var published []trace.SpanContext
+	for i := 1; i <= 2; i++ {
+		_, pub, headers := startPublishSpan(context.Background(), "user.created")
+		pub.End()
+		published = append(published, pub.SpanContext())
+		msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i), Headers: headers}
+	}
+	msgs <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 3} // no trace context
+	close(msgs)
+
+	cfg := ConsumerConfig{ConsumerName: "tracing-test", QueueName: "analytics.user-events", BatchSize: 3, BatchTimeout: time.Hour}
+	runBatchLoop(msgs, cfg, func(context.Context, []amqp.Delivery) error { return nil })
+
+	var batch sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		if s.Name() == "process analytics.user-events" {
+			batch = s
+		}
+	}
+	if batch == nil {
+		t.Fatal("expected a batch span")
+	}
+	if batch.Parent().IsValid() {
+		t.Error("expected the batch span to start its own trace")
+	}
+	links := batch.Links()
+	if len(links) != len(published) {
+		t.Fatalf("expected %d links, got %d", len(published), len(links))
+	}
+	for i, l := range links {
+		if l.SpanContext.SpanID() != published[i].SpanID() {
+			t.Errorf("link %d does not point at the publish span", i)
+		}
+	}
+}
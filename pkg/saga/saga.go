@@ -0,0 +1,652 @@
+// Package saga runs multi-step workflows about a user, driven by events on
+// the events exchange. Each saga's progress is persisted in the sagas table,
+// so a restart picks up where it left off. A step that waits for an event
+// times out, and a failed step undoes the completed ones in reverse order.
+//
+// Steps and compensations run while the saga's row is locked, but their side
+// effects commit on their own. Each one that succeeds is recorded in the
+// saga_step_runs table straight away, outside the saga's transaction, so if
+// saving the saga then fails and the event is redelivered, it is skipped
+// rather than run again.
+//
+// A step's event can be handled before the event that starts its saga, e.g.
+// with several consumers, after a requeue, or when starting the saga failed
+// and its event went back to the queue. Such early events are kept in the
+// saga_early_events table and applied once the saga starts.
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awesomeProject/pkg/correlation"
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/models"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Saga statuses.
+const (
+	StatusRunning     = "running"
+	StatusCompleted   = "completed"
+	StatusCompensated = "compensated" // a step failed and the earlier ones were undone
+	StatusFailed      = "failed"      // undoing a step failed too; needs an operator
+)
+
+// Step actions, as recorded in saga_step_runs.
+const (
+	actionDo         = "do"
+	actionCompensate = "compensate"
+)
+
+// Step statuses.
+const (
+	StepPending     = "pending"
+	StepWaiting     = "waiting"
+	StepCompleted   = "completed"
+	StepFailed      = "failed"
+	StepCompensated = "compensated"
+)
+
+// DefaultStepTimeout bounds a step's wait when it sets no Timeout.
+const DefaultStepTimeout = 5 * time.Minute
+
+// ErrStepTimeout fails a step whose event did not arrive in time.
+var ErrStepTimeout = errors.New("step timed out")
+
+// Step is one stage of a saga.
+type Step struct {
+	Name string
+
+	// Do performs the step; nil for steps with nothing to do but wait
+	Do func(ctx context.Context, s *Saga) error
+
+	// CompletedBy and FailedBy are the events, caused by the event that
+	// started the saga, that end the step's wait. With no CompletedBy the
+	// step is done when Do returns.
+	CompletedBy, FailedBy models.EventType
+
+	// Timeout bounds the wait for CompletedBy; zero means DefaultStepTimeout
+	Timeout time.Duration
+
+	// Compensate undoes the step when a later one fails; nil if there is
+	// nothing to undo
+	Compensate func(ctx context.Context, s *Saga) error
+}
+
+// Definition describes a kind of saga. At most one runs per user.
+type Definition struct {
+	Name string
+
+	// StartOn is the user event that starts a saga for its user; Start, if
+	// set, picks which of those do
+	StartOn models.EventType
+	Start   func(event models.UserEvent) bool
+
+	Steps []Step
+}
+
+// StepState is a step's progress within one saga.
+type StepState struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Saga is one run of a Definition for a user.
+type Saga struct {
+	ID             string      `json:"id"`
+	Name           string      `json:"name"`
+	UserID         string      `json:"user_id"`
+	TriggerEventID string      `json:"trigger_event_id"`
+	CorrelationID  string      `json:"correlation_id"`
+	Status         string      `json:"status"`
+	CurrentStep    int         `json:"current_step"`
+	Steps          []StepState `json:"steps"`
+	StepDeadline   *time.Time  `json:"step_deadline,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+// envelope holds the fields every event shares that the engine routes on.
+type envelope struct {
+	EventID     string           `json:"event_id"`
+	EventType   models.EventType `json:"event_type"`
+	CausationID string           `json:"causation_id"`
+	Error       string           `json:"error"`
+}
+
+// Engine starts and advances sagas.
+type Engine struct {
+	DB *sql.DB
+
+	defs []*Definition
+}
+
+// NewEngine creates an Engine running defs.
+func NewEngine(db *sql.DB, defs ...*Definition) *Engine {
+	return &Engine{DB: db, defs: defs}
+}
+
+// RoutingKeys returns the events the engine needs to receive.
+func (e *Engine) RoutingKeys() []string {
+	seen := make(map[models.EventType]bool)
+	var keys []string
+	add := func(t models.EventType) {
+		if t != "" && !seen[t] {
+			seen[t] = true
+			keys = append(keys, string(t))
+		}
+	}
+	for _, def := range e.defs {
+		add(def.StartOn)
+		for _, step := range def.Steps {
+			add(step.CompletedBy)
+			add(step.FailedBy)
+		}
+	}
+	return keys
+}
+
+// stepEvent reports whether t ends some step's wait.
+func (e *Engine) stepEvent(t models.EventType) bool {
+	for _, def := range e.defs {
+		for _, step := range def.Steps {
+			if t != "" && (t == step.CompletedBy || t == step.FailedBy) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *Engine) definition(name string) *Definition {
+	for _, def := range e.defs {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+const sagaColumns = "id, name, user_id, trigger_event_id, correlation_id, status, current_step, steps, step_deadline, error, created_at, updated_at"
+
+// HandleMessage starts sagas on their StartOn events and advances the
+// sagas waiting on any other event.
+func (e *Engine) HandleMessage(ctx context.Context, delivery amqp.Delivery) error {
+	var env envelope
+	if err := json.Unmarshal(delivery.Body, &env); err != nil {
+		logging.FromContext(ctx).Error("failed to unmarshal event", logging.KeyError, err)
+		return err
+	}
+
+	for _, def := range e.defs {
+		if env.EventType != def.StartOn {
+			continue
+		}
+		var event models.UserEvent
+		if err := json.Unmarshal(delivery.Body, &event); err != nil {
+			return err
+		}
+		if def.Start != nil && !def.Start(event) {
+			continue
+		}
+		if err := e.start(ctx, def, event); err != nil {
+			logging.FromContext(ctx).Error("error starting saga", "saga", def.Name, logging.KeyError, err)
+			return err
+		}
+		if err := e.applyEarlyEvents(ctx, event.EventID); err != nil {
+			logging.FromContext(ctx).Error("error applying early saga events", "saga", def.Name, logging.KeyError, err)
+			return err
+		}
+	}
+
+	if env.CausationID == "" || !e.stepEvent(env.EventType) {
+		return nil
+	}
+	ids, err := e.ids(ctx, "SELECT id FROM sagas WHERE trigger_event_id = $1", env.CausationID)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return e.keepEarlyEvent(ctx, env)
+	}
+	for _, id := range ids {
+		err := e.transition(ctx, id, func(ctx context.Context, tx *sql.Tx, s *Saga, def *Definition) error {
+			return e.stepEnded(ctx, s, def, env)
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("error advancing saga", "saga_id", id, logging.KeyError, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// stepEnded applies env, an event that may end the current step's wait.
+func (e *Engine) stepEnded(ctx context.Context, s *Saga, def *Definition, env envelope) error {
+	step := def.Steps[s.CurrentStep]
+	switch env.EventType {
+	case step.CompletedBy:
+		return e.complete(ctx, s, def)
+	case step.FailedBy:
+		msg := env.Error
+		if msg == "" {
+			msg = string(env.EventType)
+		}
+		return e.fail(ctx, s, def, errors.New(msg))
+	}
+	return nil
+}
+
+// keepEarlyEvent stores a step event whose saga doesn't exist yet, then
+// applies it if the saga was started meanwhile: either this or start's own
+// check sees the other's write.
+func (e *Engine) keepEarlyEvent(ctx context.Context, env envelope) error {
+	_, err := e.DB.ExecContext(ctx,
+		`INSERT INTO saga_early_events (event_id, trigger_event_id, event_type, error) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (event_id) DO NOTHING`,
+		env.EventID, env.CausationID, string(env.EventType), env.Error,
+	)
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("saga event arrived before its saga, keeping it", "trigger_event_id", env.CausationID)
+	return e.applyEarlyEvents(ctx, env.CausationID)
+}
+
+// applyEarlyEvents feeds the early events kept for triggerEventID, oldest
+// first, to the running sagas it started. An event is deleted in the
+// transaction that applies it, so it is applied once; events for a later
+// step are kept until that step waits.
+func (e *Engine) applyEarlyEvents(ctx context.Context, triggerEventID string) error {
+	ids, err := e.ids(ctx,
+		`SELECT DISTINCT s.id FROM sagas s JOIN saga_early_events x ON x.trigger_event_id = s.trigger_event_id
+		 WHERE s.trigger_event_id = $1 AND s.status = $2`,
+		triggerEventID, StatusRunning,
+	)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := e.transition(ctx, id, func(ctx context.Context, tx *sql.Tx, s *Saga, def *Definition) error {
+			events, err := earlyEvents(ctx, tx, triggerEventID)
+			if err != nil {
+				return err
+			}
+			for applied := true; applied && s.Status == StatusRunning; {
+				applied = false
+				for i, env := range events {
+					step := def.Steps[s.CurrentStep]
+					if env.EventType != step.CompletedBy && env.EventType != step.FailedBy {
+						continue
+					}
+					if _, err := tx.ExecContext(ctx, "DELETE FROM saga_early_events WHERE event_id = $1", env.EventID); err != nil {
+						return err
+					}
+					if err := e.stepEnded(ctx, s, def, env); err != nil {
+						return err
+					}
+					events = append(events[:i], events[i+1:]...)
+					applied = true
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func earlyEvents(ctx context.Context, tx *sql.Tx, triggerEventID string) ([]envelope, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT event_id, event_type, error FROM saga_early_events
+		 WHERE trigger_event_id = $1 ORDER BY received_at`,
+		triggerEventID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []envelope
+	for rows.Next() {
+		env := envelope{CausationID: triggerEventID}
+		if err := rows.Scan(&env.EventID, &env.EventType, &env.Error); err != nil {
+			return nil, err
+		}
+		events = append(events, env)
+	}
+	return events, rows.Err()
+}
+
+// PurgeEarlyEvents deletes early events kept longer than maxAge, such as
+// results about users no saga was started for, and returns how many went.
+func (e *Engine) PurgeEarlyEvents(ctx context.Context, maxAge time.Duration) (int64, error) {
+	res, err := e.DB.ExecContext(ctx,
+		"DELETE FROM saga_early_events WHERE received_at < NOW() - $1 * INTERVAL '1 second'",
+		int64(maxAge/time.Second),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ExpireSteps fails the steps whose wait has passed its deadline and
+// returns how many it found.
+func (e *Engine) ExpireSteps(ctx context.Context) (int, error) {
+	now := time.Now()
+	ids, err := e.ids(ctx, "SELECT id FROM sagas WHERE status = $1 AND step_deadline < $2", StatusRunning, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		err := e.transition(ctx, id, func(ctx context.Context, tx *sql.Tx, s *Saga, def *Definition) error {
+			if s.StepDeadline != nil && s.StepDeadline.Before(now) {
+				return e.fail(ctx, s, def, ErrStepTimeout)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// ListByUser returns the user's sagas, oldest first.
+func (e *Engine) ListByUser(ctx context.Context, userID string) ([]Saga, error) {
+	rows, err := e.DB.QueryContext(ctx, "SELECT "+sagaColumns+" FROM sagas WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sagas := []Saga{}
+	for rows.Next() {
+		s, err := scanSaga(rows)
+		if err != nil {
+			return nil, err
+		}
+		sagas = append(sagas, s)
+	}
+	return sagas, rows.Err()
+}
+
+// start creates a saga for event's user and runs it to its first wait. A
+// redelivered event finds the saga already there and does nothing. The saga
+// ID derives from the event, so a redelivery after a failed commit gets the
+// same ID and finds the steps that already ran.
+func (e *Engine) start(ctx context.Context, def *Definition, event models.UserEvent) error {
+	s := &Saga{
+		ID:             uuid.NewSHA1(uuid.NameSpaceOID, []byte(def.Name+":"+event.EventID)).String(),
+		Name:           def.Name,
+		UserID:         event.Data.ID,
+		TriggerEventID: event.EventID,
+		CorrelationID:  event.CorrelationID,
+		Status:         StatusRunning,
+		Steps:          make([]StepState, len(def.Steps)),
+	}
+	for i, step := range def.Steps {
+		s.Steps[i] = StepState{Name: step.Name, Status: StepPending}
+	}
+	steps, err := json.Marshal(s.Steps)
+	if err != nil {
+		return err
+	}
+
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO sagas (id, name, user_id, trigger_event_id, correlation_id, status, current_step, steps)
+		 VALUES ($1, $2, $3, $4, $5, $6, 0, $7)
+		 ON CONFLICT (name, user_id) DO NOTHING`,
+		s.ID, s.Name, s.UserID, s.TriggerEventID, s.CorrelationID, s.Status, steps,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return nil
+	}
+
+	sagaLogger(ctx, s).Info("saga started")
+	if err := e.advance(ctx, s, def); err != nil {
+		return err
+	}
+	if err := save(ctx, tx, s); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// transition locks saga id, lets fn move it on and saves the result in one
+// transaction. Sagas that are no longer running are left alone.
+func (e *Engine) transition(ctx context.Context, id string, fn func(ctx context.Context, tx *sql.Tx, s *Saga, def *Definition) error) error {
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	s, err := scanSaga(tx.QueryRowContext(ctx, "SELECT "+sagaColumns+" FROM sagas WHERE id = $1 FOR UPDATE", id))
+	if err != nil {
+		return err
+	}
+	def := e.definition(s.Name)
+	if def == nil || s.Status != StatusRunning || s.CurrentStep >= len(def.Steps) {
+		return nil
+	}
+	if correlation.ID(ctx) == "" {
+		ctx = correlation.WithID(ctx, s.CorrelationID)
+	}
+
+	if err := fn(ctx, tx, &s, def); err != nil {
+		return err
+	}
+	if err := save(ctx, tx, &s); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// advance runs steps from the current one until a step waits, fails or the
+// saga completes. It returns an error only if the step journal can't be
+// read, leaving the saga to be retried.
+func (e *Engine) advance(ctx context.Context, s *Saga, def *Definition) error {
+	for s.CurrentStep < len(def.Steps) {
+		step := def.Steps[s.CurrentStep]
+		now := time.Now()
+		s.Steps[s.CurrentStep].StartedAt = &now
+
+		if step.Do != nil {
+			ran, err := e.ran(ctx, s, step.Name, actionDo)
+			if err != nil {
+				return err
+			}
+			if !ran {
+				if err := step.Do(ctx, s); err != nil {
+					return e.fail(ctx, s, def, err)
+				}
+				e.record(ctx, s, step.Name, actionDo)
+			}
+		}
+		if step.CompletedBy != "" {
+			timeout := step.Timeout
+			if timeout <= 0 {
+				timeout = DefaultStepTimeout
+			}
+			deadline := now.Add(timeout)
+			s.Steps[s.CurrentStep].Status = StepWaiting
+			s.StepDeadline = &deadline
+			return nil
+		}
+		e.finishStep(s)
+	}
+	s.Status = StatusCompleted
+	sagaLogger(ctx, s).Info("saga completed")
+	return nil
+}
+
+// complete finishes the waiting step and carries on.
+func (e *Engine) complete(ctx context.Context, s *Saga, def *Definition) error {
+	e.finishStep(s)
+	return e.advance(ctx, s, def)
+}
+
+func (e *Engine) finishStep(s *Saga) {
+	now := time.Now()
+	s.Steps[s.CurrentStep].Status = StepCompleted
+	s.Steps[s.CurrentStep].FinishedAt = &now
+	s.StepDeadline = nil
+	s.CurrentStep++
+}
+
+// fail marks the current step failed and compensates the completed steps,
+// latest first. Like advance, it returns an error only if the step journal
+// can't be read.
+func (e *Engine) fail(ctx context.Context, s *Saga, def *Definition, cause error) error {
+	now := time.Now()
+	st := &s.Steps[s.CurrentStep]
+	st.Status, st.FinishedAt, st.Error = StepFailed, &now, cause.Error()
+	s.StepDeadline = nil
+	s.Error = fmt.Sprintf("%s: %v", st.Name, cause)
+	logger := sagaLogger(ctx, s)
+	logger.Warn("saga step failed, compensating", "step", st.Name, logging.KeyError, cause)
+
+	for i := s.CurrentStep - 1; i >= 0; i-- {
+		step := def.Steps[i]
+		if step.Compensate == nil || s.Steps[i].Status != StepCompleted {
+			continue
+		}
+		ran, err := e.ran(ctx, s, step.Name, actionCompensate)
+		if err != nil {
+			return err
+		}
+		if !ran {
+			if err := step.Compensate(ctx, s); err != nil {
+				s.Steps[i].Error = err.Error()
+				s.Status = StatusFailed
+				s.Error += fmt.Sprintf("; compensating %s: %v", step.Name, err)
+				logger.Error("saga compensation failed", "step", step.Name, logging.KeyError, err)
+				return nil
+			}
+			e.record(ctx, s, step.Name, actionCompensate)
+		}
+		s.Steps[i].Status = StepCompensated
+	}
+	s.Status = StatusCompensated
+	logger.Info("saga compensated")
+	return nil
+}
+
+// ran reports whether saga_step_runs shows action of step already
+// succeeded for s, in which case it is not run again.
+func (e *Engine) ran(ctx context.Context, s *Saga, step, action string) (bool, error) {
+	var ran bool
+	err := e.DB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM saga_step_runs WHERE saga_id = $1 AND step = $2 AND action = $3)",
+		s.ID, step, action,
+	).Scan(&ran)
+	if ran {
+		sagaLogger(ctx, s).Info("saga step already ran, skipping", "step", step, "action", action)
+	}
+	return ran, err
+}
+
+// record notes in saga_step_runs that action of step succeeded for s. It
+// writes outside the saga's transaction so the record survives a failed
+// save. If the write fails the side effect has still happened, so it is
+// only logged; the action would then run again on redelivery.
+func (e *Engine) record(ctx context.Context, s *Saga, step, action string) {
+	_, err := e.DB.ExecContext(ctx,
+		"INSERT INTO saga_step_runs (saga_id, step, action) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		s.ID, step, action,
+	)
+	if err != nil {
+		sagaLogger(ctx, s).Error("failed to record saga step run", "step", step, "action", action, logging.KeyError, err)
+	}
+}
+
+func sagaLogger(ctx context.Context, s *Saga) *slog.Logger {
+	return logging.FromContext(ctx).With("saga", s.Name, "saga_id", s.ID, logging.KeyUserID, s.UserID)
+}
+
+// save writes s's progress.
+func save(ctx context.Context, tx *sql.Tx, s *Saga) error {
+	steps, err := json.Marshal(s.Steps)
+	if err != nil {
+		return err
+	}
+	var deadline sql.NullTime
+	if s.StepDeadline != nil {
+		deadline = sql.NullTime{Time: *s.StepDeadline, Valid: true}
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE sagas SET status = $1, current_step = $2, steps = $3, step_deadline = $4, error = $5, updated_at = NOW()
+		 WHERE id = $6`,
+		s.Status, s.CurrentStep, steps, deadline, s.Error, s.ID,
+	)
+	return err
+}
+
+// ids runs a query selecting saga IDs.
+func (e *Engine) ids(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := e.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSaga reads one row selected with sagaColumns.
+func scanSaga(row rowScanner) (Saga, error) {
+	var s Saga
+	var steps []byte
+	var deadline sql.NullTime
+	err := row.Scan(&s.ID, &s.Name, &s.UserID, &s.TriggerEventID, &s.CorrelationID, &s.Status, &s.CurrentStep,
+		&steps, &deadline, &s.Error, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return Saga{}, err
+	}
+	if deadline.Valid {
+		s.StepDeadline = &deadline.Time
+	}
+	if err := json.Unmarshal(steps, &s.Steps); err != nil {
+		return Saga{}, err
+	}
+	return s, nil
+}
@@ -0,0 +1,496 @@
+package saga
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recorder notes the order steps and compensations ran in.
+type recorder struct {
+	calls []string
+}
+
+func (r *recorder) step(name string, err error) func(context.Context, *Saga) error {
+	return func(context.Context, *Saga) error {
+		r.calls = append(r.calls, name)
+		return err
+	}
+}
+
+func testDefinition(r *recorder, undoErr error) *Definition {
+	return &Definition{
+		Name:    "onboarding",
+		StartOn: models.EventUserCreated,
+		Start:   func(e models.UserEvent) bool { return e.Data.Status == models.UserPending },
+		Steps: []Step{
+			{Name: "create", Compensate: r.step("undo create", undoErr)},
+			{Name: "sync", CompletedBy: models.EventCRMContactSynced, FailedBy: models.EventCRMContactSyncFailed, Timeout: time.Minute},
+			{Name: "welcome", Do: r.step("welcome", nil)},
+			{Name: "activate", Do: r.step("activate", nil)},
+		},
+	}
+}
+
+func newSaga(def *Definition) *Saga {
+	s := &Saga{ID: "saga-1", Name: def.Name, UserID: "user-1", Status: StatusRunning, Steps: make([]StepState, len(def.Steps))}
+	for i, step := range def.Steps {
+		s.Steps[i] = StepState{Name: step.Name, Status: StepPending}
+	}
+	return s
+}
+
+// expectStepRun expects the step journal lookup for an action of saga-1's
+// step, and if it hasn't run yet and succeeds, its record.
+func expectStepRun(mock sqlmock.Sqlmock, step, action string, ran, succeeds bool) {
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM saga_step_runs WHERE saga_id = \\$1 AND step = \\$2 AND action = \\$3\\)").
+		WithArgs("saga-1", step, action).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(ran))
+	if !ran && succeeds {
+		mock.ExpectExec("INSERT INTO saga_step_runs \\(saga_id, step, action\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT DO NOTHING").
+			WithArgs("saga-1", step, action).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+func TestAdvance_WaitsThenCompletes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	expectStepRun(mock, "welcome", "do", false, true)
+	expectStepRun(mock, "activate", "do", false, true)
+
+	r := &recorder{}
+	def := testDefinition(r, nil)
+	e := NewEngine(db, def)
+	s := newSaga(def)
+
+	before := time.Now()
+	e.advance(context.Background(), s, def)
+	if s.CurrentStep != 1 || s.Steps[0].Status != StepCompleted || s.Steps[1].Status != StepWaiting {
+		t.Fatalf("expected to wait on step 1, got %+v", s)
+	}
+	if s.StepDeadline == nil || s.StepDeadline.Before(before.Add(time.Minute)) {
+		t.Errorf("expected a deadline a minute out, got %v", s.StepDeadline)
+	}
+
+	if err := e.complete(context.Background(), s, def); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.Status != StatusCompleted || s.CurrentStep != 4 || s.StepDeadline != nil {
+		t.Errorf("expected completed saga, got %+v", s)
+	}
+	if len(r.calls) != 2 || r.calls[0] != "welcome" || r.calls[1] != "activate" {
+		t.Errorf("expected welcome then activate, got %v", r.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvance_SkipsStepsThatAlreadyRan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	// A redelivery after the save failed: welcome went through last time
+	expectStepRun(mock, "welcome", "do", true, true)
+	expectStepRun(mock, "activate", "do", false, true)
+
+	r := &recorder{}
+	def := testDefinition(r, nil)
+	s := newSaga(def)
+	s.CurrentStep = 2
+	s.Steps[0].Status, s.Steps[1].Status = StepCompleted, StepCompleted
+
+	if err := NewEngine(db, def).advance(context.Background(), s, def); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.Status != StatusCompleted {
+		t.Errorf("expected completed saga, got %+v", s)
+	}
+	if len(r.calls) != 1 || r.calls[0] != "activate" {
+		t.Errorf("expected only activate to run, got %v", r.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvance_JournalErrorLeavesSagaToRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM saga_step_runs").WillReturnError(errors.New("db down"))
+
+	r := &recorder{}
+	def := testDefinition(r, nil)
+	s := newSaga(def)
+	s.CurrentStep = 2
+
+	if err := NewEngine(db, def).advance(context.Background(), s, def); err == nil {
+		t.Fatal("expected the journal error")
+	}
+	if len(r.calls) != 0 || s.Steps[2].Status != StepPending {
+		t.Errorf("expected welcome not to run, got %v (%+v)", r.calls, s.Steps[2])
+	}
+}
+
+func TestFail_CompensatesCompletedSteps(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	expectStepRun(mock, "create", "compensate", false, true)
+
+	r := &recorder{}
+	def := testDefinition(r, nil)
+	e := NewEngine(db, def)
+	s := newSaga(def)
+
+	_ = e.advance(context.Background(), s, def)
+	if err := e.fail(context.Background(), s, def, ErrStepTimeout); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if s.Status != StatusCompensated {
+		t.Errorf("expected compensated, got %s", s.Status)
+	}
+	if s.Steps[1].Status != StepFailed || s.Steps[1].Error != ErrStepTimeout.Error() {
+		t.Errorf("expected step 1 failed by timeout, got %+v", s.Steps[1])
+	}
+	if s.Steps[0].Status != StepCompensated || len(r.calls) != 1 || r.calls[0] != "undo create" {
+		t.Errorf("expected step 0 compensated, got %+v (calls %v)", s.Steps[0], r.calls)
+	}
+	if s.Error != "sync: step timed out" {
+		t.Errorf("unexpected saga error %q", s.Error)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestFail_SkipsCompensationThatAlreadyRan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	expectStepRun(mock, "create", "compensate", true, true)
+
+	r := &recorder{}
+	def := testDefinition(r, nil)
+	s := newSaga(def)
+	s.CurrentStep = 1
+	s.Steps[0].Status = StepCompleted
+
+	if err := NewEngine(db, def).fail(context.Background(), s, def, ErrStepTimeout); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if s.Status != StatusCompensated || s.Steps[0].Status != StepCompensated || len(r.calls) != 0 {
+		t.Errorf("expected step 0 compensated without running again, got %+v (calls %v)", s.Steps[0], r.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestFail_CompensationFailureNeedsOperator(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+	expectStepRun(mock, "create", "compensate", false, false)
+
+	r := &recorder{}
+	def := testDefinition(r, errors.New("db down"))
+	e := NewEngine(db, def)
+	s := newSaga(def)
+
+	_ = e.advance(context.Background(), s, def)
+	if err := e.fail(context.Background(), s, def, errors.New("crm rejected")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if s.Status != StatusFailed {
+		t.Errorf("expected failed, got %s", s.Status)
+	}
+	if s.Steps[0].Status != StepCompleted || s.Steps[0].Error != "db down" {
+		t.Errorf("expected step 0 still completed with the compensation error, got %+v", s.Steps[0])
+	}
+}
+
+func TestRoutingKeys(t *testing.T) {
+	keys := NewEngine(nil, testDefinition(&recorder{}, nil)).RoutingKeys()
+	want := []string{"user.created", "crm.contact.synced", "crm.contact.sync_failed"}
+	if len(keys) != len(want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, keys)
+		}
+	}
+}
+
+// stepsArg matches the steps JSON written for a saga.
+type stepsArg struct {
+	statuses []string
+}
+
+func (a stepsArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	var steps []StepState
+	if err := json.Unmarshal(b, &steps); err != nil || len(steps) != len(a.statuses) {
+		return false
+	}
+	for i, st := range steps {
+		if st.Status != a.statuses[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func delivery(t *testing.T, event interface{}) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	return amqp.Delivery{Body: body}
+}
+
+func TestHandleMessage_StartsOnPendingUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO sagas .* ON CONFLICT \\(name, user_id\\) DO NOTHING").
+		WithArgs(sqlmock.AnyArg(), "onboarding", "user-1", "evt-1", "corr-1", StatusRunning, stepsArg{[]string{"pending", "pending", "pending", "pending"}}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sagas SET status = \\$1, current_step = \\$2, steps = \\$3, step_deadline = \\$4").
+		WithArgs(StatusRunning, 1, stepsArg{[]string{"completed", "waiting", "pending", "pending"}}, sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT DISTINCT s.id FROM sagas s JOIN saga_early_events").
+		WithArgs("evt-1", StatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	e := NewEngine(db, testDefinition(&recorder{}, nil))
+	event := models.UserEvent{EventID: "evt-1", CorrelationID: "corr-1", EventType: models.EventUserCreated,
+		Data: models.User{ID: "user-1", Status: models.UserPending}}
+	if err := e.HandleMessage(context.Background(), delivery(t, event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_SkipsActiveUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	e := NewEngine(db, testDefinition(&recorder{}, nil))
+	event := models.UserEvent{EventID: "evt-1", EventType: models.EventUserCreated,
+		Data: models.User{ID: "user-1", Status: models.UserActive}}
+	if err := e.HandleMessage(context.Background(), delivery(t, event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected queries: %v", err)
+	}
+}
+
+var sagaColumnNames = []string{"id", "name", "user_id", "trigger_event_id", "correlation_id", "status", "current_step",
+	"steps", "step_deadline", "error", "created_at", "updated_at"}
+
+func TestHandleMessage_ResultEventAdvancesSaga(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	steps, _ := json.Marshal([]StepState{
+		{Name: "create", Status: StepCompleted}, {Name: "sync", Status: StepWaiting},
+		{Name: "welcome", Status: StepPending}, {Name: "activate", Status: StepPending},
+	})
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT id FROM sagas WHERE trigger_event_id = \\$1").
+		WithArgs("evt-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("saga-1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, .* FROM sagas WHERE id = \\$1 FOR UPDATE").
+		WithArgs("saga-1").
+		WillReturnRows(sqlmock.NewRows(sagaColumnNames).
+			AddRow("saga-1", "onboarding", "user-1", "evt-1", "corr-1", StatusRunning, 1, steps, now.Add(time.Minute), "", now, now))
+	expectStepRun(mock, "welcome", "do", false, true)
+	expectStepRun(mock, "activate", "do", false, true)
+	mock.ExpectExec("UPDATE sagas SET").
+		WithArgs(StatusCompleted, 4, stepsArg{[]string{"completed", "completed", "completed", "completed"}}, sqlmock.AnyArg(), "", "saga-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	r := &recorder{}
+	e := NewEngine(db, testDefinition(r, nil))
+	result := models.ResultEvent{EventID: "res-1", EventType: models.EventCRMContactSynced, CausationID: "evt-1", UserID: "user-1"}
+	if err := e.HandleMessage(context.Background(), delivery(t, result)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.calls) != 2 {
+		t.Errorf("expected welcome and activate to run, got %v", r.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_KeepsEventBeforeSagaStarts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id FROM sagas WHERE trigger_event_id = \\$1").
+		WithArgs("evt-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO saga_early_events .* ON CONFLICT \\(event_id\\) DO NOTHING").
+		WithArgs("res-1", "evt-1", string(models.EventCRMContactSynced), "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT DISTINCT s.id FROM sagas s JOIN saga_early_events").
+		WithArgs("evt-1", StatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	e := NewEngine(db, testDefinition(&recorder{}, nil))
+	result := models.ResultEvent{EventID: "res-1", EventType: models.EventCRMContactSynced, CausationID: "evt-1", UserID: "user-1"}
+	if err := e.HandleMessage(context.Background(), delivery(t, result)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_StartAppliesEarlyEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	steps, _ := json.Marshal([]StepState{
+		{Name: "create", Status: StepCompleted}, {Name: "sync", Status: StepWaiting},
+		{Name: "welcome", Status: StepPending}, {Name: "activate", Status: StepPending},
+	})
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO sagas .* ON CONFLICT \\(name, user_id\\) DO NOTHING").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sagas SET").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT DISTINCT s.id FROM sagas s JOIN saga_early_events").
+		WithArgs("evt-1", StatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("saga-1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, .* FROM sagas WHERE id = \\$1 FOR UPDATE").
+		WithArgs("saga-1").
+		WillReturnRows(sqlmock.NewRows(sagaColumnNames).
+			AddRow("saga-1", "onboarding", "user-1", "evt-1", "corr-1", StatusRunning, 1, steps, now.Add(time.Minute), "", now, now))
+	mock.ExpectQuery("SELECT event_id, event_type, error FROM saga_early_events").
+		WithArgs("evt-1").
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "event_type", "error"}).
+			AddRow("res-1", string(models.EventCRMContactSynced), ""))
+	mock.ExpectExec("DELETE FROM saga_early_events WHERE event_id = \\$1").
+		WithArgs("res-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStepRun(mock, "welcome", "do", false, true)
+	expectStepRun(mock, "activate", "do", false, true)
+	mock.ExpectExec("UPDATE sagas SET").
+		WithArgs(StatusCompleted, 4, stepsArg{[]string{"completed", "completed", "completed", "completed"}}, sqlmock.AnyArg(), "", "saga-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	r := &recorder{}
+	e := NewEngine(db, testDefinition(r, nil))
+	event := models.UserEvent{EventID: "evt-1", CorrelationID: "corr-1", EventType: models.EventUserCreated,
+		Data: models.User{ID: "user-1", Status: models.UserPending}}
+	if err := e.HandleMessage(context.Background(), delivery(t, event)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.calls) != 2 {
+		t.Errorf("expected the kept sync event to run welcome and activate, got %v", r.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestExpireSteps_CompensatesOverdueSaga(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	steps, _ := json.Marshal([]StepState{
+		{Name: "create", Status: StepCompleted}, {Name: "sync", Status: StepWaiting},
+		{Name: "welcome", Status: StepPending}, {Name: "activate", Status: StepPending},
+	})
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT id FROM sagas WHERE status = \\$1 AND step_deadline < \\$2").
+		WithArgs(StatusRunning, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("saga-1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, .* FROM sagas WHERE id = \\$1 FOR UPDATE").
+		WithArgs("saga-1").
+		WillReturnRows(sqlmock.NewRows(sagaColumnNames).
+			AddRow("saga-1", "onboarding", "user-1", "evt-1", "corr-1", StatusRunning, 1, steps, now.Add(-time.Second), "", now, now))
+	expectStepRun(mock, "create", "compensate", false, true)
+	mock.ExpectExec("UPDATE sagas SET").
+		WithArgs(StatusCompensated, 1, stepsArg{[]string{"compensated", "failed", "pending", "pending"}}, nil, "sync: step timed out", "saga-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	r := &recorder{}
+	n, err := NewEngine(db, testDefinition(r, nil)).ExpireSteps(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 1 || len(r.calls) != 1 || r.calls[0] != "undo create" {
+		t.Errorf("expected one saga compensated, got %d (calls %v)", n, r.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
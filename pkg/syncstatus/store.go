@@ -0,0 +1,223 @@
+// Package syncstatus tracks how far each downstream consumer has got with a
+// user's events, fed by the result events the consumers publish.
+package syncstatus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"awesomeProject/pkg/logging"
+	"awesomeProject/pkg/models"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Downstream consumers whose progress is tracked.
+const (
+	DownstreamCRM       = "crm"
+	DownstreamAnalytics = "analytics"
+)
+
+// Downstreams lists the tracked consumers in report order.
+var Downstreams = []string{DownstreamCRM, DownstreamAnalytics}
+
+// RoutingKeys are the result events that feed the store.
+var RoutingKeys = []string{"crm.contact.#", string(models.EventAnalyticsUpdated)}
+
+// Downstream states.
+const (
+	StateSynced  = "synced"  // the user's latest event has been applied
+	StatePending = "pending" // an event is on its way, or none was reported yet
+	StateFailed  = "failed"  // the latest attempt failed and is in the DLQ
+)
+
+// outcome is what a result event says about a downstream.
+type outcome struct {
+	downstream string
+	failed     bool
+}
+
+var outcomes = map[models.EventType]outcome{
+	models.EventCRMContactSynced:     {DownstreamCRM, false},
+	models.EventCRMContactSyncFailed: {DownstreamCRM, true},
+	models.EventAnalyticsUpdated:     {DownstreamAnalytics, false},
+}
+
+// Failure describes the latest event a downstream failed to apply.
+type Failure struct {
+	EventID      string           `json:"event_id"`
+	EventType    models.EventType `json:"event_type"`
+	EventVersion int              `json:"event_version,omitempty"`
+	Error        string           `json:"error"`
+	FailedAt     time.Time        `json:"failed_at"`
+}
+
+// Status is one downstream's progress with a user's events.
+type Status struct {
+	Downstream       string           `json:"downstream"`
+	State            string           `json:"state"`
+	LastEventID      string           `json:"last_event_id,omitempty"`
+	LastEventType    models.EventType `json:"last_event_type,omitempty"`
+	LastEventVersion int              `json:"last_event_version,omitempty"`
+	LastAppliedAt    *time.Time       `json:"last_applied_at,omitempty"`
+	LastFailure      *Failure         `json:"last_failure,omitempty"`
+}
+
+// Report is a user's sync status across all downstreams.
+type Report struct {
+	UserID string `json:"user_id"`
+	// LatestEventID is the user's most recent stored event; empty if none
+	LatestEventID string   `json:"latest_event_id,omitempty"`
+	Downstreams   []Status `json:"downstreams"`
+}
+
+// Store keeps one sync_status row per user and downstream.
+type Store struct {
+	DB *sql.DB
+}
+
+// New creates a new Store.
+func New(db *sql.DB) *Store {
+	return &Store{DB: db}
+}
+
+// Record applies a result event. Events for other downstreams are ignored.
+// Results are ordered by their source event's stream version, then by when
+// they were handled: a result about an earlier event than the one recorded,
+// e.g. from a requeue, replay or DLQ redrive, never overwrites it.
+func (s *Store) Record(ctx context.Context, event models.ResultEvent) error {
+	o, ok := outcomes[event.EventType]
+	if !ok {
+		return nil
+	}
+
+	if o.failed {
+		_, err := s.DB.ExecContext(ctx,
+			`INSERT INTO sync_status (user_id, downstream, failed_event_id, failed_event_type, failed_event_version, failure_error, failed_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			 ON CONFLICT (user_id, downstream) DO UPDATE SET
+				failed_event_id = EXCLUDED.failed_event_id,
+				failed_event_type = EXCLUDED.failed_event_type,
+				failed_event_version = EXCLUDED.failed_event_version,
+				failure_error = EXCLUDED.failure_error,
+				failed_at = EXCLUDED.failed_at,
+				updated_at = NOW()
+			 WHERE sync_status.failed_at IS NULL
+				OR (sync_status.failed_event_version, sync_status.failed_at) <= (EXCLUDED.failed_event_version, EXCLUDED.failed_at)`,
+			event.UserID, o.downstream, event.CausationID, string(event.SourceEventType), event.SourceVersion, event.Error, event.Timestamp,
+		)
+		return err
+	}
+
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO sync_status (user_id, downstream, last_event_id, last_event_type, last_event_version, last_applied_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		 ON CONFLICT (user_id, downstream) DO UPDATE SET
+			last_event_id = EXCLUDED.last_event_id,
+			last_event_type = EXCLUDED.last_event_type,
+			last_event_version = EXCLUDED.last_event_version,
+			last_applied_at = EXCLUDED.last_applied_at,
+			updated_at = NOW()
+		 WHERE sync_status.last_applied_at IS NULL
+			OR (sync_status.last_event_version, sync_status.last_applied_at) <= (EXCLUDED.last_event_version, EXCLUDED.last_applied_at)`,
+		event.UserID, o.downstream, event.CausationID, string(event.SourceEventType), event.SourceVersion, event.Timestamp,
+	)
+	return err
+}
+
+// HandleMessage records a result event delivered from RabbitMQ.
+func (s *Store) HandleMessage(ctx context.Context, delivery amqp.Delivery) error {
+	var event models.ResultEvent
+	if err := json.Unmarshal(delivery.Body, &event); err != nil {
+		logging.FromContext(ctx).Error("failed to unmarshal result event", logging.KeyError, err)
+		return err
+	}
+
+	logger := logging.FromContext(ctx).With(logging.KeyEventID, event.EventID, logging.KeyEventType, event.EventType, logging.KeyUserID, event.UserID)
+	if err := s.Record(ctx, event); err != nil {
+		logger.Error("error recording sync status", logging.KeyError, err)
+		return err
+	}
+	logger.Debug("sync status recorded", "causation_id", event.CausationID)
+	return nil
+}
+
+// Report returns userID's status for every downstream. latestEventID is
+// the user's most recent event: a downstream is synced once it has applied
+// it, failed if its last failure is about a later event than its last
+// success (or the same event, retried since), and pending otherwise.
+func (s *Store) Report(ctx context.Context, userID, latestEventID string) (Report, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT downstream, last_event_id, last_event_type, last_event_version, last_applied_at,
+			failed_event_id, failed_event_type, failed_event_version, failure_error, failed_at
+		 FROM sync_status WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return Report{}, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]Status)
+	for rows.Next() {
+		var st Status
+		var lastID, lastType, failedID, failedType, failureErr sql.NullString
+		var failedVersion int
+		var appliedAt, failedAt sql.NullTime
+		if err := rows.Scan(&st.Downstream, &lastID, &lastType, &st.LastEventVersion, &appliedAt,
+			&failedID, &failedType, &failedVersion, &failureErr, &failedAt); err != nil {
+			return Report{}, err
+		}
+		st.LastEventID, st.LastEventType = lastID.String, models.EventType(lastType.String)
+		if appliedAt.Valid {
+			st.LastAppliedAt = &appliedAt.Time
+		}
+		if failedAt.Valid {
+			st.LastFailure = &Failure{
+				EventID:      failedID.String,
+				EventType:    models.EventType(failedType.String),
+				EventVersion: failedVersion,
+				Error:        failureErr.String,
+				FailedAt:     failedAt.Time,
+			}
+		}
+		found[st.Downstream] = st
+	}
+	if err := rows.Err(); err != nil {
+		return Report{}, err
+	}
+
+	report := Report{UserID: userID, LatestEventID: latestEventID, Downstreams: make([]Status, 0, len(Downstreams))}
+	for _, d := range Downstreams {
+		st, ok := found[d]
+		if !ok {
+			st = Status{Downstream: d}
+		}
+		st.State = state(st, latestEventID)
+		report.Downstreams = append(report.Downstreams, st)
+	}
+	return report, nil
+}
+
+func state(st Status, latestEventID string) string {
+	switch {
+	case st.LastFailure != nil && (st.LastAppliedAt == nil || failedSince(st)):
+		return StateFailed
+	case st.LastAppliedAt != nil && (latestEventID == "" || st.LastEventID == latestEventID):
+		return StateSynced
+	default:
+		return StatePending
+	}
+}
+
+// failedSince reports whether st's last failure comes after its last
+// success in stream order.
+func failedSince(st Status) bool {
+	f := st.LastFailure
+	if f.EventVersion != st.LastEventVersion {
+		return f.EventVersion > st.LastEventVersion
+	}
+	return f.FailedAt.After(*st.LastAppliedAt)
+}
@@ -0,0 +1,189 @@
+package syncstatus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"awesomeProject/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRecord_Synced(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectExec("INSERT INTO sync_status \\(user_id, downstream, last_event_id, .*WHERE sync_status.last_applied_at IS NULL\\s+OR \\(sync_status.last_event_version, sync_status.last_applied_at\\) <= \\(EXCLUDED.last_event_version, EXCLUDED.last_applied_at\\)").
+		WithArgs("user-1", "crm", "evt-1", "user.created", 1, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = New(db).Record(context.Background(), models.ResultEvent{
+		EventType: models.EventCRMContactSynced, CausationID: "evt-1", Timestamp: now,
+		UserID: "user-1", SourceEventType: models.EventUserCreated, SourceVersion: 1,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHandleMessage_RecordsFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	mock.ExpectExec("INSERT INTO sync_status \\(user_id, downstream, failed_event_id, .*WHERE sync_status.failed_at IS NULL\\s+OR \\(sync_status.failed_event_version, sync_status.failed_at\\) <= \\(EXCLUDED.failed_event_version, EXCLUDED.failed_at\\)").
+		WithArgs("user-1", "crm", "evt-1", "user.updated", 2, "disk full", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body, _ := json.Marshal(models.ResultEvent{
+		EventID: "res-1", EventType: models.EventCRMContactSyncFailed, CausationID: "evt-1", Timestamp: now,
+		UserID: "user-1", SourceEventType: models.EventUserUpdated, SourceVersion: 2, Error: "disk full",
+	})
+	if err := New(db).HandleMessage(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRecord_OlderEventAfterNewer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// The result for evt-1 (version 1) is handled after the one for evt-2
+	// (version 2), e.g. requeued or replayed. The version guard is what
+	// keeps it from overwriting evt-2, so the older event's write matches
+	// no row and leaves evt-2 recorded.
+	now := time.Now()
+	for _, r := range []struct {
+		id      string
+		version int
+		at      time.Time
+		rows    int64
+	}{
+		{"evt-2", 2, now, 1},
+		{"evt-1", 1, now.Add(time.Second), 0},
+	} {
+		mock.ExpectExec("INSERT INTO sync_status .*\\(sync_status.last_event_version, sync_status.last_applied_at\\) <= \\(EXCLUDED.last_event_version, EXCLUDED.last_applied_at\\)").
+			WithArgs("user-1", "crm", r.id, "user.updated", r.version, r.at).
+			WillReturnResult(sqlmock.NewResult(0, r.rows))
+	}
+
+	store := New(db)
+	for _, e := range []models.ResultEvent{
+		{EventType: models.EventCRMContactSynced, CausationID: "evt-2", Timestamp: now, UserID: "user-1", SourceEventType: models.EventUserUpdated, SourceVersion: 2},
+		{EventType: models.EventCRMContactSynced, CausationID: "evt-1", Timestamp: now.Add(time.Second), UserID: "user-1", SourceEventType: models.EventUserUpdated, SourceVersion: 1},
+	} {
+		if err := store.Record(context.Background(), e); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+
+	// Reported from the row, evt-2 stays the applied event and the user is synced
+	st := Status{LastEventID: "evt-2", LastEventVersion: 2, LastAppliedAt: &now}
+	if got := state(st, "evt-2"); got != StateSynced {
+		t.Errorf("expected synced, got %s", got)
+	}
+}
+
+func TestRecord_IgnoresOtherEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	if err := New(db).Record(context.Background(), models.ResultEvent{EventType: "billing.invoiced"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected queries: %v", err)
+	}
+}
+
+func TestReport_States(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	earlier := time.Now().Add(-time.Hour)
+	later := time.Now()
+	mock.ExpectQuery("SELECT downstream, .* FROM sync_status WHERE user_id = \\$1").
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"downstream", "last_event_id", "last_event_type", "last_event_version", "last_applied_at",
+			"failed_event_id", "failed_event_type", "failed_event_version", "failure_error", "failed_at"}).
+			AddRow("crm", "evt-1", "user.created", 1, earlier, "evt-2", "user.updated", 2, "disk full", later).
+			AddRow("analytics", "evt-1", "user.created", 1, earlier, nil, nil, 0, nil, nil))
+
+	report, err := New(db).Report(context.Background(), "user-1", "evt-2")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(report.Downstreams) != 2 {
+		t.Fatalf("expected 2 downstreams, got %+v", report.Downstreams)
+	}
+
+	crm := report.Downstreams[0]
+	if crm.Downstream != DownstreamCRM || crm.State != StateFailed {
+		t.Errorf("expected crm failed, got %+v", crm)
+	}
+	if crm.LastFailure == nil || crm.LastFailure.EventID != "evt-2" || crm.LastFailure.Error != "disk full" {
+		t.Errorf("expected the evt-2 failure, got %+v", crm.LastFailure)
+	}
+
+	// Analytics has applied an older event and not yet the latest
+	if an := report.Downstreams[1]; an.Downstream != DownstreamAnalytics || an.State != StatePending || an.LastEventID != "evt-1" {
+		t.Errorf("expected analytics pending at evt-1, got %+v", an)
+	}
+}
+
+func TestState(t *testing.T) {
+	earlier := time.Now().Add(-time.Hour)
+	later := time.Now()
+
+	tests := []struct {
+		name   string
+		st     Status
+		latest string
+		want   string
+	}{
+		{"nothing reported", Status{}, "evt-1", StatePending},
+		{"latest applied", Status{LastEventID: "evt-1", LastAppliedAt: &later}, "evt-1", StateSynced},
+		{"no stored events", Status{LastEventID: "evt-1", LastAppliedAt: &later}, "", StateSynced},
+		{"behind", Status{LastEventID: "evt-1", LastAppliedAt: &later}, "evt-2", StatePending},
+		{"failed after success", Status{LastEventID: "evt-1", LastEventVersion: 1, LastAppliedAt: &earlier, LastFailure: &Failure{EventVersion: 2, FailedAt: later}}, "evt-2", StateFailed},
+		{"success after failure", Status{LastEventID: "evt-2", LastEventVersion: 2, LastAppliedAt: &later, LastFailure: &Failure{EventVersion: 2, FailedAt: earlier}}, "evt-2", StateSynced},
+		// A redriven older event failing again doesn't undo the later success
+		{"older event failed later", Status{LastEventID: "evt-2", LastEventVersion: 2, LastAppliedAt: &earlier, LastFailure: &Failure{EventVersion: 1, FailedAt: later}}, "evt-2", StateSynced},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := state(tt.st, tt.latest); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}